@@ -0,0 +1,762 @@
+package wasm
+
+import "fmt"
+
+// An opcode. Opcodes with a prefix byte are encoded as the prefix in the
+// highest byte and the subopcode in the lower bytes.
+type Opcode uint32
+
+// Prefixes of the opcodes that are encoded on multiple bytes
+const (
+	PrefixMisc = 0xfc // Saturating truncations, bulk memory and table operations
+	PrefixSIMD = 0xfd
+	PrefixThreads = 0xfe
+)
+
+const (
+	OpBlock Opcode = 0x02
+	OpLoop Opcode = 0x03
+	OpIf Opcode = 0x04
+	OpTry Opcode = 0x06
+	OpEnd Opcode = 0x0b
+)
+
+func (op Opcode) String() string {
+	if name, present := opcodeNames[op]; present {
+		return name
+	}
+	if op > 0xff {
+		return fmt.Sprintf("0x%02x-0x%x", byte(op >> 24), uint32(op & 0xffffff))
+	}
+	return fmt.Sprintf("0x%02x", byte(op))
+}
+
+// A decoded instruction
+type Instruction struct {
+	Opcode Opcode
+	Offset int // Offset of the instruction in the binary
+	Immediates []uint64 // Indices, alignments, offsets, lanes, and constants (signed ones are sign extended)
+	Bytes []byte // Raw immediates of v128.const and i8x16.shuffle
+}
+
+// Decode a sequence of instructions, such as a function body.
+// offset is the offset of code in the binary, used in errors and in the decoded instructions.
+func DecodeInstructions(code []byte, offset int) ([]Instruction, error) {
+	instructions := make([]Instruction, 0, len(code) / 2)
+	err := WalkInstructions(code, offset, func(instr Instruction) error {
+		instructions = append(instructions, instr)
+		return nil
+	})
+	return instructions, err
+}
+
+// Call visit on each instruction of a sequence of instructions, without
+// keeping them all in memory. Stops at the first error returned by visit.
+func WalkInstructions(code []byte, offset int, visit func(Instruction) error) error {
+	r := newReader(code, offset)
+	for !r.eof() {
+		instr, err := decodeInstruction(r)
+		if err != nil {
+			return err
+		}
+		if err := visit(instr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode a constant expression, up to and including its end instruction.
+// Returns the instructions without the final end.
+func decodeConstExpr(r *reader) ([]Instruction, error) {
+	instructions := make([]Instruction, 0, 1)
+	for {
+		instr, err := decodeInstruction(r)
+		if err != nil {
+			return nil, err
+		}
+		if instr.Opcode == OpEnd {
+			return instructions, nil
+		}
+		instructions = append(instructions, instr)
+	}
+}
+
+func decodeInstruction(r *reader) (Instruction, error) {
+	instr := Instruction{Offset: r.offset()}
+	b, err := r.byte()
+	if err != nil {
+		return instr, err
+	}
+	instr.Opcode = Opcode(b)
+	// Read count unsigned immediates
+	u32s := func(count int) error {
+		for i := 0; i < count; i++ {
+			v, err := r.u32()
+			if err != nil {
+				return err
+			}
+			instr.Immediates = append(instr.Immediates, uint64(v))
+		}
+		return nil
+	}
+	// Read the alignment (with its optional memory index) and the offset of a memory access
+	memarg := func() error {
+		align, err := r.u32()
+		if err != nil {
+			return err
+		}
+		memory := uint32(0)
+		if align & 0x40 != 0 {
+			// Multiple memories proposal: the memory index follows
+			align &^= 0x40
+			if memory, err = r.u32(); err != nil {
+				return err
+			}
+		}
+		offset, err := r.u64()
+		if err != nil {
+			return err
+		}
+		instr.Immediates = append(instr.Immediates, uint64(align), offset, uint64(memory))
+		return nil
+	}
+	// Read a block type, encoded as a signed 33-bit integer: a type index when positive,
+	// otherwise the empty type (-64, 0x40) or a value type (e.g., -1 for i32, 0x7f)
+	blockType := func() error {
+		v, err := r.sleb(33)
+		instr.Immediates = append(instr.Immediates, uint64(v))
+		return err
+	}
+	switch {
+	case b == 0x02 || b == 0x03 || b == 0x04 || b == 0x06:
+		err = blockType()
+	case b == 0x07 || b == 0x08 || b == 0x09 || b == 0x0c || b == 0x0d || b == 0x10 || b == 0x12 || b == 0x14 || b == 0x15 || b == 0x18:
+		err = u32s(1)
+	case b == 0x0e:
+		// br_table: a vector of labels and a default label
+		var n int
+		if n, err = r.count(); err == nil {
+			err = u32s(n + 1)
+		}
+	case b == 0x11 || b == 0x13:
+		err = u32s(2)
+	case b == 0x1c:
+		// select with explicit types
+		var n int
+		if n, err = r.count(); err == nil {
+			for i := 0; i < n && err == nil; i++ {
+				var t byte
+				t, err = r.byte()
+				instr.Immediates = append(instr.Immediates, uint64(t))
+			}
+		}
+	case b >= 0x20 && b <= 0x26:
+		err = u32s(1)
+	case b >= 0x28 && b <= 0x3e:
+		err = memarg()
+	case b == 0x3f || b == 0x40:
+		err = u32s(1)
+	case b == 0x41:
+		var v int64
+		v, err = r.sleb(32)
+		instr.Immediates = append(instr.Immediates, uint64(v))
+	case b == 0x42:
+		var v int64
+		v, err = r.sleb(64)
+		instr.Immediates = append(instr.Immediates, uint64(v))
+	case b == 0x43:
+		var v []byte
+		v, err = r.bytes(4)
+		instr.Bytes = v
+	case b == 0x44:
+		var v []byte
+		v, err = r.bytes(8)
+		instr.Bytes = v
+	case b == 0xd0:
+		// ref.null: a heap type, encoded like a block type
+		err = blockType()
+	case b == 0xd2:
+		err = u32s(1)
+	case b == PrefixMisc || b == PrefixSIMD || b == PrefixThreads:
+		var sub uint32
+		if sub, err = r.u32(); err != nil {
+			break
+		}
+		instr.Opcode = Opcode(uint32(b) << 24 | sub)
+		err = decodePrefixedImmediates(r, &instr, b, sub, u32s, memarg)
+	default:
+		if _, present := opcodeNames[instr.Opcode]; !present {
+			err = r.errorf("unknown opcode 0x%02x", b)
+		}
+	}
+	if err != nil {
+		return instr, err
+	}
+	return instr, nil
+}
+
+func decodePrefixedImmediates(r *reader, instr *Instruction, prefix byte, sub uint32, u32s func(int) error, memarg func() error) error {
+	if _, present := opcodeNames[instr.Opcode]; !present {
+		return r.errorf("unknown opcode %s", instr.Opcode.String())
+	}
+	switch prefix {
+	case PrefixMisc:
+		switch {
+		case sub <= 7:
+			return nil
+		case sub == 8 || sub == 10 || sub == 12 || sub == 14:
+			return u32s(2)
+		default:
+			return u32s(1)
+		}
+	case PrefixSIMD:
+		switch {
+		case sub <= 11 || sub == 92 || sub == 93:
+			return memarg()
+		case sub == 12 || sub == 13:
+			b, err := r.bytes(16)
+			instr.Bytes = b
+			return err
+		case sub >= 21 && sub <= 34:
+			lane, err := r.byte()
+			instr.Immediates = append(instr.Immediates, uint64(lane))
+			return err
+		case sub >= 84 && sub <= 91:
+			if err := memarg(); err != nil {
+				return err
+			}
+			lane, err := r.byte()
+			instr.Immediates = append(instr.Immediates, uint64(lane))
+			return err
+		}
+		return nil
+	case PrefixThreads:
+		if sub == 3 {
+			// atomic.fence has a reserved byte
+			_, err := r.byte()
+			return err
+		}
+		return memarg()
+	}
+	return nil
+}
+
+// Names of the opcodes, as in the text format
+var opcodeNames = map[Opcode]string{
+	0x00: "unreachable",
+	0x01: "nop",
+	0x02: "block",
+	0x03: "loop",
+	0x04: "if",
+	0x05: "else",
+	0x06: "try",
+	0x07: "catch",
+	0x08: "throw",
+	0x09: "rethrow",
+	0x0a: "throw_ref",
+	0x0b: "end",
+	0x0c: "br",
+	0x0d: "br_if",
+	0x0e: "br_table",
+	0x0f: "return",
+	0x10: "call",
+	0x11: "call_indirect",
+	0x12: "return_call",
+	0x13: "return_call_indirect",
+	0x14: "call_ref",
+	0x15: "return_call_ref",
+	0x18: "delegate",
+	0x19: "catch_all",
+	0x1a: "drop",
+	0x1b: "select",
+	0x1c: "select",
+	0x20: "local.get",
+	0x21: "local.set",
+	0x22: "local.tee",
+	0x23: "global.get",
+	0x24: "global.set",
+	0x25: "table.get",
+	0x26: "table.set",
+	0x28: "i32.load",
+	0x29: "i64.load",
+	0x2a: "f32.load",
+	0x2b: "f64.load",
+	0x2c: "i32.load8_s",
+	0x2d: "i32.load8_u",
+	0x2e: "i32.load16_s",
+	0x2f: "i32.load16_u",
+	0x30: "i64.load8_s",
+	0x31: "i64.load8_u",
+	0x32: "i64.load16_s",
+	0x33: "i64.load16_u",
+	0x34: "i64.load32_s",
+	0x35: "i64.load32_u",
+	0x36: "i32.store",
+	0x37: "i64.store",
+	0x38: "f32.store",
+	0x39: "f64.store",
+	0x3a: "i32.store8",
+	0x3b: "i32.store16",
+	0x3c: "i64.store8",
+	0x3d: "i64.store16",
+	0x3e: "i64.store32",
+	0x3f: "memory.size",
+	0x40: "memory.grow",
+	0x41: "i32.const",
+	0x42: "i64.const",
+	0x43: "f32.const",
+	0x44: "f64.const",
+	0x45: "i32.eqz",
+	0x46: "i32.eq",
+	0x47: "i32.ne",
+	0x48: "i32.lt_s",
+	0x49: "i32.lt_u",
+	0x4a: "i32.gt_s",
+	0x4b: "i32.gt_u",
+	0x4c: "i32.le_s",
+	0x4d: "i32.le_u",
+	0x4e: "i32.ge_s",
+	0x4f: "i32.ge_u",
+	0x50: "i64.eqz",
+	0x51: "i64.eq",
+	0x52: "i64.ne",
+	0x53: "i64.lt_s",
+	0x54: "i64.lt_u",
+	0x55: "i64.gt_s",
+	0x56: "i64.gt_u",
+	0x57: "i64.le_s",
+	0x58: "i64.le_u",
+	0x59: "i64.ge_s",
+	0x5a: "i64.ge_u",
+	0x5b: "f32.eq",
+	0x5c: "f32.ne",
+	0x5d: "f32.lt",
+	0x5e: "f32.gt",
+	0x5f: "f32.le",
+	0x60: "f32.ge",
+	0x61: "f64.eq",
+	0x62: "f64.ne",
+	0x63: "f64.lt",
+	0x64: "f64.gt",
+	0x65: "f64.le",
+	0x66: "f64.ge",
+	0x67: "i32.clz",
+	0x68: "i32.ctz",
+	0x69: "i32.popcnt",
+	0x6a: "i32.add",
+	0x6b: "i32.sub",
+	0x6c: "i32.mul",
+	0x6d: "i32.div_s",
+	0x6e: "i32.div_u",
+	0x6f: "i32.rem_s",
+	0x70: "i32.rem_u",
+	0x71: "i32.and",
+	0x72: "i32.or",
+	0x73: "i32.xor",
+	0x74: "i32.shl",
+	0x75: "i32.shr_s",
+	0x76: "i32.shr_u",
+	0x77: "i32.rotl",
+	0x78: "i32.rotr",
+	0x79: "i64.clz",
+	0x7a: "i64.ctz",
+	0x7b: "i64.popcnt",
+	0x7c: "i64.add",
+	0x7d: "i64.sub",
+	0x7e: "i64.mul",
+	0x7f: "i64.div_s",
+	0x80: "i64.div_u",
+	0x81: "i64.rem_s",
+	0x82: "i64.rem_u",
+	0x83: "i64.and",
+	0x84: "i64.or",
+	0x85: "i64.xor",
+	0x86: "i64.shl",
+	0x87: "i64.shr_s",
+	0x88: "i64.shr_u",
+	0x89: "i64.rotl",
+	0x8a: "i64.rotr",
+	0x8b: "f32.abs",
+	0x8c: "f32.neg",
+	0x8d: "f32.ceil",
+	0x8e: "f32.floor",
+	0x8f: "f32.trunc",
+	0x90: "f32.nearest",
+	0x91: "f32.sqrt",
+	0x92: "f32.add",
+	0x93: "f32.sub",
+	0x94: "f32.mul",
+	0x95: "f32.div",
+	0x96: "f32.min",
+	0x97: "f32.max",
+	0x98: "f32.copysign",
+	0x99: "f64.abs",
+	0x9a: "f64.neg",
+	0x9b: "f64.ceil",
+	0x9c: "f64.floor",
+	0x9d: "f64.trunc",
+	0x9e: "f64.nearest",
+	0x9f: "f64.sqrt",
+	0xa0: "f64.add",
+	0xa1: "f64.sub",
+	0xa2: "f64.mul",
+	0xa3: "f64.div",
+	0xa4: "f64.min",
+	0xa5: "f64.max",
+	0xa6: "f64.copysign",
+	0xa7: "i32.wrap_i64",
+	0xa8: "i32.trunc_f32_s",
+	0xa9: "i32.trunc_f32_u",
+	0xaa: "i32.trunc_f64_s",
+	0xab: "i32.trunc_f64_u",
+	0xac: "i64.extend_i32_s",
+	0xad: "i64.extend_i32_u",
+	0xae: "i64.trunc_f32_s",
+	0xaf: "i64.trunc_f32_u",
+	0xb0: "i64.trunc_f64_s",
+	0xb1: "i64.trunc_f64_u",
+	0xb2: "f32.convert_i32_s",
+	0xb3: "f32.convert_i32_u",
+	0xb4: "f32.convert_i64_s",
+	0xb5: "f32.convert_i64_u",
+	0xb6: "f32.demote_f64",
+	0xb7: "f64.convert_i32_s",
+	0xb8: "f64.convert_i32_u",
+	0xb9: "f64.convert_i64_s",
+	0xba: "f64.convert_i64_u",
+	0xbb: "f64.promote_f32",
+	0xbc: "i32.reinterpret_f32",
+	0xbd: "i64.reinterpret_f64",
+	0xbe: "f32.reinterpret_i32",
+	0xbf: "f64.reinterpret_i64",
+	0xc0: "i32.extend8_s",
+	0xc1: "i32.extend16_s",
+	0xc2: "i64.extend8_s",
+	0xc3: "i64.extend16_s",
+	0xc4: "i64.extend32_s",
+	0xd0: "ref.null",
+	0xd1: "ref.is_null",
+	0xd2: "ref.func",
+	PrefixMisc << 24 | 0: "i32.trunc_sat_f32_s",
+	PrefixMisc << 24 | 1: "i32.trunc_sat_f32_u",
+	PrefixMisc << 24 | 2: "i32.trunc_sat_f64_s",
+	PrefixMisc << 24 | 3: "i32.trunc_sat_f64_u",
+	PrefixMisc << 24 | 4: "i64.trunc_sat_f32_s",
+	PrefixMisc << 24 | 5: "i64.trunc_sat_f32_u",
+	PrefixMisc << 24 | 6: "i64.trunc_sat_f64_s",
+	PrefixMisc << 24 | 7: "i64.trunc_sat_f64_u",
+	PrefixMisc << 24 | 8: "memory.init",
+	PrefixMisc << 24 | 9: "data.drop",
+	PrefixMisc << 24 | 10: "memory.copy",
+	PrefixMisc << 24 | 11: "memory.fill",
+	PrefixMisc << 24 | 12: "table.init",
+	PrefixMisc << 24 | 13: "elem.drop",
+	PrefixMisc << 24 | 14: "table.copy",
+	PrefixMisc << 24 | 15: "table.grow",
+	PrefixMisc << 24 | 16: "table.size",
+	PrefixMisc << 24 | 17: "table.fill",
+	PrefixSIMD << 24 | 0: "v128.load",
+	PrefixSIMD << 24 | 1: "v128.load8x8_s",
+	PrefixSIMD << 24 | 2: "v128.load8x8_u",
+	PrefixSIMD << 24 | 3: "v128.load16x4_s",
+	PrefixSIMD << 24 | 4: "v128.load16x4_u",
+	PrefixSIMD << 24 | 5: "v128.load32x2_s",
+	PrefixSIMD << 24 | 6: "v128.load32x2_u",
+	PrefixSIMD << 24 | 7: "v128.load8_splat",
+	PrefixSIMD << 24 | 8: "v128.load16_splat",
+	PrefixSIMD << 24 | 9: "v128.load32_splat",
+	PrefixSIMD << 24 | 10: "v128.load64_splat",
+	PrefixSIMD << 24 | 11: "v128.store",
+	PrefixSIMD << 24 | 12: "v128.const",
+	PrefixSIMD << 24 | 13: "i8x16.shuffle",
+	PrefixSIMD << 24 | 14: "i8x16.swizzle",
+	PrefixSIMD << 24 | 15: "i8x16.splat",
+	PrefixSIMD << 24 | 16: "i16x8.splat",
+	PrefixSIMD << 24 | 17: "i32x4.splat",
+	PrefixSIMD << 24 | 18: "i64x2.splat",
+	PrefixSIMD << 24 | 19: "f32x4.splat",
+	PrefixSIMD << 24 | 20: "f64x2.splat",
+	PrefixSIMD << 24 | 21: "i8x16.extract_lane_s",
+	PrefixSIMD << 24 | 22: "i8x16.extract_lane_u",
+	PrefixSIMD << 24 | 23: "i8x16.replace_lane",
+	PrefixSIMD << 24 | 24: "i16x8.extract_lane_s",
+	PrefixSIMD << 24 | 25: "i16x8.extract_lane_u",
+	PrefixSIMD << 24 | 26: "i16x8.replace_lane",
+	PrefixSIMD << 24 | 27: "i32x4.extract_lane",
+	PrefixSIMD << 24 | 28: "i32x4.replace_lane",
+	PrefixSIMD << 24 | 29: "i64x2.extract_lane",
+	PrefixSIMD << 24 | 30: "i64x2.replace_lane",
+	PrefixSIMD << 24 | 31: "f32x4.extract_lane",
+	PrefixSIMD << 24 | 32: "f32x4.replace_lane",
+	PrefixSIMD << 24 | 33: "f64x2.extract_lane",
+	PrefixSIMD << 24 | 34: "f64x2.replace_lane",
+	PrefixSIMD << 24 | 35: "i8x16.eq",
+	PrefixSIMD << 24 | 36: "i8x16.ne",
+	PrefixSIMD << 24 | 37: "i8x16.lt_s",
+	PrefixSIMD << 24 | 38: "i8x16.lt_u",
+	PrefixSIMD << 24 | 39: "i8x16.gt_s",
+	PrefixSIMD << 24 | 40: "i8x16.gt_u",
+	PrefixSIMD << 24 | 41: "i8x16.le_s",
+	PrefixSIMD << 24 | 42: "i8x16.le_u",
+	PrefixSIMD << 24 | 43: "i8x16.ge_s",
+	PrefixSIMD << 24 | 44: "i8x16.ge_u",
+	PrefixSIMD << 24 | 45: "i16x8.eq",
+	PrefixSIMD << 24 | 46: "i16x8.ne",
+	PrefixSIMD << 24 | 47: "i16x8.lt_s",
+	PrefixSIMD << 24 | 48: "i16x8.lt_u",
+	PrefixSIMD << 24 | 49: "i16x8.gt_s",
+	PrefixSIMD << 24 | 50: "i16x8.gt_u",
+	PrefixSIMD << 24 | 51: "i16x8.le_s",
+	PrefixSIMD << 24 | 52: "i16x8.le_u",
+	PrefixSIMD << 24 | 53: "i16x8.ge_s",
+	PrefixSIMD << 24 | 54: "i16x8.ge_u",
+	PrefixSIMD << 24 | 55: "i32x4.eq",
+	PrefixSIMD << 24 | 56: "i32x4.ne",
+	PrefixSIMD << 24 | 57: "i32x4.lt_s",
+	PrefixSIMD << 24 | 58: "i32x4.lt_u",
+	PrefixSIMD << 24 | 59: "i32x4.gt_s",
+	PrefixSIMD << 24 | 60: "i32x4.gt_u",
+	PrefixSIMD << 24 | 61: "i32x4.le_s",
+	PrefixSIMD << 24 | 62: "i32x4.le_u",
+	PrefixSIMD << 24 | 63: "i32x4.ge_s",
+	PrefixSIMD << 24 | 64: "i32x4.ge_u",
+	PrefixSIMD << 24 | 65: "f32x4.eq",
+	PrefixSIMD << 24 | 66: "f32x4.ne",
+	PrefixSIMD << 24 | 67: "f32x4.lt",
+	PrefixSIMD << 24 | 68: "f32x4.gt",
+	PrefixSIMD << 24 | 69: "f32x4.le",
+	PrefixSIMD << 24 | 70: "f32x4.ge",
+	PrefixSIMD << 24 | 71: "f64x2.eq",
+	PrefixSIMD << 24 | 72: "f64x2.ne",
+	PrefixSIMD << 24 | 73: "f64x2.lt",
+	PrefixSIMD << 24 | 74: "f64x2.gt",
+	PrefixSIMD << 24 | 75: "f64x2.le",
+	PrefixSIMD << 24 | 76: "f64x2.ge",
+	PrefixSIMD << 24 | 77: "v128.not",
+	PrefixSIMD << 24 | 78: "v128.and",
+	PrefixSIMD << 24 | 79: "v128.andnot",
+	PrefixSIMD << 24 | 80: "v128.or",
+	PrefixSIMD << 24 | 81: "v128.xor",
+	PrefixSIMD << 24 | 82: "v128.bitselect",
+	PrefixSIMD << 24 | 83: "v128.any_true",
+	PrefixSIMD << 24 | 84: "v128.load8_lane",
+	PrefixSIMD << 24 | 85: "v128.load16_lane",
+	PrefixSIMD << 24 | 86: "v128.load32_lane",
+	PrefixSIMD << 24 | 87: "v128.load64_lane",
+	PrefixSIMD << 24 | 88: "v128.store8_lane",
+	PrefixSIMD << 24 | 89: "v128.store16_lane",
+	PrefixSIMD << 24 | 90: "v128.store32_lane",
+	PrefixSIMD << 24 | 91: "v128.store64_lane",
+	PrefixSIMD << 24 | 92: "v128.load32_zero",
+	PrefixSIMD << 24 | 93: "v128.load64_zero",
+	PrefixSIMD << 24 | 94: "f32x4.demote_f64x2_zero",
+	PrefixSIMD << 24 | 95: "f64x2.promote_low_f32x4",
+	PrefixSIMD << 24 | 96: "i8x16.abs",
+	PrefixSIMD << 24 | 97: "i8x16.neg",
+	PrefixSIMD << 24 | 98: "i8x16.popcnt",
+	PrefixSIMD << 24 | 99: "i8x16.all_true",
+	PrefixSIMD << 24 | 100: "i8x16.bitmask",
+	PrefixSIMD << 24 | 101: "i8x16.narrow_i16x8_s",
+	PrefixSIMD << 24 | 102: "i8x16.narrow_i16x8_u",
+	PrefixSIMD << 24 | 103: "f32x4.ceil",
+	PrefixSIMD << 24 | 104: "f32x4.floor",
+	PrefixSIMD << 24 | 105: "f32x4.trunc",
+	PrefixSIMD << 24 | 106: "f32x4.nearest",
+	PrefixSIMD << 24 | 107: "i8x16.shl",
+	PrefixSIMD << 24 | 108: "i8x16.shr_s",
+	PrefixSIMD << 24 | 109: "i8x16.shr_u",
+	PrefixSIMD << 24 | 110: "i8x16.add",
+	PrefixSIMD << 24 | 111: "i8x16.add_sat_s",
+	PrefixSIMD << 24 | 112: "i8x16.add_sat_u",
+	PrefixSIMD << 24 | 113: "i8x16.sub",
+	PrefixSIMD << 24 | 114: "i8x16.sub_sat_s",
+	PrefixSIMD << 24 | 115: "i8x16.sub_sat_u",
+	PrefixSIMD << 24 | 116: "f64x2.ceil",
+	PrefixSIMD << 24 | 117: "f64x2.floor",
+	PrefixSIMD << 24 | 118: "i8x16.min_s",
+	PrefixSIMD << 24 | 119: "i8x16.min_u",
+	PrefixSIMD << 24 | 120: "i8x16.max_s",
+	PrefixSIMD << 24 | 121: "i8x16.max_u",
+	PrefixSIMD << 24 | 122: "f64x2.trunc",
+	PrefixSIMD << 24 | 123: "i8x16.avgr_u",
+	PrefixSIMD << 24 | 124: "i16x8.extadd_pairwise_i8x16_s",
+	PrefixSIMD << 24 | 125: "i16x8.extadd_pairwise_i8x16_u",
+	PrefixSIMD << 24 | 126: "i32x4.extadd_pairwise_i16x8_s",
+	PrefixSIMD << 24 | 127: "i32x4.extadd_pairwise_i16x8_u",
+	PrefixSIMD << 24 | 128: "i16x8.abs",
+	PrefixSIMD << 24 | 129: "i16x8.neg",
+	PrefixSIMD << 24 | 130: "i16x8.q15mulr_sat_s",
+	PrefixSIMD << 24 | 131: "i16x8.all_true",
+	PrefixSIMD << 24 | 132: "i16x8.bitmask",
+	PrefixSIMD << 24 | 133: "i16x8.narrow_i32x4_s",
+	PrefixSIMD << 24 | 134: "i16x8.narrow_i32x4_u",
+	PrefixSIMD << 24 | 135: "i16x8.extend_low_i8x16_s",
+	PrefixSIMD << 24 | 136: "i16x8.extend_high_i8x16_s",
+	PrefixSIMD << 24 | 137: "i16x8.extend_low_i8x16_u",
+	PrefixSIMD << 24 | 138: "i16x8.extend_high_i8x16_u",
+	PrefixSIMD << 24 | 139: "i16x8.shl",
+	PrefixSIMD << 24 | 140: "i16x8.shr_s",
+	PrefixSIMD << 24 | 141: "i16x8.shr_u",
+	PrefixSIMD << 24 | 142: "i16x8.add",
+	PrefixSIMD << 24 | 143: "i16x8.add_sat_s",
+	PrefixSIMD << 24 | 144: "i16x8.add_sat_u",
+	PrefixSIMD << 24 | 145: "i16x8.sub",
+	PrefixSIMD << 24 | 146: "i16x8.sub_sat_s",
+	PrefixSIMD << 24 | 147: "i16x8.sub_sat_u",
+	PrefixSIMD << 24 | 148: "f64x2.nearest",
+	PrefixSIMD << 24 | 149: "i16x8.mul",
+	PrefixSIMD << 24 | 150: "i16x8.min_s",
+	PrefixSIMD << 24 | 151: "i16x8.min_u",
+	PrefixSIMD << 24 | 152: "i16x8.max_s",
+	PrefixSIMD << 24 | 153: "i16x8.max_u",
+	PrefixSIMD << 24 | 155: "i16x8.avgr_u",
+	PrefixSIMD << 24 | 156: "i16x8.extmul_low_i8x16_s",
+	PrefixSIMD << 24 | 157: "i16x8.extmul_high_i8x16_s",
+	PrefixSIMD << 24 | 158: "i16x8.extmul_low_i8x16_u",
+	PrefixSIMD << 24 | 159: "i16x8.extmul_high_i8x16_u",
+	PrefixSIMD << 24 | 160: "i32x4.abs",
+	PrefixSIMD << 24 | 161: "i32x4.neg",
+	PrefixSIMD << 24 | 163: "i32x4.all_true",
+	PrefixSIMD << 24 | 164: "i32x4.bitmask",
+	PrefixSIMD << 24 | 167: "i32x4.extend_low_i16x8_s",
+	PrefixSIMD << 24 | 168: "i32x4.extend_high_i16x8_s",
+	PrefixSIMD << 24 | 169: "i32x4.extend_low_i16x8_u",
+	PrefixSIMD << 24 | 170: "i32x4.extend_high_i16x8_u",
+	PrefixSIMD << 24 | 171: "i32x4.shl",
+	PrefixSIMD << 24 | 172: "i32x4.shr_s",
+	PrefixSIMD << 24 | 173: "i32x4.shr_u",
+	PrefixSIMD << 24 | 174: "i32x4.add",
+	PrefixSIMD << 24 | 177: "i32x4.sub",
+	PrefixSIMD << 24 | 181: "i32x4.mul",
+	PrefixSIMD << 24 | 182: "i32x4.min_s",
+	PrefixSIMD << 24 | 183: "i32x4.min_u",
+	PrefixSIMD << 24 | 184: "i32x4.max_s",
+	PrefixSIMD << 24 | 185: "i32x4.max_u",
+	PrefixSIMD << 24 | 186: "i32x4.dot_i16x8_s",
+	PrefixSIMD << 24 | 188: "i32x4.extmul_low_i16x8_s",
+	PrefixSIMD << 24 | 189: "i32x4.extmul_high_i16x8_s",
+	PrefixSIMD << 24 | 190: "i32x4.extmul_low_i16x8_u",
+	PrefixSIMD << 24 | 191: "i32x4.extmul_high_i16x8_u",
+	PrefixSIMD << 24 | 192: "i64x2.abs",
+	PrefixSIMD << 24 | 193: "i64x2.neg",
+	PrefixSIMD << 24 | 195: "i64x2.all_true",
+	PrefixSIMD << 24 | 196: "i64x2.bitmask",
+	PrefixSIMD << 24 | 199: "i64x2.extend_low_i32x4_s",
+	PrefixSIMD << 24 | 200: "i64x2.extend_high_i32x4_s",
+	PrefixSIMD << 24 | 201: "i64x2.extend_low_i32x4_u",
+	PrefixSIMD << 24 | 202: "i64x2.extend_high_i32x4_u",
+	PrefixSIMD << 24 | 203: "i64x2.shl",
+	PrefixSIMD << 24 | 204: "i64x2.shr_s",
+	PrefixSIMD << 24 | 205: "i64x2.shr_u",
+	PrefixSIMD << 24 | 206: "i64x2.add",
+	PrefixSIMD << 24 | 209: "i64x2.sub",
+	PrefixSIMD << 24 | 213: "i64x2.mul",
+	PrefixSIMD << 24 | 214: "i64x2.eq",
+	PrefixSIMD << 24 | 215: "i64x2.ne",
+	PrefixSIMD << 24 | 216: "i64x2.lt_s",
+	PrefixSIMD << 24 | 217: "i64x2.gt_s",
+	PrefixSIMD << 24 | 218: "i64x2.le_s",
+	PrefixSIMD << 24 | 219: "i64x2.ge_s",
+	PrefixSIMD << 24 | 220: "i64x2.extmul_low_i32x4_s",
+	PrefixSIMD << 24 | 221: "i64x2.extmul_high_i32x4_s",
+	PrefixSIMD << 24 | 222: "i64x2.extmul_low_i32x4_u",
+	PrefixSIMD << 24 | 223: "i64x2.extmul_high_i32x4_u",
+	PrefixSIMD << 24 | 224: "f32x4.abs",
+	PrefixSIMD << 24 | 225: "f32x4.neg",
+	PrefixSIMD << 24 | 227: "f32x4.sqrt",
+	PrefixSIMD << 24 | 228: "f32x4.add",
+	PrefixSIMD << 24 | 229: "f32x4.sub",
+	PrefixSIMD << 24 | 230: "f32x4.mul",
+	PrefixSIMD << 24 | 231: "f32x4.div",
+	PrefixSIMD << 24 | 232: "f32x4.min",
+	PrefixSIMD << 24 | 233: "f32x4.max",
+	PrefixSIMD << 24 | 234: "f32x4.pmin",
+	PrefixSIMD << 24 | 235: "f32x4.pmax",
+	PrefixSIMD << 24 | 236: "f64x2.abs",
+	PrefixSIMD << 24 | 237: "f64x2.neg",
+	PrefixSIMD << 24 | 239: "f64x2.sqrt",
+	PrefixSIMD << 24 | 240: "f64x2.add",
+	PrefixSIMD << 24 | 241: "f64x2.sub",
+	PrefixSIMD << 24 | 242: "f64x2.mul",
+	PrefixSIMD << 24 | 243: "f64x2.div",
+	PrefixSIMD << 24 | 244: "f64x2.min",
+	PrefixSIMD << 24 | 245: "f64x2.max",
+	PrefixSIMD << 24 | 246: "f64x2.pmin",
+	PrefixSIMD << 24 | 247: "f64x2.pmax",
+	PrefixSIMD << 24 | 248: "i32x4.trunc_sat_f32x4_s",
+	PrefixSIMD << 24 | 249: "i32x4.trunc_sat_f32x4_u",
+	PrefixSIMD << 24 | 250: "f32x4.convert_i32x4_s",
+	PrefixSIMD << 24 | 251: "f32x4.convert_i32x4_u",
+	PrefixSIMD << 24 | 252: "i32x4.trunc_sat_f64x2_s_zero",
+	PrefixSIMD << 24 | 253: "i32x4.trunc_sat_f64x2_u_zero",
+	PrefixSIMD << 24 | 254: "f64x2.convert_low_i32x4_s",
+	PrefixSIMD << 24 | 255: "f64x2.convert_low_i32x4_u",
+	PrefixThreads << 24 | 0x00: "memory.atomic.notify",
+	PrefixThreads << 24 | 0x01: "memory.atomic.wait32",
+	PrefixThreads << 24 | 0x02: "memory.atomic.wait64",
+	PrefixThreads << 24 | 0x03: "atomic.fence",
+	PrefixThreads << 24 | 0x10: "i32.atomic.load",
+	PrefixThreads << 24 | 0x11: "i64.atomic.load",
+	PrefixThreads << 24 | 0x12: "i32.atomic.load8_u",
+	PrefixThreads << 24 | 0x13: "i32.atomic.load16_u",
+	PrefixThreads << 24 | 0x14: "i64.atomic.load8_u",
+	PrefixThreads << 24 | 0x15: "i64.atomic.load16_u",
+	PrefixThreads << 24 | 0x16: "i64.atomic.load32_u",
+	PrefixThreads << 24 | 0x17: "i32.atomic.store",
+	PrefixThreads << 24 | 0x18: "i64.atomic.store",
+	PrefixThreads << 24 | 0x19: "i32.atomic.store8",
+	PrefixThreads << 24 | 0x1a: "i32.atomic.store16",
+	PrefixThreads << 24 | 0x1b: "i64.atomic.store8",
+	PrefixThreads << 24 | 0x1c: "i64.atomic.store16",
+	PrefixThreads << 24 | 0x1d: "i64.atomic.store32",
+	PrefixThreads << 24 | 0x1e: "i32.atomic.rmw.add",
+	PrefixThreads << 24 | 0x1f: "i64.atomic.rmw.add",
+	PrefixThreads << 24 | 0x20: "i32.atomic.rmw8.add_u",
+	PrefixThreads << 24 | 0x21: "i32.atomic.rmw16.add_u",
+	PrefixThreads << 24 | 0x22: "i64.atomic.rmw8.add_u",
+	PrefixThreads << 24 | 0x23: "i64.atomic.rmw16.add_u",
+	PrefixThreads << 24 | 0x24: "i64.atomic.rmw32.add_u",
+	PrefixThreads << 24 | 0x25: "i32.atomic.rmw.sub",
+	PrefixThreads << 24 | 0x26: "i64.atomic.rmw.sub",
+	PrefixThreads << 24 | 0x27: "i32.atomic.rmw8.sub_u",
+	PrefixThreads << 24 | 0x28: "i32.atomic.rmw16.sub_u",
+	PrefixThreads << 24 | 0x29: "i64.atomic.rmw8.sub_u",
+	PrefixThreads << 24 | 0x2a: "i64.atomic.rmw16.sub_u",
+	PrefixThreads << 24 | 0x2b: "i64.atomic.rmw32.sub_u",
+	PrefixThreads << 24 | 0x2c: "i32.atomic.rmw.and",
+	PrefixThreads << 24 | 0x2d: "i64.atomic.rmw.and",
+	PrefixThreads << 24 | 0x2e: "i32.atomic.rmw8.and_u",
+	PrefixThreads << 24 | 0x2f: "i32.atomic.rmw16.and_u",
+	PrefixThreads << 24 | 0x30: "i64.atomic.rmw8.and_u",
+	PrefixThreads << 24 | 0x31: "i64.atomic.rmw16.and_u",
+	PrefixThreads << 24 | 0x32: "i64.atomic.rmw32.and_u",
+	PrefixThreads << 24 | 0x33: "i32.atomic.rmw.or",
+	PrefixThreads << 24 | 0x34: "i64.atomic.rmw.or",
+	PrefixThreads << 24 | 0x35: "i32.atomic.rmw8.or_u",
+	PrefixThreads << 24 | 0x36: "i32.atomic.rmw16.or_u",
+	PrefixThreads << 24 | 0x37: "i64.atomic.rmw8.or_u",
+	PrefixThreads << 24 | 0x38: "i64.atomic.rmw16.or_u",
+	PrefixThreads << 24 | 0x39: "i64.atomic.rmw32.or_u",
+	PrefixThreads << 24 | 0x3a: "i32.atomic.rmw.xor",
+	PrefixThreads << 24 | 0x3b: "i64.atomic.rmw.xor",
+	PrefixThreads << 24 | 0x3c: "i32.atomic.rmw8.xor_u",
+	PrefixThreads << 24 | 0x3d: "i32.atomic.rmw16.xor_u",
+	PrefixThreads << 24 | 0x3e: "i64.atomic.rmw8.xor_u",
+	PrefixThreads << 24 | 0x3f: "i64.atomic.rmw16.xor_u",
+	PrefixThreads << 24 | 0x40: "i64.atomic.rmw32.xor_u",
+	PrefixThreads << 24 | 0x41: "i32.atomic.rmw.xchg",
+	PrefixThreads << 24 | 0x42: "i64.atomic.rmw.xchg",
+	PrefixThreads << 24 | 0x43: "i32.atomic.rmw8.xchg_u",
+	PrefixThreads << 24 | 0x44: "i32.atomic.rmw16.xchg_u",
+	PrefixThreads << 24 | 0x45: "i64.atomic.rmw8.xchg_u",
+	PrefixThreads << 24 | 0x46: "i64.atomic.rmw16.xchg_u",
+	PrefixThreads << 24 | 0x47: "i64.atomic.rmw32.xchg_u",
+	PrefixThreads << 24 | 0x48: "i32.atomic.rmw.cmpxchg",
+	PrefixThreads << 24 | 0x49: "i64.atomic.rmw.cmpxchg",
+	PrefixThreads << 24 | 0x4a: "i32.atomic.rmw8.cmpxchg_u",
+	PrefixThreads << 24 | 0x4b: "i32.atomic.rmw16.cmpxchg_u",
+	PrefixThreads << 24 | 0x4c: "i64.atomic.rmw8.cmpxchg_u",
+	PrefixThreads << 24 | 0x4d: "i64.atomic.rmw16.cmpxchg_u",
+	PrefixThreads << 24 | 0x4e: "i64.atomic.rmw32.cmpxchg_u",
+}
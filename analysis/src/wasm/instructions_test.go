@@ -0,0 +1,136 @@
+package wasm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Immediates of signed constants, sign extended
+func signed(v int64) uint64 {
+	return uint64(v)
+}
+
+func TestDecodeInstruction(t *testing.T) {
+	sixteen := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	tests := []struct {
+		name string
+		code []byte
+		opcode string
+		immediates []uint64
+		bytes []byte
+	}{
+		{"no immediate", []byte{0x6a}, "i32.add", nil, nil},
+		{"empty block", []byte{0x02, 0x40}, "block", []uint64{signed(-64)}, nil},
+		{"value block", []byte{0x03, 0x7f}, "loop", []uint64{signed(-1)}, nil},
+		{"typed block", []byte{0x04, 0x05}, "if", []uint64{5}, nil},
+		{"br", []byte{0x0c, 0x02}, "br", []uint64{2}, nil},
+		{"br_table", []byte{0x0e, 0x02, 0x01, 0x02, 0x00}, "br_table", []uint64{1, 2, 0}, nil},
+		{"br_table without labels", []byte{0x0e, 0x00, 0x05}, "br_table", []uint64{5}, nil},
+		{"br_table with large labels", []byte{0x0e, 0x01, 0x80, 0x01, 0xff, 0x7f}, "br_table", []uint64{128, 16383}, nil},
+		{"call", []byte{0x10, 0x80, 0x02}, "call", []uint64{256}, nil},
+		{"call_indirect", []byte{0x11, 0x03, 0x00}, "call_indirect", []uint64{3, 0}, nil},
+		{"typed select", []byte{0x1c, 0x01, 0x7f}, "select", []uint64{0x7f}, nil},
+		{"local.get", []byte{0x20, 0x00}, "local.get", []uint64{0}, nil},
+		{"memarg", []byte{0x28, 0x02, 0x10}, "i32.load", []uint64{2, 16, 0}, nil},
+		{"memarg with memory index", []byte{0x36, 0x42, 0x01, 0x08}, "i32.store", []uint64{2, 8, 1}, nil},
+		{"memarg with 64-bit offset", []byte{0x29, 0x03, 0x80, 0x80, 0x80, 0x80, 0x10}, "i64.load", []uint64{3, 1 << 32, 0}, nil},
+		{"memory.grow", []byte{0x40, 0x00}, "memory.grow", []uint64{0}, nil},
+		{"i32.const", []byte{0x41, 0x7f}, "i32.const", []uint64{signed(-1)}, nil},
+		{"i64.const", []byte{0x42, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}, "i64.const", []uint64{1 << 63}, nil},
+		{"f32.const", []byte{0x43, 0x00, 0x00, 0x80, 0x3f}, "f32.const", nil, []byte{0x00, 0x00, 0x80, 0x3f}},
+		{"f64.const", []byte{0x44, 1, 2, 3, 4, 5, 6, 7, 8}, "f64.const", nil, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"ref.null", []byte{0xd0, 0x70}, "ref.null", []uint64{signed(-16)}, nil},
+		{"saturating truncation", []byte{0xfc, 0x00}, "i32.trunc_sat_f32_s", nil, nil},
+		{"memory.init", []byte{0xfc, 0x08, 0x01, 0x00}, "memory.init", []uint64{1, 0}, nil},
+		{"data.drop", []byte{0xfc, 0x09, 0x01}, "data.drop", []uint64{1}, nil},
+		{"memory.copy", []byte{0xfc, 0x0a, 0x00, 0x00}, "memory.copy", []uint64{0, 0}, nil},
+		{"memory.fill", []byte{0xfc, 0x0b, 0x00}, "memory.fill", []uint64{0}, nil},
+		{"table.copy", []byte{0xfc, 0x0e, 0x01, 0x02}, "table.copy", []uint64{1, 2}, nil},
+		{"overlong subopcode", []byte{0xfc, 0x88, 0x00, 0x01, 0x00}, "memory.init", []uint64{1, 0}, nil},
+		{"v128.load", []byte{0xfd, 0x00, 0x04, 0x00}, "v128.load", []uint64{4, 0, 0}, nil},
+		{"v128.const", append([]byte{0xfd, 0x0c}, sixteen...), "v128.const", nil, sixteen},
+		{"i8x16.shuffle", append([]byte{0xfd, 0x0d}, sixteen...), "i8x16.shuffle", nil, sixteen},
+		{"extract lane", []byte{0xfd, 0x15, 0x03}, "i8x16.extract_lane_s", []uint64{3}, nil},
+		{"load lane", []byte{0xfd, 0x54, 0x00, 0x00, 0x07}, "v128.load8_lane", []uint64{0, 0, 0, 7}, nil},
+		{"two-byte subopcode", []byte{0xfd, 0xae, 0x01}, "i32x4.add", nil, nil},
+		{"atomic.fence", []byte{0xfe, 0x03, 0x00}, "atomic.fence", nil, nil},
+		{"atomic load", []byte{0xfe, 0x10, 0x02, 0x00}, "i32.atomic.load", []uint64{2, 0, 0}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instructions, err := DecodeInstructions(test.code, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(instructions) != 1 {
+				t.Fatalf("decoded %d instructions, expected 1", len(instructions))
+			}
+			instr := instructions[0]
+			if instr.Opcode.String() != test.opcode {
+				t.Errorf("opcode %s, expected %s", instr.Opcode, test.opcode)
+			}
+			if !reflect.DeepEqual(instr.Immediates, test.immediates) {
+				t.Errorf("immediates %v, expected %v", instr.Immediates, test.immediates)
+			}
+			if !bytes.Equal(instr.Bytes, test.bytes) {
+				t.Errorf("bytes %v, expected %v", instr.Bytes, test.bytes)
+			}
+		})
+	}
+}
+
+func TestDecodeInstructionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		err string
+	}{
+		{"unknown opcode", []byte{0x27}, "unknown opcode 0x27"},
+		{"unknown prefixed opcode", []byte{0xfc, 0x7f}, "unknown opcode 0xfc-0x7f"},
+		{"unknown SIMD opcode", []byte{0xfd, 0x80, 0x04}, "unknown opcode 0xfd-0x200"},
+		{"truncated subopcode", []byte{0xfc}, truncated},
+		{"truncated index", []byte{0x10}, truncated},
+		{"truncated br_table", []byte{0x0e, 0x01, 0x01}, truncated},
+		{"br_table longer than the body", []byte{0x0e, 0x10, 0x01}, "does not fit in the input"},
+		{"truncated memarg", []byte{0x28, 0x02}, truncated},
+		{"truncated memory index", []byte{0x28, 0x40}, truncated},
+		{"truncated i32.const", []byte{0x41}, truncated},
+		{"i32.const too large", []byte{0x41, 0x80, 0x80, 0x80, 0x80, 0x08}, "integer too large"},
+		{"truncated f64.const", []byte{0x44, 0x00, 0x00}, truncated},
+		{"truncated v128.const", []byte{0xfd, 0x0c, 0x00}, truncated},
+		{"truncated lane", []byte{0xfd, 0x54, 0x00, 0x00}, truncated},
+		{"truncated block type", []byte{0x02}, truncated},
+		{"error after instructions", []byte{0x01, 0x01, 0x41}, "at offset 0x13"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeInstructions(test.code, 0x10)
+			checkError(t, err, test.err)
+		})
+	}
+}
+
+func TestWalkInstructions(t *testing.T) {
+	code := []byte{0x20, 0x00, 0x41, 0x01, 0x6a, 0x0b}
+	offsets := make([]int, 0)
+	err := WalkInstructions(code, 0x100, func(instr Instruction) error {
+		offsets = append(offsets, instr.Offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(offsets, []int{0x100, 0x102, 0x104, 0x105}) {
+		t.Errorf("offsets %v", offsets)
+	}
+	stop := ErrUnexpectedEnd
+	visited := 0
+	err = WalkInstructions(code, 0, func(instr Instruction) error {
+		visited += 1
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Errorf("walk not stopped by the visitor: %d instructions visited, error %v", visited, err)
+	}
+}
@@ -0,0 +1,296 @@
+// Package wasm parses WebAssembly modules in the binary format.
+//
+// It decodes the structure of a module (sections, types, imports,
+// functions, tables, memories, globals, exports, start function and code
+// bodies) along with the instructions of the function bodies, which is what
+// the analyses of the scraped modules need. It does not validate modules.
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Identifiers of the sections
+type SectionID byte
+
+const (
+	SectionCustom SectionID = 0
+	SectionType SectionID = 1
+	SectionImport SectionID = 2
+	SectionFunction SectionID = 3
+	SectionTable SectionID = 4
+	SectionMemory SectionID = 5
+	SectionGlobal SectionID = 6
+	SectionExport SectionID = 7
+	SectionStart SectionID = 8
+	SectionElement SectionID = 9
+	SectionCode SectionID = 10
+	SectionData SectionID = 11
+	SectionDataCount SectionID = 12
+	SectionTag SectionID = 13
+)
+
+// Short names of the sections, as used by wassail
+var sectionNames = map[SectionID]string{
+	SectionCustom: "custom",
+	SectionType: "type",
+	SectionImport: "import",
+	SectionFunction: "func",
+	SectionTable: "table",
+	SectionMemory: "memory",
+	SectionGlobal: "global",
+	SectionExport: "export",
+	SectionStart: "start",
+	SectionElement: "elem",
+	SectionCode: "code",
+	SectionData: "data",
+	SectionDataCount: "datacount",
+	SectionTag: "tag",
+}
+
+func (id SectionID) String() string {
+	if name, present := sectionNames[id]; present {
+		return name
+	}
+	return fmt.Sprintf("section-%d", byte(id))
+}
+
+// The standard sections, in the order in which they appear in a module
+var StandardSections = []SectionID{
+	SectionType, SectionImport, SectionFunction, SectionTable, SectionMemory, SectionGlobal,
+	SectionExport, SectionStart, SectionElement, SectionCode, SectionData,
+}
+
+// A section of a module, as found in the binary
+type Section struct {
+	ID SectionID
+	Name string // Name of a custom section
+	Offset int // Offset of the content of the section in the binary
+	Size int // Size of the content of the section, in bytes
+}
+
+// Types of values
+type ValueType byte
+
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+	F32 ValueType = 0x7d
+	F64 ValueType = 0x7c
+	V128 ValueType = 0x7b
+	FuncRef ValueType = 0x70
+	ExternRef ValueType = 0x6f
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case V128:
+		return "v128"
+	case FuncRef:
+		return "funcref"
+	case ExternRef:
+		return "externref"
+	}
+	return fmt.Sprintf("type-0x%02x", byte(t))
+}
+
+func isValueType(b byte) bool {
+	switch ValueType(b) {
+	case I32, I64, F32, F64, V128, FuncRef, ExternRef:
+		return true
+	}
+	return false
+}
+
+// The type of a function
+type FuncType struct {
+	Params []ValueType
+	Results []ValueType
+}
+
+// Formats the type as wassail does, e.g. "i32, i32 -> i32"
+func (t FuncType) String() string {
+	names := func(types []ValueType) string {
+		s := make([]string, len(types))
+		for i, t := range types {
+			s[i] = t.String()
+		}
+		return strings.Join(s, ", ")
+	}
+	params := names(t.Params)
+	if params != "" {
+		params += " "
+	}
+	results := names(t.Results)
+	if results != "" {
+		results = " " + results
+	}
+	return params + "->" + results
+}
+
+// Kinds of imports and exports
+type ExternalKind byte
+
+const (
+	ExternalFunction ExternalKind = 0
+	ExternalTable ExternalKind = 1
+	ExternalMemory ExternalKind = 2
+	ExternalGlobal ExternalKind = 3
+	ExternalTag ExternalKind = 4
+)
+
+func (k ExternalKind) String() string {
+	switch k {
+	case ExternalFunction:
+		return "func"
+	case ExternalTable:
+		return "table"
+	case ExternalMemory:
+		return "memory"
+	case ExternalGlobal:
+		return "global"
+	case ExternalTag:
+		return "tag"
+	}
+	return fmt.Sprintf("kind-%d", byte(k))
+}
+
+// Minimum and optional maximum size of a table or memory
+type Limits struct {
+	Min uint64
+	Max uint64
+	HasMax bool
+	Shared bool // Shared memory (threads proposal)
+	Is64 bool // 64-bit memory (memory64 proposal)
+}
+
+type Table struct {
+	ElemType ValueType
+	Limits Limits
+}
+
+type Memory struct {
+	Limits Limits
+}
+
+type GlobalType struct {
+	Type ValueType
+	Mutable bool
+}
+
+type Global struct {
+	Type GlobalType
+	Init []Instruction // Constant expression computing the initial value, without the final end
+}
+
+type Import struct {
+	Module string
+	Name string
+	Kind ExternalKind
+	TypeIndex uint32 // For functions and tags
+	Table Table // For tables
+	Memory Memory // For memories
+	Global GlobalType // For globals
+}
+
+type Export struct {
+	Name string
+	Kind ExternalKind
+	Index uint32
+}
+
+// Local variables of a function, declared in groups of the same type
+type Locals struct {
+	Count uint32
+	Type ValueType
+}
+
+// The body of a defined function
+type FunctionBody struct {
+	Locals []Locals
+	Code []byte // The instructions of the body, including the final end
+	Offset int // Offset of Code in the binary
+}
+
+// Decode the instructions of the body
+func (b FunctionBody) Instructions() ([]Instruction, error) {
+	return DecodeInstructions(b.Code, b.Offset)
+}
+
+// A parsed module
+type Module struct {
+	Version uint32
+	Sections []Section
+	Types []FuncType
+	Imports []Import
+	Functions []uint32 // Type index of each defined function
+	Tables []Table
+	Memories []Memory
+	Globals []Global
+	Exports []Export
+	Start *uint32
+	ElementSegments int
+	Code []FunctionBody
+	DataSegments int
+	Customs []Section
+}
+
+// Size of the content of the given section, 0 if the module does not have it.
+// Returns the total size if the section appears multiple times, as custom sections can.
+func (m *Module) SectionSize(id SectionID) int {
+	size := 0
+	for _, section := range m.Sections {
+		if section.ID == id {
+			size += section.Size
+		}
+	}
+	return size
+}
+
+// The imports of the given kind
+func (m *Module) ImportsOf(kind ExternalKind) []Import {
+	imports := make([]Import, 0)
+	for _, imp := range m.Imports {
+		if imp.Kind == kind {
+			imports = append(imports, imp)
+		}
+	}
+	return imports
+}
+
+// The exports of the given kind
+func (m *Module) ExportsOf(kind ExternalKind) []Export {
+	exports := make([]Export, 0)
+	for _, exp := range m.Exports {
+		if exp.Kind == kind {
+			exports = append(exports, exp)
+		}
+	}
+	return exports
+}
+
+// The type of the function with the given index, where imported functions come first
+func (m *Module) FunctionType(index uint32) (FuncType, error) {
+	imported := m.ImportsOf(ExternalFunction)
+	var typeIndex uint32
+	if int(index) < len(imported) {
+		typeIndex = imported[index].TypeIndex
+	} else if int(index) - len(imported) < len(m.Functions) {
+		typeIndex = m.Functions[int(index) - len(imported)]
+	} else {
+		return FuncType{}, fmt.Errorf("no function with index %d", index)
+	}
+	if int(typeIndex) >= len(m.Types) {
+		return FuncType{}, fmt.Errorf("no type with index %d", typeIndex)
+	}
+	return m.Types[typeIndex], nil
+}
@@ -0,0 +1,331 @@
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+var magic = []byte{0x00, 0x61, 0x73, 0x6d}
+
+// Parse a module from its binary representation
+func Parse(data []byte) (*Module, error) {
+	r := newReader(data, 0)
+	header, err := r.bytes(4)
+	if err != nil || !bytes.Equal(header, magic) {
+		return nil, fmt.Errorf("not a WebAssembly module: invalid magic number")
+	}
+	version, err := r.bytes(4)
+	if err != nil {
+		return nil, err
+	}
+	m := &Module{Version: uint32(version[0]) | uint32(version[1]) << 8 | uint32(version[2]) << 16 | uint32(version[3]) << 24}
+	if m.Version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", m.Version)
+	}
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		if int(size) > len(data) - r.pos {
+			return nil, r.errorf("%s section of %d bytes is truncated", SectionID(id), size)
+		}
+		section := Section{ID: SectionID(id), Offset: r.offset(), Size: int(size)}
+		content, _ := r.bytes(int(size))
+		if err := m.parseSection(&section, newReader(content, section.Offset)); err != nil {
+			return nil, fmt.Errorf("%s section: %v", section.ID, err)
+		}
+		m.Sections = append(m.Sections, section)
+	}
+	if len(m.Functions) != len(m.Code) {
+		return nil, fmt.Errorf("%d functions declared but %d bodies defined", len(m.Functions), len(m.Code))
+	}
+	return m, nil
+}
+
+// Parse the module stored in the given file
+func ParseFile(path string) (*Module, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+// Parse the content of a section, which must be entirely consumed
+func (m *Module) parseSection(section *Section, r *reader) error {
+	var err error
+	switch section.ID {
+	case SectionCustom:
+		section.Name, err = r.name()
+		if err == nil {
+			m.Customs = append(m.Customs, *section)
+		}
+		// The content of custom sections is not interpreted
+		r.pos = len(r.data)
+	case SectionType:
+		err = vector(r, func() error {
+			t, err := parseFuncType(r)
+			m.Types = append(m.Types, t)
+			return err
+		})
+	case SectionImport:
+		err = vector(r, func() error {
+			imp, err := parseImport(r)
+			m.Imports = append(m.Imports, imp)
+			return err
+		})
+	case SectionFunction:
+		err = vector(r, func() error {
+			index, err := r.u32()
+			m.Functions = append(m.Functions, index)
+			return err
+		})
+	case SectionTable:
+		err = vector(r, func() error {
+			table, err := parseTable(r)
+			m.Tables = append(m.Tables, table)
+			return err
+		})
+	case SectionMemory:
+		err = vector(r, func() error {
+			limits, err := parseLimits(r)
+			m.Memories = append(m.Memories, Memory{limits})
+			return err
+		})
+	case SectionGlobal:
+		err = vector(r, func() error {
+			t, err := parseGlobalType(r)
+			if err != nil {
+				return err
+			}
+			init, err := decodeConstExpr(r)
+			m.Globals = append(m.Globals, Global{Type: t, Init: init})
+			return err
+		})
+	case SectionExport:
+		err = vector(r, func() error {
+			exp, err := parseExport(r)
+			m.Exports = append(m.Exports, exp)
+			return err
+		})
+	case SectionStart:
+		var index uint32
+		index, err = r.u32()
+		m.Start = &index
+	case SectionElement:
+		// Only the number of segments is needed
+		m.ElementSegments, err = r.count()
+		r.pos = len(r.data)
+	case SectionCode:
+		err = vector(r, func() error {
+			body, err := parseFunctionBody(r)
+			m.Code = append(m.Code, body)
+			return err
+		})
+	case SectionData:
+		// Only the number of segments is needed
+		m.DataSegments, err = r.count()
+		r.pos = len(r.data)
+	case SectionDataCount:
+		_, err = r.u32()
+	case SectionTag:
+		err = vector(r, func() error {
+			if _, err := r.byte(); err != nil {
+				return err
+			}
+			_, err := r.u32()
+			return err
+		})
+	default:
+		return fmt.Errorf("unknown section id %d", byte(section.ID))
+	}
+	if err != nil {
+		return err
+	}
+	if !r.eof() {
+		return r.errorf("%d unexpected bytes at the end of the section", len(r.data) - r.pos)
+	}
+	return nil
+}
+
+// Read a vector, calling element for each of its elements
+func vector(r *reader, element func() error) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := element(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseValueType(r *reader) (ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	if !isValueType(b) {
+		r.pos--
+		return 0, r.errorf("invalid value type 0x%02x", b)
+	}
+	return ValueType(b), nil
+}
+
+func parseValueTypes(r *reader) ([]ValueType, error) {
+	types := make([]ValueType, 0)
+	err := vector(r, func() error {
+		t, err := parseValueType(r)
+		types = append(types, t)
+		return err
+	})
+	return types, err
+}
+
+func parseFuncType(r *reader) (FuncType, error) {
+	form, err := r.byte()
+	if err != nil {
+		return FuncType{}, err
+	}
+	if form != 0x60 {
+		return FuncType{}, r.errorf("unsupported type form 0x%02x", form)
+	}
+	params, err := parseValueTypes(r)
+	if err != nil {
+		return FuncType{}, err
+	}
+	results, err := parseValueTypes(r)
+	return FuncType{Params: params, Results: results}, err
+}
+
+func parseLimits(r *reader) (Limits, error) {
+	flags, err := r.byte()
+	if err != nil {
+		return Limits{}, err
+	}
+	if flags > 7 {
+		return Limits{}, r.errorf("invalid limits flags 0x%02x", flags)
+	}
+	limits := Limits{HasMax: flags & 1 != 0, Shared: flags & 2 != 0, Is64: flags & 4 != 0}
+	if limits.Min, err = r.u64(); err != nil {
+		return limits, err
+	}
+	if limits.HasMax {
+		limits.Max, err = r.u64()
+	}
+	return limits, err
+}
+
+func parseTable(r *reader) (Table, error) {
+	elemType, err := parseValueType(r)
+	if err != nil {
+		return Table{}, err
+	}
+	limits, err := parseLimits(r)
+	return Table{ElemType: elemType, Limits: limits}, err
+}
+
+func parseGlobalType(r *reader) (GlobalType, error) {
+	t, err := parseValueType(r)
+	if err != nil {
+		return GlobalType{}, err
+	}
+	mutable, err := r.byte()
+	if err != nil {
+		return GlobalType{}, err
+	}
+	if mutable > 1 {
+		return GlobalType{}, r.errorf("invalid mutability 0x%02x", mutable)
+	}
+	return GlobalType{Type: t, Mutable: mutable == 1}, nil
+}
+
+func parseImport(r *reader) (Import, error) {
+	var imp Import
+	var err error
+	if imp.Module, err = r.name(); err != nil {
+		return imp, err
+	}
+	if imp.Name, err = r.name(); err != nil {
+		return imp, err
+	}
+	kind, err := r.byte()
+	if err != nil {
+		return imp, err
+	}
+	imp.Kind = ExternalKind(kind)
+	switch imp.Kind {
+	case ExternalFunction:
+		imp.TypeIndex, err = r.u32()
+	case ExternalTable:
+		imp.Table, err = parseTable(r)
+	case ExternalMemory:
+		imp.Memory.Limits, err = parseLimits(r)
+	case ExternalGlobal:
+		imp.Global, err = parseGlobalType(r)
+	case ExternalTag:
+		if _, err = r.byte(); err == nil {
+			imp.TypeIndex, err = r.u32()
+		}
+	default:
+		err = r.errorf("invalid import kind 0x%02x", kind)
+	}
+	return imp, err
+}
+
+func parseExport(r *reader) (Export, error) {
+	var exp Export
+	var err error
+	if exp.Name, err = r.name(); err != nil {
+		return exp, err
+	}
+	kind, err := r.byte()
+	if err != nil {
+		return exp, err
+	}
+	if kind > byte(ExternalTag) {
+		return exp, r.errorf("invalid export kind 0x%02x", kind)
+	}
+	exp.Kind = ExternalKind(kind)
+	exp.Index, err = r.u32()
+	return exp, err
+}
+
+func parseFunctionBody(r *reader) (FunctionBody, error) {
+	size, err := r.count()
+	if err != nil {
+		return FunctionBody{}, err
+	}
+	start := r.pos
+	body := FunctionBody{Locals: make([]Locals, 0)}
+	err = vector(r, func() error {
+		count, err := r.u32()
+		if err != nil {
+			return err
+		}
+		t, err := parseValueType(r)
+		body.Locals = append(body.Locals, Locals{Count: count, Type: t})
+		return err
+	})
+	if err != nil {
+		return body, err
+	}
+	if r.pos > start + size {
+		return body, r.errorf("locals exceed the size of the function body")
+	}
+	body.Offset = r.offset()
+	body.Code, _ = r.bytes(start + size - r.pos)
+	return body, nil
+}
@@ -0,0 +1,323 @@
+package wasm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Helpers assembling modules in the binary format
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// Encode an unsigned LEB128 integer
+func leb(v uint64) []byte {
+	encoded := make([]byte, 0)
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(encoded, b)
+		}
+		encoded = append(encoded, b | 0x80)
+	}
+}
+
+func vec(elements ...[]byte) []byte {
+	return concat(leb(uint64(len(elements))), concat(elements...))
+}
+
+func name(s string) []byte {
+	return concat(leb(uint64(len(s))), []byte(s))
+}
+
+func section(id SectionID, content ...[]byte) []byte {
+	body := concat(content...)
+	return concat([]byte{byte(id)}, leb(uint64(len(body))), body)
+}
+
+func custom(sectionName string, content []byte) []byte {
+	return section(SectionCustom, name(sectionName), content)
+}
+
+func module(sections ...[]byte) []byte {
+	return concat(magic, []byte{0x01, 0x00, 0x00, 0x00}, concat(sections...))
+}
+
+func funcType(params []ValueType, results []ValueType) []byte {
+	encode := func(types []ValueType) []byte {
+		elements := make([][]byte, len(types))
+		for i, t := range types {
+			elements[i] = []byte{byte(t)}
+		}
+		return vec(elements...)
+	}
+	return concat([]byte{0x60}, encode(params), encode(results))
+}
+
+func body(locals []byte, code ...byte) []byte {
+	content := concat(locals, code)
+	return concat(leb(uint64(len(content))), content)
+}
+
+// A module using every kind of section, import and export
+var (
+	typeSection = section(SectionType, vec(funcType([]ValueType{I32, I32}, []ValueType{I32}), funcType(nil, nil)))
+	importSection = section(SectionImport, vec(
+		concat(name("env"), name("f"), []byte{byte(ExternalFunction)}, leb(1)),
+		concat(name("env"), name("t"), []byte{byte(ExternalTable), byte(FuncRef), 0x00, 0x01}),
+		concat(name("env"), name("m"), []byte{byte(ExternalMemory), 0x01, 0x01, 0x02}),
+		concat(name("env"), name("g"), []byte{byte(ExternalGlobal), byte(I32), 0x01}),
+		concat(name("env"), name("e"), []byte{byte(ExternalTag), 0x00}, leb(1)),
+	))
+	functionSection = section(SectionFunction, vec(leb(0), leb(1)))
+	tableSection = section(SectionTable, vec([]byte{byte(ExternRef), 0x00, 0x00}))
+	memorySection = section(SectionMemory, vec([]byte{0x03, 0x01, 0x80, 0x02}))
+	globalSection = section(SectionGlobal, vec([]byte{byte(I64), 0x00, 0x42, 0x2a, 0x0b}))
+	exportSection = section(SectionExport, vec(
+		concat(name("add"), []byte{byte(ExternalFunction)}, leb(1)),
+		concat(name("tab"), []byte{byte(ExternalTable)}, leb(1)),
+		concat(name("mem"), []byte{byte(ExternalMemory)}, leb(0)),
+		concat(name("glob"), []byte{byte(ExternalGlobal)}, leb(1)),
+		concat(name("exn"), []byte{byte(ExternalTag)}, leb(0)),
+	))
+	startSection = section(SectionStart, leb(2))
+	elementSection = section(SectionElement, leb(1), []byte{0x00, 0x41, 0x00, 0x0b, 0x01, 0x00})
+	dataCountSection = section(SectionDataCount, leb(1))
+	codeSection = section(SectionCode, vec(
+		body(vec(concat(leb(2), []byte{byte(I64)})), 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b),
+		body(vec(), 0x01, 0x0b),
+	))
+	dataSection = section(SectionData, leb(1), []byte{0x00, 0x41, 0x00, 0x0b, 0x02, 'h', 'i'})
+	tagSection = section(SectionTag, vec([]byte{0x00, 0x01}))
+	nameSection = custom("name", []byte{0x01, 0x02, 0x03})
+	producersSection = custom("producers", []byte{0x00})
+	fullModule = module(nameSection, typeSection, importSection, functionSection, tableSection, memorySection,
+		tagSection, globalSection, exportSection, startSection, elementSection, dataCountSection, codeSection,
+		dataSection, producersSection, nameSection)
+)
+
+// Size of the content of an assembled section
+func contentSize(section []byte) int {
+	r := newReader(section[1:], 0)
+	size, _ := r.u32()
+	return int(size)
+}
+
+func TestParseModule(t *testing.T) {
+	m, err := Parse(fullModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 1 {
+		t.Errorf("version %d, expected 1", m.Version)
+	}
+	ids := make([]SectionID, len(m.Sections))
+	for i, s := range m.Sections {
+		ids[i] = s.ID
+	}
+	expectedIDs := []SectionID{SectionCustom, SectionType, SectionImport, SectionFunction, SectionTable, SectionMemory,
+		SectionTag, SectionGlobal, SectionExport, SectionStart, SectionElement, SectionDataCount, SectionCode,
+		SectionData, SectionCustom, SectionCustom}
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("sections %v, expected %v", ids, expectedIDs)
+	}
+	customs := make([]string, len(m.Customs))
+	for i, s := range m.Customs {
+		customs[i] = s.Name
+	}
+	if !reflect.DeepEqual(customs, []string{"name", "producers", "name"}) {
+		t.Errorf("custom sections %v", customs)
+	}
+	for _, s := range m.Sections {
+		if s.Offset + s.Size > len(fullModule) {
+			t.Errorf("%s section at 0x%x of %d bytes is out of the module", s.ID, s.Offset, s.Size)
+		}
+	}
+}
+
+func TestSectionSize(t *testing.T) {
+	m, err := Parse(fullModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id SectionID
+		want int
+	}{
+		// Custom sections appearing several times are added up
+		{SectionCustom, 2 * contentSize(nameSection) + contentSize(producersSection)},
+		{SectionType, contentSize(typeSection)},
+		{SectionImport, contentSize(importSection)},
+		{SectionFunction, contentSize(functionSection)},
+		{SectionTable, contentSize(tableSection)},
+		{SectionMemory, contentSize(memorySection)},
+		{SectionGlobal, contentSize(globalSection)},
+		{SectionExport, contentSize(exportSection)},
+		{SectionStart, contentSize(startSection)},
+		{SectionElement, contentSize(elementSection)},
+		{SectionCode, contentSize(codeSection)},
+		{SectionData, contentSize(dataSection)},
+		{SectionDataCount, contentSize(dataCountSection)},
+		{SectionTag, contentSize(tagSection)},
+	}
+	for _, test := range tests {
+		if got := m.SectionSize(test.id); got != test.want {
+			t.Errorf("%s section: size %d, expected %d", test.id, got, test.want)
+		}
+	}
+	empty, err := Parse(module())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := empty.SectionSize(SectionCode); size != 0 {
+		t.Errorf("size %d for a missing section, expected 0", size)
+	}
+}
+
+func TestParseSections(t *testing.T) {
+	m, err := Parse(fullModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got interface{}
+		want interface{}
+	}{
+		{"types", m.Types, []FuncType{{[]ValueType{I32, I32}, []ValueType{I32}}, {[]ValueType{}, []ValueType{}}}},
+		{"type names", []string{m.Types[0].String(), m.Types[1].String()}, []string{"i32, i32 -> i32", "->"}},
+		{"functions", m.Functions, []uint32{0, 1}},
+		{"tables", m.Tables, []Table{{ExternRef, Limits{}}}},
+		{"memories", m.Memories, []Memory{{Limits{Min: 1, Max: 256, HasMax: true, Shared: true}}}},
+		{"global type", m.Globals[0].Type, GlobalType{I64, false}},
+		{"global init", m.Globals[0].Init[0].Opcode.String(), "i64.const"},
+		{"global init value", m.Globals[0].Init[0].Immediates, []uint64{42}},
+		{"start", *m.Start, uint32(2)},
+		{"element segments", m.ElementSegments, 1},
+		{"data segments", m.DataSegments, 1},
+		{"bodies", len(m.Code), 2},
+		{"locals", m.Code[0].Locals, []Locals{{2, I64}}},
+		{"no locals", m.Code[1].Locals, []Locals{}},
+		{"code", m.Code[0].Code, []byte{0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b}},
+		{"code offset", fullModule[m.Code[1].Offset:m.Code[1].Offset + 2], []byte{0x01, 0x0b}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s: got %v, expected %v", test.name, test.got, test.want)
+		}
+	}
+	instructions, err := m.Code[0].Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(instructions))
+	for i, instr := range instructions {
+		names[i] = instr.Opcode.String()
+	}
+	if !reflect.DeepEqual(names, []string{"local.get", "local.get", "i32.add", "end"}) {
+		t.Errorf("instructions %v", names)
+	}
+}
+
+func TestImportsAndExports(t *testing.T) {
+	m, err := Parse(fullModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := []Import{
+		{Module: "env", Name: "f", Kind: ExternalFunction, TypeIndex: 1},
+		{Module: "env", Name: "t", Kind: ExternalTable, Table: Table{FuncRef, Limits{Min: 1}}},
+		{Module: "env", Name: "m", Kind: ExternalMemory, Memory: Memory{Limits{Min: 1, Max: 2, HasMax: true}}},
+		{Module: "env", Name: "g", Kind: ExternalGlobal, Global: GlobalType{I32, true}},
+		{Module: "env", Name: "e", Kind: ExternalTag, TypeIndex: 1},
+	}
+	if !reflect.DeepEqual(m.Imports, imports) {
+		t.Errorf("imports %+v, expected %+v", m.Imports, imports)
+	}
+	exports := []Export{
+		{"add", ExternalFunction, 1},
+		{"tab", ExternalTable, 1},
+		{"mem", ExternalMemory, 0},
+		{"glob", ExternalGlobal, 1},
+		{"exn", ExternalTag, 0},
+	}
+	if !reflect.DeepEqual(m.Exports, exports) {
+		t.Errorf("exports %+v, expected %+v", m.Exports, exports)
+	}
+	for _, kind := range []ExternalKind{ExternalFunction, ExternalTable, ExternalMemory, ExternalGlobal, ExternalTag} {
+		if imports := m.ImportsOf(kind); len(imports) != 1 || imports[0].Kind != kind {
+			t.Errorf("imports of kind %s: %+v", kind, imports)
+		}
+		if exports := m.ExportsOf(kind); len(exports) != 1 || exports[0].Kind != kind {
+			t.Errorf("exports of kind %s: %+v", kind, exports)
+		}
+	}
+}
+
+func TestFunctionType(t *testing.T) {
+	m, err := Parse(fullModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		index uint32
+		want string
+		err string
+	}{
+		// The imported function comes first
+		{0, "->", ""},
+		{1, "i32, i32 -> i32", ""},
+		{2, "->", ""},
+		{3, "", "no function with index 3"},
+	}
+	for _, test := range tests {
+		ft, err := m.FunctionType(test.index)
+		checkError(t, err, test.err)
+		if err == nil && ft.String() != test.want {
+			t.Errorf("function %d: type %s, expected %s", test.index, ft, test.want)
+		}
+	}
+	m.Functions[0] = 5
+	_, err = m.FunctionType(1)
+	checkError(t, err, "no type with index 5")
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err string
+	}{
+		{"empty", []byte{}, "invalid magic number"},
+		{"bad magic", []byte{0x00, 'a', 's', 'n', 0x01, 0x00, 0x00, 0x00}, "invalid magic number"},
+		{"truncated version", concat(magic, []byte{0x01}), truncated},
+		{"bad version", concat(magic, []byte{0x02, 0x00, 0x00, 0x00}), "unsupported version 2"},
+		{"truncated section size", concat(module(), []byte{byte(SectionType), 0x80}), truncated},
+		{"truncated section", concat(module(), []byte{byte(SectionType), 0x05, 0x01}), "type section of 5 bytes is truncated"},
+		{"unknown section", module(section(14)), "unknown section id 14"},
+		{"trailing bytes", module(section(SectionStart, leb(0), []byte{0x00})), "1 unexpected bytes at the end of the section"},
+		{"too many elements", module(section(SectionFunction, leb(3), leb(0))), "vector of 3 elements does not fit"},
+		{"truncated element", module(section(SectionFunction, leb(1), []byte{0x80})), truncated},
+		{"bad type form", module(section(SectionType, vec([]byte{0x50, 0x00, 0x00}))), "unsupported type form 0x50"},
+		{"bad value type", module(section(SectionType, vec([]byte{0x60, 0x01, 0x55, 0x00}))), "invalid value type 0x55"},
+		{"bad limits", module(section(SectionMemory, vec([]byte{0x08, 0x00}))), "invalid limits flags 0x08"},
+		{"bad mutability", module(section(SectionGlobal, vec([]byte{byte(I32), 0x02, 0x41, 0x00, 0x0b}))), "invalid mutability 0x02"},
+		{"truncated global init", module(section(SectionGlobal, vec([]byte{byte(I32), 0x00, 0x41, 0x00}))), truncated},
+		{"bad import kind", module(section(SectionImport, vec(concat(name("a"), name("b"), []byte{0x05, 0x00})))), "invalid import kind 0x05"},
+		{"bad export kind", module(section(SectionExport, vec(concat(name("a"), []byte{0x05, 0x00})))), "invalid export kind 0x05"},
+		{"bad export name", module(section(SectionExport, vec([]byte{0x01, 0xff, 0x00, 0x00}))), "invalid UTF-8 name"},
+		{"truncated custom name", module(section(SectionCustom, []byte{0x04, 'n'})), "does not fit"},
+		{"truncated body", module(section(SectionCode, vec([]byte{0x05, 0x00, 0x0b}))), "vector of 5 elements does not fit"},
+		{"locals exceed body", module(section(SectionCode, vec([]byte{0x02, 0x01, 0x01, byte(I32)}))), "locals exceed the size of the function body"},
+		{"missing bodies", module(typeSection, functionSection, section(SectionCode, vec(body(vec(), 0x0b)))), "2 functions declared but 1 bodies defined"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.data)
+			checkError(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var ErrUnexpectedEnd = errors.New("unexpected end of input")
+
+// Reads the primitive values of the binary format from a byte slice
+type reader struct {
+	data []byte
+	pos int
+	base int // Offset of data in the whole module, for error messages
+}
+
+func newReader(data []byte, base int) *reader {
+	return &reader{data: data, base: base}
+}
+
+func (r *reader) offset() int {
+	return r.base + r.pos
+}
+
+func (r *reader) eof() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *reader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset 0x%x: %s", r.offset(), fmt.Sprintf(format, args...))
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, r.errorf("%v", ErrUnexpectedEnd)
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) peek() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, r.errorf("%v", ErrUnexpectedEnd)
+	}
+	return r.data[r.pos], nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos + n > len(r.data) {
+		return nil, r.errorf("%v", ErrUnexpectedEnd)
+	}
+	b := r.data[r.pos:r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// Read an unsigned LEB128 integer of at most the given number of bits
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits || (shift + 7 > bits && uint64(b & 0x7f) >> (bits - shift) != 0) {
+			return 0, r.errorf("integer too large")
+		}
+		result |= uint64(b & 0x7f) << shift
+		shift += 7
+		if b & 0x80 == 0 {
+			return result, nil
+		}
+	}
+}
+
+// Read a signed LEB128 integer of at most the given number of bits
+func (r *reader) sleb(bits uint) (int64, error) {
+	var result int64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, r.errorf("integer too large")
+		}
+		if shift + 7 > bits {
+			// The unused bits of the last byte must repeat the sign bit
+			used := bits - shift
+			mask := byte(0x7f) >> (used - 1) << (used - 1)
+			if signs := b & mask; signs != 0 && signs != mask {
+				return 0, r.errorf("integer too large")
+			}
+		}
+		result |= int64(b & 0x7f) << shift
+		shift += 7
+		if b & 0x80 == 0 {
+			if shift < 64 && b & 0x40 != 0 {
+				result |= -1 << shift // Sign extend
+			}
+			return result, nil
+		}
+	}
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+func (r *reader) u64() (uint64, error) {
+	return r.uleb(64)
+}
+
+// Read a vector length, checking that it can plausibly fit in the remaining input
+func (r *reader) count() (int, error) {
+	n, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+	if int(n) > len(r.data) - r.pos {
+		return 0, r.errorf("vector of %d elements does not fit in the input", n)
+	}
+	return int(n), nil
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.count()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", r.errorf("invalid UTF-8 name")
+	}
+	return string(b), nil
+}
@@ -0,0 +1,133 @@
+package wasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// Check that err is nil if want is empty, and contains want otherwise
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" && err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+		t.Fatalf("expected an error containing %q, got %v", want, err)
+	}
+}
+
+const tooLarge = "integer too large"
+
+var truncated = ErrUnexpectedEnd.Error()
+
+func TestUleb(t *testing.T) {
+	tests := []struct {
+		name string
+		bits uint
+		input []byte
+		want uint64
+		err string
+	}{
+		{"zero", 32, []byte{0x00}, 0, ""},
+		{"one byte", 32, []byte{0x7f}, 127, ""},
+		{"two bytes", 32, []byte{0x80, 0x01}, 128, ""},
+		{"overlong zero", 32, []byte{0x80, 0x80, 0x80, 0x80, 0x00}, 0, ""},
+		{"overlong one", 32, []byte{0x81, 0x80, 0x00}, 1, ""},
+		{"max u32", 32, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, 0xffffffff, ""},
+		{"u32 overflow", 32, []byte{0xff, 0xff, 0xff, 0xff, 0x1f}, 0, tooLarge},
+		{"unused bits set", 32, []byte{0x80, 0x80, 0x80, 0x80, 0x70}, 0, tooLarge},
+		{"more than 5 bytes", 32, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, 0, tooLarge},
+		{"max u64", 64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, 0xffffffffffffffff, ""},
+		{"u64 overflow", 64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, 0, tooLarge},
+		{"u32 in u64", 64, []byte{0x80, 0x80, 0x80, 0x80, 0x10}, 1 << 32, ""},
+		{"empty", 32, []byte{}, 0, truncated},
+		{"truncated", 32, []byte{0x80}, 0, truncated},
+		{"truncated after bytes", 64, []byte{0xff, 0xff, 0xff}, 0, truncated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newReader(test.input, 0)
+			got, err := r.uleb(test.bits)
+			checkError(t, err, test.err)
+			if err == nil && got != test.want {
+				t.Fatalf("got %d, expected %d", got, test.want)
+			}
+			if err == nil && !r.eof() {
+				t.Fatalf("%d bytes left unread", len(r.data) - r.pos)
+			}
+		})
+	}
+}
+
+func TestSleb(t *testing.T) {
+	tests := []struct {
+		name string
+		bits uint
+		input []byte
+		want int64
+		err string
+	}{
+		{"zero", 32, []byte{0x00}, 0, ""},
+		{"minus one", 32, []byte{0x7f}, -1, ""},
+		{"positive one byte", 32, []byte{0x3f}, 63, ""},
+		{"negative one byte", 32, []byte{0x40}, -64, ""},
+		{"positive with sign bit in second byte", 32, []byte{0xc0, 0x00}, 64, ""},
+		{"negative two bytes", 32, []byte{0x80, 0x7f}, -128, ""},
+		{"overlong minus one", 32, []byte{0xff, 0xff, 0xff, 0xff, 0x7f}, -1, ""},
+		{"max i32", 32, []byte{0xff, 0xff, 0xff, 0xff, 0x07}, 2147483647, ""},
+		{"min i32", 32, []byte{0x80, 0x80, 0x80, 0x80, 0x78}, -2147483648, ""},
+		{"i32 overflow", 32, []byte{0x80, 0x80, 0x80, 0x80, 0x08}, 0, tooLarge},
+		{"unused bits differ from sign", 32, []byte{0xff, 0xff, 0xff, 0xff, 0x4f}, 0, tooLarge},
+		{"more than 5 bytes", 32, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, 0, tooLarge},
+		{"empty block type", 33, []byte{0x40}, -64, ""},
+		{"value block type", 33, []byte{0x7f}, -1, ""},
+		{"max type index", 33, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, 0xffffffff, ""},
+		{"s33 overflow", 33, []byte{0x80, 0x80, 0x80, 0x80, 0x10}, 0, tooLarge},
+		{"max i64", 64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}, 9223372036854775807, ""},
+		{"min i64", 64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}, -9223372036854775808, ""},
+		{"i64 overflow", 64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, 0, tooLarge},
+		{"empty", 32, []byte{}, 0, truncated},
+		{"truncated", 64, []byte{0xff}, 0, truncated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newReader(test.input, 0)
+			got, err := r.sleb(test.bits)
+			checkError(t, err, test.err)
+			if err == nil && got != test.want {
+				t.Fatalf("got %d, expected %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNameAndCount(t *testing.T) {
+	tests := []struct {
+		name string
+		input []byte
+		want string
+		err string
+	}{
+		{"empty name", []byte{0x00}, "", ""},
+		{"ascii", []byte{0x03, 'e', 'n', 'v'}, "env", ""},
+		{"utf-8", []byte{0x02, 0xc3, 0xa9}, "é", ""},
+		{"invalid utf-8", []byte{0x01, 0xff}, "", "invalid UTF-8 name"},
+		{"longer than input", []byte{0x05, 'a'}, "", "does not fit in the input"},
+		{"truncated length", []byte{0x80}, "", truncated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := newReader(test.input, 0).name()
+			checkError(t, err, test.err)
+			if err == nil && got != test.want {
+				t.Fatalf("got %q, expected %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorOffset(t *testing.T) {
+	r := newReader([]byte{0x80}, 0x20)
+	_, err := r.u32()
+	checkError(t, err, "at offset 0x21")
+}
@@ -0,0 +1,7 @@
+module wasmstats
+
+go 1.21
+
+require wasm v0.0.0
+
+replace wasm => ../wasm
@@ -0,0 +1,184 @@
+// Command wasmstats answers the questions of the analysis README in a single
+// pass over the modules of the bytecode directory, without relying on
+// wassail. All reports are written to wasmstats.json, and each report is also
+// written to its own CSV file in the output directory.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"wasm"
+)
+
+func ReadEntries(path string) [][]string {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Cannot open file %s: %v", path, err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		log.Fatalf("Cannot read from file %s: %v", path, err)
+	}
+	return records
+}
+
+func WriteCSV(path string, header []string, rows [][]string) {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Cannot open file %s: %v", path, err)
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	if err := w.Write(header); err != nil {
+		log.Fatalf("Cannot write to file %s: %v", path, err)
+	}
+	if err := w.WriteAll(rows); err != nil {
+		log.Fatalf("Cannot write to file %s: %v", path, err)
+	}
+}
+
+func WriteJSON(path string, v interface{}) {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("Cannot encode %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		log.Fatalf("Cannot write to file %s: %v", path, err)
+	}
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func moduleCounts(counts []ModuleCount) [][]string {
+	rows := make([][]string, len(counts))
+	for i, c := range counts {
+		rows[i] = []string{c.Hash, itoa(c.Count)}
+	}
+	return rows
+}
+
+func moduleFunctions(functions []ModuleFunction) [][]string {
+	rows := make([][]string, len(functions))
+	for i, f := range functions {
+		rows[i] = []string{f.Hash, f.Name, f.Type}
+	}
+	return rows
+}
+
+func functionUsages(usages []FunctionUsage) [][]string {
+	rows := make([][]string, len(usages))
+	for i, u := range usages {
+		rows[i] = []string{u.Name, u.Type, itoa(u.Modules)}
+	}
+	return rows
+}
+
+// Write each report to its own CSV file, named after the files produced by the scripts
+func WriteCSVs(dir string, r Report) {
+	path := func(name string) string { return filepath.Join(dir, name + ".csv") }
+	rows := make([][]string, 0)
+	for _, d := range r.UsePerDomain {
+		rows = append(rows, []string{d.Domain, itoa(d.Count)})
+	}
+	WriteCSV(path("use-per-domain"), []string{"domain", "scripts"}, rows)
+	WriteCSV(path("use-per-module"), []string{"hash", "domains"}, moduleCounts(r.UsePerModule))
+	WriteCSV(path("import-modules"), []string{"hash", "name", "type"}, moduleFunctions(r.ImportModules))
+	WriteCSV(path("import-usages"), []string{"name", "type", "usages"}, functionUsages(r.ImportUsages))
+	WriteCSV(path("imports-per-module"), []string{"hash", "imports"}, moduleCounts(r.ImportsPerModule))
+	WriteCSV(path("export-modules"), []string{"hash", "name", "type"}, moduleFunctions(r.ExportModules))
+	WriteCSV(path("export-usages"), []string{"name", "type", "usages"}, functionUsages(r.ExportUsages))
+	WriteCSV(path("exports-per-module"), []string{"hash", "exports"}, moduleCounts(r.ExportsPerModule))
+	rows = make([][]string, 0)
+	for _, s := range r.SectionSizes {
+		rows = append(rows, []string{s.Section, itoa(s.Min), itoa(s.Max), ftoa(s.Median), ftoa(s.Mean)})
+	}
+	WriteCSV(path("section-sizes"), []string{"section", "min", "max", "median", "mean"}, rows)
+	rows = make([][]string, 0)
+	for _, m := range r.Memories {
+		rows = append(rows, []string{m.Hash, itoa(m.Imported), itoa(m.Exported)})
+	}
+	WriteCSV(path("memories"), []string{"hash", "imported", "exported"}, rows)
+	rows = make([][]string, 0)
+	for _, f := range r.Features {
+		rows = append(rows, []string{f.Hash, strings.Join(f.Features, " ")})
+	}
+	WriteCSV(path("extended-features"), []string{"hash", "features"}, rows)
+	rows = make([][]string, 0)
+	for _, i := range r.Instructions {
+		rows = append(rows, []string{i.Instruction, itoa(i.Count)})
+	}
+	WriteCSV(path("instructions"), []string{"instruction", "count"}, rows)
+	WriteCSV(path("sizes"), []string{"hash", "size"}, moduleCounts(r.BinarySizes))
+	rows = make([][]string, 0)
+	for _, e := range r.Errors {
+		rows = append(rows, []string{e.Hash, e.Error})
+	}
+	WriteCSV(path("errors"), []string{"hash", "error"}, rows)
+}
+
+func main() {
+	bytecodeDir := flag.String("bytecode", "bytecode", "directory containing the .wasm files, named after their hash")
+	resultsCSV := flag.String("results", "results.csv", "CSV file listing the modules found on each page, ignored if missing")
+	outDir := flag.String("out", ".", "directory in which the reports are written")
+	flag.Parse()
+
+	files, err := filepath.Glob(filepath.Join(*bytecodeDir, "*.wasm"))
+	if err != nil {
+		log.Fatalf("Cannot list modules: %v", err)
+	}
+	sort.Strings(files)
+	collector := NewCollector()
+	hashes := make([]string, 0, len(files))
+	for i, file := range files {
+		fmt.Fprintf(os.Stderr, "\r%d/%d", i + 1, len(files))
+		hash := strings.TrimSuffix(filepath.Base(file), ".wasm")
+		hashes = append(hashes, hash)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Cannot read %s: %v", file, err)
+		}
+		module, err := wasm.Parse(data)
+		if err != nil {
+			collector.AddError(hash, len(data), err)
+			continue
+		}
+		collector.Add(hash, len(data), module)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+	if _, err := os.Stat(*resultsCSV); err == nil {
+		collector.AddResults(ReadEntries(*resultsCSV), hashes)
+	} else {
+		log.Printf("No %s, skipping the per-domain and per-module uses", *resultsCSV)
+	}
+
+	report := collector.Report()
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatalf("Cannot create %s: %v", *outDir, err)
+	}
+	WriteJSON(filepath.Join(*outDir, "wasmstats.json"), report)
+	WriteCSVs(*outDir, report)
+
+	fmt.Printf("%d modules, %d could not be parsed\n", report.Modules, len(report.Errors))
+	fmt.Printf("Binary size: min %d, max %d, median %s, mean %s bytes\n", report.BinarySize.Min, report.BinarySize.Max, ftoa(report.BinarySize.Median), ftoa(report.BinarySize.Mean))
+	fmt.Printf("%d modules import their memory, while %d do not\n", report.MemoryImports, report.Modules - len(report.Errors) - report.MemoryImports)
+	fmt.Printf("%d modules export their memory, while %d do not\n", report.MemoryExports, report.Modules - len(report.Errors) - report.MemoryExports)
+	fmt.Printf("%d modules do not use extended features, while %d do\n", report.Modules - len(report.Errors) - len(report.Features), len(report.Features))
+	fmt.Printf("Reports written to %s\n", *outDir)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"wasm"
+)
+
+// Minimum, maximum, median and mean of a set of values, as computed by the analysis scripts
+type Distribution struct {
+	Count int
+	Min int
+	Max int
+	Median float64
+	Mean float64
+}
+
+func DistributionOf(values []int) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	n := len(sorted)
+	d := Distribution{Count: n, Min: sorted[0], Max: sorted[n-1]}
+	if n % 2 == 1 {
+		d.Median = float64(sorted[n/2])
+	} else {
+		d.Median = float64(sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	total := 0
+	for _, v := range sorted {
+		total += v
+	}
+	d.Mean = float64(total) / float64(n)
+	return d
+}
+
+// A function imported or exported by a module
+type ModuleFunction struct {
+	Hash string
+	Name string
+	Type string
+}
+
+// How many modules import or export a function with a given name and type
+type FunctionUsage struct {
+	Name string
+	Type string
+	Modules int
+}
+
+type ModuleCount struct {
+	Hash string
+	Count int
+}
+
+type DomainCount struct {
+	Domain string
+	Count int
+}
+
+type InstructionCount struct {
+	Instruction string
+	Count int
+}
+
+type SectionSizes struct {
+	Section string
+	Distribution
+}
+
+// Memories imported and exported by a module
+type ModuleMemories struct {
+	Hash string
+	Imported int
+	Exported int
+}
+
+// Extensions of the WebAssembly MVP used by a module
+type ModuleFeatures struct {
+	Hash string
+	Features []string
+}
+
+type ModuleError struct {
+	Hash string
+	Error string
+}
+
+// All the reports, produced from a single pass over the modules
+type Report struct {
+	Modules int
+	Errors []ModuleError
+	UsePerDomain []DomainCount // Number of scripts found on each domain
+	UsePerModule []ModuleCount // Number of domains that use each module
+	ImportModules []ModuleFunction
+	ImportUsages []FunctionUsage
+	ImportsPerModule []ModuleCount
+	ExportModules []ModuleFunction
+	ExportUsages []FunctionUsage
+	ExportsPerModule []ModuleCount
+	SectionSizes []SectionSizes
+	Memories []ModuleMemories
+	MemoryImports int // Number of modules that import their memory
+	MemoryExports int // Number of modules that export their memory
+	Features []ModuleFeatures
+	Instructions []InstructionCount
+	BinarySizes []ModuleCount
+	BinarySize Distribution
+}
+
+// Accumulates the data of each module to produce a report
+type Collector struct {
+	report Report
+	sectionSizes map[wasm.SectionID][]int
+	binarySizes []int
+	instructions map[string]int
+	importUsages map[ModuleFunction]int
+	exportUsages map[ModuleFunction]int
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		sectionSizes: make(map[wasm.SectionID][]int),
+		instructions: make(map[string]int),
+		importUsages: make(map[ModuleFunction]int),
+		exportUsages: make(map[ModuleFunction]int),
+	}
+}
+
+// Record a module that could not be parsed. It still counts in the binary sizes.
+func (c *Collector) AddError(hash string, size int, err error) {
+	c.report.Modules++
+	c.report.Errors = append(c.report.Errors, ModuleError{hash, err.Error()})
+	c.report.BinarySizes = append(c.report.BinarySizes, ModuleCount{hash, size})
+	c.binarySizes = append(c.binarySizes, size)
+}
+
+func (c *Collector) Add(hash string, size int, m *wasm.Module) error {
+	// Decode the code first, so that a module with invalid code is not partially counted
+	instructions := make(map[string]int)
+	features := make(map[string]bool)
+	for _, body := range m.Code {
+		err := wasm.WalkInstructions(body.Code, body.Offset, func(instr wasm.Instruction) error {
+			instructions[instr.Opcode.String()]++
+			if feature := featureOf(instr); feature != "" {
+				features[feature] = true
+			}
+			return nil
+		})
+		if err != nil {
+			c.AddError(hash, size, err)
+			return err
+		}
+	}
+	c.report.Modules++
+	for name, count := range instructions {
+		c.instructions[name] += count
+	}
+	c.report.BinarySizes = append(c.report.BinarySizes, ModuleCount{hash, size})
+	c.binarySizes = append(c.binarySizes, size)
+	for _, id := range wasm.StandardSections {
+		c.sectionSizes[id] = append(c.sectionSizes[id], m.SectionSize(id))
+	}
+
+	imports := m.ImportsOf(wasm.ExternalFunction)
+	for _, imp := range imports {
+		f := ModuleFunction{Hash: hash, Name: imp.Name, Type: typeName(m, imp.TypeIndex)}
+		c.report.ImportModules = append(c.report.ImportModules, f)
+		c.importUsages[ModuleFunction{Name: f.Name, Type: f.Type}]++
+	}
+	c.report.ImportsPerModule = append(c.report.ImportsPerModule, ModuleCount{hash, len(imports)})
+
+	exports := m.ExportsOf(wasm.ExternalFunction)
+	for _, exp := range exports {
+		t := "?"
+		if ft, err := m.FunctionType(exp.Index); err == nil {
+			t = ft.String()
+		}
+		f := ModuleFunction{Hash: hash, Name: exp.Name, Type: t}
+		c.report.ExportModules = append(c.report.ExportModules, f)
+		c.exportUsages[ModuleFunction{Name: f.Name, Type: f.Type}]++
+	}
+	c.report.ExportsPerModule = append(c.report.ExportsPerModule, ModuleCount{hash, len(exports)})
+
+	memories := ModuleMemories{hash, len(m.ImportsOf(wasm.ExternalMemory)), len(m.ExportsOf(wasm.ExternalMemory))}
+	c.report.Memories = append(c.report.Memories, memories)
+	if memories.Imported > 0 {
+		c.report.MemoryImports++
+	}
+	if memories.Exported > 0 {
+		c.report.MemoryExports++
+	}
+
+	for _, t := range m.Types {
+		if len(t.Results) > 1 {
+			features["multi-value"] = true
+		}
+	}
+	for _, g := range m.ImportsOf(wasm.ExternalGlobal) {
+		if g.Global.Mutable {
+			features["mutable-globals"] = true
+		}
+	}
+	for _, exp := range m.ExportsOf(wasm.ExternalGlobal) {
+		if int(exp.Index) < len(m.ImportsOf(wasm.ExternalGlobal)) {
+			continue
+		}
+		if index := int(exp.Index) - len(m.ImportsOf(wasm.ExternalGlobal)); index < len(m.Globals) && m.Globals[index].Type.Mutable {
+			features["mutable-globals"] = true
+		}
+	}
+	if len(features) > 0 {
+		names := make([]string, 0, len(features))
+		for name := range features {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.report.Features = append(c.report.Features, ModuleFeatures{hash, names})
+	}
+	return nil
+}
+
+// The extension of the MVP that an instruction belongs to, among the ones
+// checked by use-extended-features.sh, or "" if it is part of the MVP
+func featureOf(instr wasm.Instruction) string {
+	op := instr.Opcode
+	switch {
+	case op >= 0xc0 && op <= 0xc4:
+		return "sign-extension"
+	case op >> 24 == wasm.PrefixMisc && op & 0xffffff <= 7:
+		return "saturating-float-to-int"
+	case (op == wasm.OpBlock || op == wasm.OpLoop || op == wasm.OpIf) && int64(instr.Immediates[0]) >= 0:
+		// A block type given by a type index, allowing multiple values
+		return "multi-value"
+	}
+	return ""
+}
+
+func typeName(m *wasm.Module, index uint32) string {
+	if int(index) < len(m.Types) {
+		return m.Types[index].String()
+	}
+	return fmt.Sprintf("type-%d", index)
+}
+
+func usagesOf(usages map[ModuleFunction]int) []FunctionUsage {
+	result := make([]FunctionUsage, 0, len(usages))
+	for f, count := range usages {
+		result = append(result, FunctionUsage{f.Name, f.Type, count})
+	}
+	// Sorted by increasing usage, as produced by the scripts after sort -g
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Modules != result[j].Modules {
+			return result[i].Modules < result[j].Modules
+		}
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Type < result[j].Type
+	})
+	return result
+}
+
+// Count, for each domain, the number of scripts found, and for each module, the number of domains that use it.
+// Entries of results.csv are: domain, page, script URL, script hash, calling script URL, calling script hash.
+func (c *Collector) AddResults(entries [][]string, hashes []string) {
+	perDomain := make(map[string]int)
+	domainsOf := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		if len(entry) < 4 {
+			continue
+		}
+		perDomain[entry[0]]++
+		if domainsOf[entry[3]] == nil {
+			domainsOf[entry[3]] = make(map[string]bool)
+		}
+		domainsOf[entry[3]][entry[0]] = true
+	}
+	for domain, count := range perDomain {
+		c.report.UsePerDomain = append(c.report.UsePerDomain, DomainCount{domain, count})
+	}
+	sort.Slice(c.report.UsePerDomain, func(i, j int) bool {
+		a, b := c.report.UsePerDomain[i], c.report.UsePerDomain[j]
+		return a.Count < b.Count || (a.Count == b.Count && a.Domain < b.Domain)
+	})
+	for _, hash := range hashes {
+		c.report.UsePerModule = append(c.report.UsePerModule, ModuleCount{hash, len(domainsOf[hash])})
+	}
+}
+
+// Finalize the report once all modules have been added
+func (c *Collector) Report() Report {
+	r := c.report
+	r.ImportUsages = usagesOf(c.importUsages)
+	r.ExportUsages = usagesOf(c.exportUsages)
+	for _, id := range wasm.StandardSections {
+		r.SectionSizes = append(r.SectionSizes, SectionSizes{id.String(), DistributionOf(c.sectionSizes[id])})
+	}
+	for name, count := range c.instructions {
+		r.Instructions = append(r.Instructions, InstructionCount{name, count})
+	}
+	sort.Slice(r.Instructions, func(i, j int) bool {
+		a, b := r.Instructions[i], r.Instructions[j]
+		return a.Count < b.Count || (a.Count == b.Count && a.Instruction < b.Instruction)
+	})
+	r.BinarySize = DistributionOf(c.binarySizes)
+	return r
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"wasm"
+)
+
+var (
+	hashA = strings.Repeat("a", 64)
+	hashB = strings.Repeat("b", 64)
+	hashC = strings.Repeat("c", 64) // Found on a page, but not in bytecode
+)
+
+// The smallest module, 8 bytes
+var emptyModule = []byte("\x00asm\x01\x00\x00\x00")
+
+// A module with a type section and a custom section, 19 bytes
+var typeModule = append(append([]byte(nil), emptyModule...),
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // One function type [] -> []
+	0x00, 0x03, 0x02, 'a', 'b', // Custom section named "ab"
+)
+
+// Entries of results.csv: domain, page, script URL, script hash, calling script URL, calling script hash.
+// As written by findscript, the entries of a domain follow each other.
+var results = [][]string{
+	{"a.com", "http://a.com/", "http://a.com/x.wasm", hashA, "http://a.com/x.js", "js1"},
+	{"a.com", "http://a.com/page", "http://a.com/x.wasm", hashA, "http://a.com/x.js", "js1"},
+	{"b.com", "http://b.com/", "http://b.com/y.wasm", hashA, "", ""},
+	{"b.com", "http://b.com/", "http://b.com/z.wasm", hashB, "", ""},
+	{"c.com", "http://c.com/", "http://c.com/w.wasm", hashC, "", ""},
+}
+
+func collect(t *testing.T) Report {
+	t.Helper()
+	c := NewCollector()
+	for _, module := range []struct {
+		hash string
+		data []byte
+	}{{hashA, emptyModule}, {hashB, typeModule}} {
+		m, err := wasm.Parse(module.data)
+		if err != nil {
+			t.Fatalf("cannot parse module %s: %v", module.hash, err)
+		}
+		if err := c.Add(module.hash, len(module.data), m); err != nil {
+			t.Fatalf("cannot add module %s: %v", module.hash, err)
+		}
+	}
+	c.AddResults(results, []string{hashA, hashB})
+	return c.Report()
+}
+
+func TestReport(t *testing.T) {
+	r := collect(t)
+	if want := []DomainCount{{"c.com", 1}, {"a.com", 2}, {"b.com", 2}}; !reflect.DeepEqual(r.UsePerDomain, want) {
+		t.Errorf("use per domain %v, expected %v", r.UsePerDomain, want)
+	}
+	if want := []ModuleCount{{hashA, 2}, {hashB, 1}}; !reflect.DeepEqual(r.UsePerModule, want) {
+		t.Errorf("use per module %v, expected %v", r.UsePerModule, want)
+	}
+	if want := (Distribution{Count: 2, Min: 8, Max: 19, Median: 13.5, Mean: 13.5}); r.BinarySize != want {
+		t.Errorf("binary size %+v, expected %+v", r.BinarySize, want)
+	}
+	if r.Modules != 2 || len(r.Errors) != 0 {
+		t.Errorf("%d modules, errors %v", r.Modules, r.Errors)
+	}
+}
+
+func TestDistributionOf(t *testing.T) {
+	tests := []struct {
+		values []int
+		want Distribution
+	}{
+		{nil, Distribution{}},
+		{[]int{5}, Distribution{1, 5, 5, 5, 5}},
+		// The median of an odd number of values is the middle one
+		{[]int{10, 1, 4}, Distribution{3, 1, 10, 4, 5}},
+		// The median of an even number of values is the mean of the two middle ones
+		{[]int{10, 1, 4, 2}, Distribution{4, 1, 10, 3, 4.25}},
+		{[]int{7, 7, 1, 100}, Distribution{4, 1, 100, 7, 28.75}},
+	}
+	for _, test := range tests {
+		if got := DistributionOf(test.values); got != test.want {
+			t.Errorf("distribution of %v: %+v, expected %+v", test.values, got, test.want)
+		}
+	}
+}
+
+// Run an analysis script in the given directory, returns the fields of each line of its output
+func runScript(t *testing.T, dir string, script string) [][]string {
+	t.Helper()
+	path, err := filepath.Abs(filepath.Join("..", "..", script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", path)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s failed: %v", script, err)
+	}
+	lines := make([][]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "-e" {
+			// echo -e is not understood by every sh
+			fields = fields[1:]
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+// The reports agree with the analysis scripts on the same files
+func TestReportMatchesScripts(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the scripts")
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bytecode"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for hash, data := range map[string][]byte{hashA: emptyModule, hashB: typeModule} {
+		if err := os.WriteFile(filepath.Join(dir, "bytecode", hash + ".wasm"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := make([]string, len(results))
+	for i, entry := range results {
+		lines[i] = strings.Join(entry, ",")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "results.csv"), []byte(strings.Join(lines, "\n") + "\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := collect(t)
+
+	// use-per-domain.sh: count and domain, by increasing count
+	perDomain := make([]DomainCount, 0)
+	for _, fields := range runScript(t, dir, "use-per-domain.sh") {
+		count, _ := strconv.Atoi(fields[0])
+		perDomain = append(perDomain, DomainCount{fields[1], count})
+	}
+	if !reflect.DeepEqual(r.UsePerDomain, perDomain) {
+		t.Errorf("use per domain %v, use-per-domain.sh gives %v", r.UsePerDomain, perDomain)
+	}
+
+	// use-per-module.sh: count and hash, by hash
+	perModule := make([]ModuleCount, 0)
+	for _, fields := range runScript(t, dir, "use-per-module.sh") {
+		count, _ := strconv.Atoi(fields[0])
+		perModule = append(perModule, ModuleCount{fields[1], count})
+	}
+	if !reflect.DeepEqual(r.UsePerModule, perModule) {
+		t.Errorf("use per module %v, use-per-module.sh gives %v", r.UsePerModule, perModule)
+	}
+
+	// binary-size.sh: "min: 8 bytes", and so on
+	sizes := make(map[string]float64)
+	for _, fields := range runScript(t, dir, "binary-size.sh") {
+		sizes[fields[0]], _ = strconv.ParseFloat(fields[1], 64)
+	}
+	want := map[string]float64{"min:": float64(r.BinarySize.Min), "max:": float64(r.BinarySize.Max), "median:": r.BinarySize.Median, "mean:": r.BinarySize.Mean}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("binary size %v, binary-size.sh gives %v", want, sizes)
+	}
+}
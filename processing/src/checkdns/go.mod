@@ -0,0 +1,3 @@
+module checkdns
+
+go 1.21
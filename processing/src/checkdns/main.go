@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load all lines of a file, returns no line if the file does not exist
+func LinesInFile(file string) []string {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return []string{}
+	}
+	if err != nil {
+		log.Fatalf("Can't open file: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+	result := []string{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Can't read file %s: %v", file, err)
+	}
+	return result
+}
+
+var scraped []string
+// Load the URLs of the given result files into the `scraped` variable. Lines
+// of scripts.log also list the scripts found ("<url> [<script urls>]"), only
+// the URL that starts them is kept.
+func Init(files ...string) {
+	scraped = make([]string, 0)
+	for _, file := range files {
+		for _, line := range LinesInFile(file) {
+			url, _, _ := strings.Cut(line, " ")
+			scraped = append(scraped, url)
+		}
+	}
+	sort.Strings(scraped)
+}
+
+// Check if an URL has been scraped
+func IsSuccessfullyScraped(url string) bool {
+	idx := sort.SearchStrings(scraped, url)
+	return idx < len(scraped) && scraped[idx] == url
+}
+
+// The host name of an URL, empty if it cannot be found
+func HostOf(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// The outcome of the lookup of a record type
+type Outcome int
+
+const (
+	Found Outcome = iota // The record exists
+	NotFound // NXDOMAIN, or no record of this type: final
+	Failed // SERVFAIL, timeout or network error: may succeed when retried
+)
+
+// Performs DNS lookups. Implementations other than NetResolver can be used to
+// test the tool, or to query another kind of service.
+type Resolver interface {
+	// The addresses of a host for the given network ("ip4" for A records, "ip6" for AAAA records)
+	LookupIP(ctx context.Context, network string, host string) ([]net.IP, Outcome, error)
+	// The canonical name of a host, which is the host itself if it has no CNAME record
+	LookupCNAME(ctx context.Context, host string) (string, Outcome, error)
+}
+
+// A resolver using the DNS client of Go, either with the resolvers of the
+// system or with the given servers, queried in turn
+type NetResolver struct {
+	resolver *net.Resolver
+}
+
+func NewNetResolver(servers []string) *NetResolver {
+	if len(servers) == 0 {
+		return &NetResolver{net.DefaultResolver}
+	}
+	var next uint32
+	return &NetResolver{&net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			server := servers[int(atomic.AddUint32(&next, 1)) % len(servers)]
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}}
+}
+
+// Classify the error of a lookup
+func outcomeOf(err error) Outcome {
+	if err == nil {
+		return Found
+	}
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) && dnsError.IsNotFound {
+		return NotFound
+	}
+	return Failed
+}
+
+func (r *NetResolver) LookupIP(ctx context.Context, network string, host string) ([]net.IP, Outcome, error) {
+	ips, err := r.resolver.LookupIP(ctx, network, host)
+	if err == nil && len(ips) == 0 {
+		return ips, NotFound, nil
+	}
+	return ips, outcomeOf(err), err
+}
+
+func (r *NetResolver) LookupCNAME(ctx context.Context, host string) (string, Outcome, error) {
+	cname, err := r.resolver.LookupCNAME(ctx, host)
+	return cname, outcomeOf(err), err
+}
+
+// What is known about a host
+type Check struct {
+	Host string
+	URLs []string // The URLs of the host to check
+	Valid bool // Whether the host has an A or AAAA record
+	Final bool // Whether the answer is final, otherwise the lookups kept failing and the host is checked again on the next run
+	A int // Number of A records
+	AAAA int // Number of AAAA records
+	CNAME string // The canonical name of the host, empty if the host has no CNAME record
+	Err string // The last error, if the answer is not final
+}
+
+// Summary of the records of a host, written in the records file
+func (c Check) Records() string {
+	records := fmt.Sprintf("a=%d aaaa=%d", c.A, c.AAAA)
+	if c.CNAME != "" {
+		records += " cname=" + c.CNAME
+	}
+	return records
+}
+
+var (
+	resolver Resolver
+	lookupTimeout time.Duration
+	maxAttempts int
+	backoff time.Duration
+)
+
+// Look up one record type, retrying the lookups that fail (e.g., SERVFAIL)
+// until the answer is final or the attempts are exhausted
+func lookup(what string, host string, attempt func(ctx context.Context) (Outcome, error)) (Outcome, error) {
+	var outcome Outcome
+	var err error
+	for i := 0; i < maxAttempts; i++ {
+		if i > 0 {
+			<-time.After(backoff * time.Duration(1 << (i - 1)))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		outcome, err = attempt(ctx)
+		cancel()
+		if outcome != Failed {
+			return outcome, err
+		}
+	}
+	return outcome, fmt.Errorf("%s lookup of %s failed after %d attempts: %v", what, host, maxAttempts, err)
+}
+
+// Check the A, AAAA and CNAME records of a host
+func CheckHost(check Check) Check {
+	outcomes := make([]Outcome, 0, 2)
+	for _, record := range []struct{ name, network string; count *int }{{"A", "ip4", &check.A}, {"AAAA", "ip6", &check.AAAA}} {
+		outcome, err := lookup(record.name, check.Host, func(ctx context.Context) (Outcome, error) {
+			ips, outcome, err := resolver.LookupIP(ctx, record.network, check.Host)
+			*record.count = len(ips)
+			return outcome, err
+		})
+		if outcome == Failed {
+			check.Err = err.Error()
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	check.Valid = check.A + check.AAAA > 0
+	// A host with an address is valid even if one of the lookups failed, a host without is only invalid if both lookups are final
+	check.Final = check.Valid || (outcomes[0] == NotFound && outcomes[1] == NotFound)
+	if check.Valid {
+		// The CNAME is only informative, its lookup is not retried
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		cname, outcome, _ := resolver.LookupCNAME(ctx, check.Host)
+		cancel()
+		if outcome == Found && strings.TrimSuffix(cname, ".") != strings.TrimSuffix(check.Host, ".") {
+			check.CNAME = cname
+		}
+	}
+	return check
+}
+
+// A file to which lines are appended
+type Output struct {
+	file *os.File
+	writer *bufio.Writer
+}
+
+// Open a file for appending, completing its last line if it has been truncated
+func OpenOutput(path string) *Output {
+	f, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE | os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatalf("Can't open %s to write: %v", path, err)
+	}
+	output := &Output{f, bufio.NewWriter(f)}
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size() - 1); err == nil && last[0] != '\n' {
+			output.writer.WriteString("\n")
+		}
+	}
+	return output
+}
+
+func (o *Output) WriteLine(line string) {
+	if _, err := o.writer.WriteString(line + "\n"); err != nil {
+		log.Fatalf("Can't write to %s: %v", o.file.Name(), err)
+	}
+}
+
+// Flush the lines written so far, so that they are kept if the tool is interrupted
+func (o *Output) Flush() {
+	if err := o.writer.Flush(); err != nil {
+		log.Fatalf("Can't write to %s: %v", o.file.Name(), err)
+	}
+}
+
+func (o *Output) Close() {
+	o.Flush()
+	o.file.Close()
+}
+
+// Check the hosts of the URLs of the input file that have not been scraped nor
+// checked by a previous run, with the given number of workers
+func FilterValidDNS(input string, validFile string, invalidFile string, recordsFile string, workers int) {
+	done := make(map[string]bool)
+	for _, url := range append(LinesInFile(validFile), LinesInFile(invalidFile)...) {
+		done[url] = true
+	}
+	hosts := make(map[string]*Check)
+	order := make([]string, 0)
+	skipped, urls := 0, 0
+	for _, url := range LinesInFile(input) {
+		if done[url] || IsSuccessfullyScraped(url) {
+			skipped += 1
+			continue
+		}
+		done[url] = true // Ignore duplicates
+		host := HostOf(url)
+		if hosts[host] == nil {
+			hosts[host] = &Check{Host: host}
+			order = append(order, host)
+		}
+		hosts[host].URLs = append(hosts[host].URLs, url)
+		urls += 1
+	}
+	log.Printf("Checking %d URLs on %d hosts, skipping %d URLs already scraped or checked", urls, len(order), skipped)
+
+	pending := make(chan Check)
+	checked := make(chan Check)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range pending {
+				if check.Host == "" {
+					// Malformed URL, no lookup can succeed
+					check.Final = true
+					check.Err = "no host name"
+					checked <- check
+					continue
+				}
+				checked <- CheckHost(check)
+			}
+		}()
+	}
+	go func() {
+		for _, host := range order {
+			pending <- *hosts[host]
+		}
+		close(pending)
+		wg.Wait()
+		close(checked)
+	}()
+
+	valid := OpenOutput(validFile)
+	invalid := OpenOutput(invalidFile)
+	records := OpenOutput(recordsFile)
+	var stats struct{ hosts, valid, invalid, unknown int }
+	report := func() {
+		fmt.Printf("\r%d/%d hosts: %d valid, %d invalid, %d unknown", stats.hosts, len(order), stats.valid, stats.invalid, stats.unknown)
+	}
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report()
+			continue
+		case check, ok := <-checked:
+			if !ok {
+				valid.Close()
+				invalid.Close()
+				records.Close()
+				report()
+				fmt.Println()
+				if stats.unknown > 0 {
+					log.Printf("%d hosts could not be checked, run again to retry them", stats.unknown)
+				}
+				return
+			}
+			stats.hosts += 1
+			output, status := invalid, "invalid"
+			switch {
+			case !check.Final:
+				stats.unknown += 1
+				log.Printf("Could not check %s: %s", check.Host, check.Err)
+				continue
+			case check.Valid:
+				stats.valid += 1
+				output, status = valid, "valid"
+			default:
+				stats.invalid += 1
+			}
+			// The records are written and flushed before the URLs, so that an URL
+			// in the valid or invalid files, which is not checked again, always has
+			// its records (a crash in between only makes the next run write them twice)
+			for _, url := range check.URLs {
+				records.WriteLine(fmt.Sprintf("%s\t%s\t%s", url, status, check.Records()))
+			}
+			records.Flush()
+			for _, url := range check.URLs {
+				output.WriteLine(url)
+			}
+			output.Flush()
+		}
+	}
+}
+
+// Read urls which resulted in a DNS error from dnserrors.log
+// First check if the url has actually been scraped in another scraping run, by going through the scripts.log and noscripts.log files
+// Output the URLs for which the DNS is actually valid (the host has an A or AAAA record) in dnserrors-valid.log
+// Output the ones for which the host does not exist or has no address in dnserrors-invalid.log
+// The records of each host (number of A and AAAA records, CNAME) are written in dnserrors-records.tsv
+// URLs already in dnserrors-valid.log or dnserrors-invalid.log are not checked again, so that an interrupted run can be resumed,
+// and hosts for which lookups keep failing (e.g., SERVFAIL) are written nowhere, to be checked again by the next run
+func main() {
+	workers := flag.Int("workers", 10, "number of hosts checked concurrently")
+	servers := flag.String("resolvers", "", "comma-separated DNS servers (host:port) to query in turn, the ones of the system if empty")
+	flag.DurationVar(&lookupTimeout, "timeout", 5 * time.Second, "how long a lookup can take")
+	flag.IntVar(&maxAttempts, "attempts", 3, "how many times a lookup is performed when it fails (e.g., SERVFAIL), NXDOMAIN being final")
+	flag.DurationVar(&backoff, "backoff", 1 * time.Second, "delay before performing a failed lookup again, doubled for each attempt")
+	input := flag.String("input", "dnserrors.log", "URLs to check")
+	validFile := flag.String("valid", "dnserrors-valid.log", "where URLs whose host has an address are written")
+	invalidFile := flag.String("invalid", "dnserrors-invalid.log", "where URLs whose host does not exist are written")
+	recordsFile := flag.String("records", "dnserrors-records.tsv", "where the records found for each URL are written")
+	flag.Parse()
+	if *workers < 1 || maxAttempts < 1 {
+		log.Fatalf("-workers and -attempts must be at least 1")
+	}
+	list := make([]string, 0)
+	for _, server := range strings.Split(*servers, ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		} else if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		list = append(list, server)
+	}
+	resolver = NewNetResolver(list)
+	Init("scripts.log", "noscripts.log")
+	FilterValidDNS(*input, *validFile, *invalidFile, *recordsFile, *workers)
+}
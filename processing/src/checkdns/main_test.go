@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The answer of the fake resolver to a lookup
+type answer struct {
+	ips int // Number of addresses returned
+	cname string
+	outcome Outcome
+}
+
+var (
+	servfail = answer{outcome: Failed}
+	nxdomain = answer{outcome: NotFound}
+)
+
+func found(ips int) answer {
+	return answer{ips: ips, outcome: Found}
+}
+
+// A stand-in DNS server, answering lookups from a script: each record type of
+// a host has a sequence of answers, the last one being repeated
+type FakeResolver struct {
+	lock sync.Mutex
+	answers map[string][]answer // By record type ("ip4", "ip6" or "cname") and host, e.g. "ip4 example.com"
+	lookups map[string]int // Number of lookups performed, by record type and host
+}
+
+func NewFakeResolver(answers map[string][]answer) *FakeResolver {
+	return &FakeResolver{answers: answers, lookups: make(map[string]int)}
+}
+
+func (r *FakeResolver) next(key string) answer {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	answers := r.answers[key]
+	i := r.lookups[key]
+	r.lookups[key] += 1
+	if len(answers) == 0 {
+		return nxdomain
+	}
+	return answers[min(i, len(answers) - 1)]
+}
+
+func errorOf(a answer) error {
+	switch a.outcome {
+	case NotFound:
+		return &net.DNSError{Err: "no such host", IsNotFound: true}
+	case Failed:
+		return &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+	}
+	return nil
+}
+
+func (r *FakeResolver) LookupIP(ctx context.Context, network string, host string) ([]net.IP, Outcome, error) {
+	a := r.next(network + " " + host)
+	ips := make([]net.IP, a.ips)
+	for i := range ips {
+		ips[i] = net.IPv4(192, 0, 2, byte(i + 1))
+	}
+	return ips, a.outcome, errorOf(a)
+}
+
+func (r *FakeResolver) LookupCNAME(ctx context.Context, host string) (string, Outcome, error) {
+	a := r.next("cname " + host)
+	return a.cname, a.outcome, errorOf(a)
+}
+
+// Use the given resolver, with retries that do not wait
+func useResolver(t *testing.T, r Resolver) {
+	previousResolver, previousTimeout, previousAttempts, previousBackoff := resolver, lookupTimeout, maxAttempts, backoff
+	resolver, lookupTimeout, maxAttempts, backoff = r, time.Second, 3, 0
+	t.Cleanup(func() {
+		resolver, lookupTimeout, maxAttempts, backoff = previousResolver, previousTimeout, previousAttempts, previousBackoff
+	})
+}
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		name string
+		answers map[string][]answer
+		want Check
+		lookups map[string]int // Number of lookups of each record type, all if nil
+		err string // Part of the error of checks that are not final
+	}{
+		{
+			name: "A and AAAA",
+			answers: map[string][]answer{"ip4 h": {found(2)}, "ip6 h": {found(1)}, "cname h": {{cname: "h.", outcome: Found}}},
+			want: Check{Host: "h", Valid: true, Final: true, A: 2, AAAA: 1},
+		},
+		{
+			name: "A only",
+			answers: map[string][]answer{"ip4 h": {found(1)}, "ip6 h": {nxdomain}},
+			want: Check{Host: "h", Valid: true, Final: true, A: 1},
+		},
+		{
+			name: "AAAA only",
+			answers: map[string][]answer{"ip4 h": {nxdomain}, "ip6 h": {found(1)}},
+			want: Check{Host: "h", Valid: true, Final: true, AAAA: 1},
+		},
+		{
+			name: "CNAME",
+			answers: map[string][]answer{"ip4 h": {found(1)}, "ip6 h": {nxdomain}, "cname h": {{cname: "cdn.example.net.", outcome: Found}}},
+			want: Check{Host: "h", Valid: true, Final: true, A: 1, CNAME: "cdn.example.net."},
+		},
+		{
+			name: "CNAME lookup failing",
+			answers: map[string][]answer{"ip4 h": {found(1)}, "ip6 h": {nxdomain}, "cname h": {servfail}},
+			want: Check{Host: "h", Valid: true, Final: true, A: 1},
+			// The CNAME is only informative, its lookup is not retried
+			lookups: map[string]int{"ip4 h": 1, "ip6 h": 1, "cname h": 1},
+		},
+		{
+			name: "NXDOMAIN is final",
+			answers: map[string][]answer{"ip4 h": {nxdomain}, "ip6 h": {nxdomain}},
+			want: Check{Host: "h", Final: true},
+			// Not retried, and no CNAME lookup for a host without address
+			lookups: map[string]int{"ip4 h": 1, "ip6 h": 1},
+		},
+		{
+			name: "SERVFAIL retried until found",
+			answers: map[string][]answer{"ip4 h": {servfail, servfail, found(1)}, "ip6 h": {servfail, nxdomain}},
+			want: Check{Host: "h", Valid: true, Final: true, A: 1},
+			lookups: map[string]int{"ip4 h": 3, "ip6 h": 2, "cname h": 1},
+		},
+		{
+			name: "SERVFAIL retried until NXDOMAIN",
+			answers: map[string][]answer{"ip4 h": {servfail, nxdomain}, "ip6 h": {nxdomain}},
+			want: Check{Host: "h", Final: true},
+			lookups: map[string]int{"ip4 h": 2, "ip6 h": 1},
+		},
+		{
+			name: "SERVFAIL on every attempt",
+			answers: map[string][]answer{"ip4 h": {servfail}, "ip6 h": {servfail}},
+			want: Check{Host: "h"},
+			lookups: map[string]int{"ip4 h": 3, "ip6 h": 3},
+			err: "AAAA lookup of h failed after 3 attempts",
+		},
+		{
+			name: "SERVFAIL and NXDOMAIN",
+			answers: map[string][]answer{"ip4 h": {servfail}, "ip6 h": {nxdomain}},
+			// The host may have an A record, it is checked again on the next run
+			want: Check{Host: "h"},
+			err: "A lookup of h failed after 3 attempts",
+		},
+		{
+			name: "address despite SERVFAIL",
+			answers: map[string][]answer{"ip4 h": {servfail}, "ip6 h": {found(1)}},
+			want: Check{Host: "h", Valid: true, Final: true, AAAA: 1},
+			err: "A lookup of h failed after 3 attempts",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := NewFakeResolver(test.answers)
+			useResolver(t, fake)
+			got := CheckHost(Check{Host: "h"})
+			if (test.err == "" && got.Err != "") || !strings.Contains(got.Err, test.err) {
+				t.Errorf("error %q, expected %q", got.Err, test.err)
+			}
+			got.Err = ""
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, expected %+v", got, test.want)
+			}
+			if test.lookups != nil && !reflect.DeepEqual(fake.lookups, test.lookups) {
+				t.Errorf("lookups %v, expected %v", fake.lookups, test.lookups)
+			}
+		})
+	}
+}
+
+func TestOutcomeOf(t *testing.T) {
+	tests := []struct {
+		err error
+		want Outcome
+	}{
+		{nil, Found},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, NotFound},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, Failed},
+		{&net.DNSError{Err: "i/o timeout", IsTimeout: true}, Failed},
+		{errors.New("connection refused"), Failed},
+	}
+	for _, test := range tests {
+		if got := outcomeOf(test.err); got != test.want {
+			t.Errorf("outcome %d for %v, expected %d", got, test.err, test.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilterValidDNS(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	writeFile(t, path("scripts.log"), "http://scraped.test/wasm [http://scraped.test/a.wasm blob:http://scraped.test/1]\n")
+	writeFile(t, path("noscripts.log"), "http://scraped.test/\n")
+	writeFile(t, path("dnserrors.log"), strings.Join([]string{
+		"http://scraped.test/wasm", // Scraped by another run, with WebAssembly
+		"http://scraped.test/",
+		"http://valid.test/a",
+		"http://valid.test/b",
+		"http://valid.test/a", // Duplicate
+		"https://gone.test/",
+		"http://flaky.test/",
+		"http://",
+	}, "\n") + "\n")
+	useResolver(t, NewFakeResolver(map[string][]answer{
+		"ip4 valid.test": {found(1)},
+		"ip4 flaky.test": {servfail},
+		"ip6 flaky.test": {servfail},
+	}))
+	Init(path("scripts.log"), path("noscripts.log"))
+	FilterValidDNS(path("dnserrors.log"), path("valid.log"), path("invalid.log"), path("records.tsv"), 2)
+
+	check := func(file string, want []string) {
+		t.Helper()
+		if got := LinesInFile(path(file)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, expected %q", file, got, want)
+		}
+	}
+	check("valid.log", []string{"http://valid.test/a", "http://valid.test/b"})
+	// The hosts are checked concurrently, in any order
+	invalid := LinesInFile(path("invalid.log"))
+	sort.Strings(invalid)
+	if want := []string{"http://", "https://gone.test/"}; !reflect.DeepEqual(invalid, want) {
+		t.Errorf("invalid.log: got %q, expected %q", invalid, want)
+	}
+	records := LinesInFile(path("records.tsv"))
+	if len(records) != 4 || !strings.Contains(strings.Join(records, "\n"), "http://valid.test/a\tvalid\ta=1 aaaa=0") {
+		t.Errorf("records.tsv: got %q", records)
+	}
+
+	// A second run only checks again the host that could not be checked
+	fake := NewFakeResolver(map[string][]answer{"ip4 flaky.test": {found(1)}})
+	useResolver(t, fake)
+	FilterValidDNS(path("dnserrors.log"), path("valid.log"), path("invalid.log"), path("records.tsv"), 2)
+	check("valid.log", []string{"http://valid.test/a", "http://valid.test/b", "http://flaky.test/"})
+	if len(fake.lookups) != 3 || fake.lookups["ip4 flaky.test"] != 1 {
+		t.Errorf("lookups of the second run: %v", fake.lookups)
+	}
+}
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+	scripts := filepath.Join(dir, "scripts.log")
+	writeFile(t, scripts, "https://b.test/ [https://b.test/x.wasm]\nhttps://a.test/page [blob:https://a.test/1 https://a.test/y.wasm]\n")
+	Init(scripts, filepath.Join(dir, "missing.log"))
+	for _, url := range []string{"https://a.test/page", "https://b.test/"} {
+		if !IsSuccessfullyScraped(url) {
+			t.Errorf("%s not recognized as scraped", url)
+		}
+	}
+	for _, url := range []string{"https://a.test/", "https://b.test/ [https://b.test/x.wasm]", "https://c.test/"} {
+		if IsSuccessfullyScraped(url) {
+			t.Errorf("%s recognized as scraped", url)
+		}
+	}
+}
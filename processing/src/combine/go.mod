@@ -0,0 +1,3 @@
+module combine
+
+go 1.21
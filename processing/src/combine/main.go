@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The result files of a scraping, by increasing precedence: when the same URL
+// appears in several files, a page that has been scraped takes precedence over
+// an error, and a page on which WebAssembly has been found over one without
+var statusFiles = []string{"dnserrors.log", "failures.log", "timeouts.log", "noscripts.log", "scripts.log"}
+
+// The precedence of a result file, -1 if it is not a result file
+func precedenceOf(file string) int {
+	for i, name := range statusFiles {
+		if name == file {
+			return i
+		}
+	}
+	return -1
+}
+
+// The result of an URL, and the archive in which it has been found
+type Record struct {
+	Status int // Index of the result file in statusFiles
+	Archive int // Index of the archive in the order in which they are read
+	Details string // What follows the URL on its line (e.g., the list of scripts in scripts.log), empty if nothing
+}
+
+// What has been read from an archive
+type ArchiveReport struct {
+	Name string
+	Read map[string]int // Number of records read from each result file
+	Invalid int // Lines whose URL is not valid
+	Contributed int // Number of URLs whose combined result comes from this archive
+}
+
+// Matches URLs that start with a scheme other than a port, such as mailto: or javascript:
+var schemeRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:($|[^0-9])`)
+
+// Normalize an URL so that the same page is written in the same way across scrapings:
+// lowercase scheme and host, no default port, no fragment, no trailing slash.
+// Links starting with // are considered to use http, and URLs without scheme are
+// considered to be http URLs. Returns false for relative links and URLs that are
+// not http or https.
+func Normalize(link string) (string, bool) {
+	link = strings.TrimSpace(link)
+	if strings.HasPrefix(link, "//") {
+		link = "http:" + link
+	} else if strings.HasPrefix(link, "/") || link == "" {
+		// Relative link, the page on which it was found is unknown
+		return "", false
+	} else if !schemeRegexp.MatchString(link) {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		// IPv6 address
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host = host + ":" + port
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	if u.Path == "" && u.RawQuery != "" {
+		u.Path = "/"
+	}
+	return u.String(), true
+}
+
+// Split a line of a result file into its URL and what follows it, such as the
+// list of scripts of scripts.log lines ("<url> [<script urls>]")
+func SplitLine(line string) (string, string) {
+	link, details, _ := strings.Cut(strings.TrimSpace(line), " ")
+	return link, strings.TrimSpace(details)
+}
+
+// Read the result files of an archive, recording the results of its URLs in records
+func ReadArchive(path string, index int, records map[string]Record, lastWins bool) ArchiveReport {
+	report := ArchiveReport{Name: path, Read: make(map[string]int)}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		log.Fatalf("Can't open archive %s: %v", path, err)
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		status := precedenceOf(filepath.Base(file.Name))
+		if status < 0 || file.FileInfo().IsDir() {
+			continue
+		}
+		f, err := file.Open()
+		if err != nil {
+			log.Fatalf("Can't read %s in %s: %v", file.Name, path, err)
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+		for scanner.Scan() {
+			link, details := SplitLine(scanner.Text())
+			link, ok := Normalize(link)
+			if !ok {
+				if strings.TrimSpace(scanner.Text()) != "" {
+					report.Invalid += 1
+				}
+				continue
+			}
+			report.Read[statusFiles[status]] += 1
+			previous, present := records[link]
+			if !present || (lastWins && previous.Archive < index) || ((!lastWins || previous.Archive == index) && previous.Status < status) {
+				// Within an archive, precedence decides between results of the same URL
+				records[link] = Record{status, index, details}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("Can't read %s in %s: %v", file.Name, path, err)
+		}
+		f.Close()
+	}
+	return report
+}
+
+// Write the combined results in one file per status, each sorted and without duplicates
+func WriteResults(dir string, records map[string]Record) {
+	links := make([][]string, len(statusFiles))
+	for link, record := range records {
+		if record.Details != "" {
+			link += " " + record.Details
+		}
+		links[record.Status] = append(links[record.Status], link)
+	}
+	for status, name := range statusFiles {
+		sort.Strings(links[status])
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("Can't create %s: %v", path, err)
+		}
+		writer := bufio.NewWriter(f)
+		for _, link := range links[status] {
+			writer.WriteString(link + "\n")
+		}
+		if err := writer.Flush(); err != nil {
+			log.Fatalf("Can't write %s: %v", path, err)
+		}
+		f.Close()
+		fmt.Printf("%s: %d URLs\n", path, len(links[status]))
+	}
+}
+
+// Combine the results of multiple scrapings, given as zip archives of their
+// .log files (all *.zip files of the current directory by default), into
+// single .log files. Results for the same URL are merged either by precedence
+// (see statusFiles) or by keeping the result of the last archive.
+func main() {
+	merge := flag.String("merge", "precedence", "how results of the same URL are merged: precedence (pages scraped over errors) or last (result of the last archive given)")
+	output := flag.String("output", ".", "directory in which the combined .log files are written")
+	flag.Parse()
+	if *merge != "precedence" && *merge != "last" {
+		log.Fatalf("Unknown merge strategy %s, expected precedence or last", *merge)
+	}
+	archives := flag.Args()
+	if len(archives) == 0 {
+		archives, _ = filepath.Glob("*.zip")
+		sort.Strings(archives)
+	}
+	if len(archives) == 0 {
+		log.Fatalf("No archive to combine")
+	}
+	records := make(map[string]Record)
+	reports := make([]ArchiveReport, len(archives))
+	for i, archive := range archives {
+		reports[i] = ReadArchive(archive, i, records, *merge == "last")
+	}
+	for _, record := range records {
+		reports[record.Archive].Contributed += 1
+	}
+	for _, report := range reports {
+		read := make([]string, 0, len(statusFiles))
+		for _, name := range statusFiles {
+			read = append(read, fmt.Sprintf("%d %s", report.Read[name], strings.TrimSuffix(name, ".log")))
+		}
+		fmt.Printf("%s: read %s, %d invalid lines, contributed %d URLs\n", report.Name, strings.Join(read, ", "), report.Invalid, report.Contributed)
+	}
+	WriteResults(*output, records)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+		ok bool
+	}{
+		{"http://example.com/page", "http://example.com/page", true},
+		// Case
+		{"HTTP://Example.COM/Page", "http://example.com/Page", true},
+		// Default ports
+		{"http://example.com:80/a", "http://example.com/a", true},
+		{"https://example.com:443/a", "https://example.com/a", true},
+		{"https://example.com:80/a", "https://example.com:80/a", true},
+		{"http://[::1]:80/a", "http://[::1]/a", true},
+		{"http://[2001:DB8::1]:8080/a", "http://[2001:db8::1]:8080/a", true},
+		// Fragments and trailing slashes
+		{"http://example.com/page#section", "http://example.com/page", true},
+		{"http://example.com/dir/", "http://example.com/dir", true},
+		{"http://example.com/", "http://example.com", true},
+		{"http://example.com/?a=1", "http://example.com/?a=1", true},
+		{"http://example.com/dir/?b=2&a=1", "http://example.com/dir?b=2&a=1", true},
+		// Links without scheme
+		{"//cdn.example.com/lib.js", "http://cdn.example.com/lib.js", true},
+		{"  example.com/page  ", "http://example.com/page", true},
+		{"localhost:8080/page", "http://localhost:8080/page", true},
+		// Links that are dropped
+		{"/relative/page", "", false},
+		{"", "", false},
+		{"mailto:someone@example.com", "", false},
+		{"javascript:void(0)", "", false},
+		{"ftp://example.com/file", "", false},
+		{"http:///page", "", false},
+		{"http://exa mple.com/", "", false},
+	}
+	for _, test := range tests {
+		got, ok := Normalize(test.link)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Normalize(%q) = %q, %t, expected %q, %t", test.link, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// Write a zip archive with the given files and contents
+func writeArchive(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		file, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.zip")
+	second := filepath.Join(dir, "second.zip")
+	writeArchive(t, first, map[string]string{
+		"scripts.log": "http://a.com/ [http://a.com/x.wasm]\n",
+		"noscripts.log": "http://b.com/\nhttp://d.com\n",
+		"timeouts.log": "http://c.com/\n",
+		"failures.log": "http://d.com/\n",
+	})
+	// A data directory with several jobs, and files that are not results
+	writeArchive(t, second, map[string]string{
+		"data/job1/noscripts.log": "HTTP://A.com/\n/relative\n\nhttp://e.com/\n",
+		"data/job1/dnserrors.log": "b.com\n",
+		"data/job2/scripts.log": "http://c.com/#top [http://c.com/y.wasm]\n",
+		"data/job2/failures.log": "http://e.com/\n",
+		"data/job2/requests.txt": "http://f.com/\n",
+	})
+	tests := []struct {
+		name string
+		lastWins bool
+		want map[string]Record
+	}{
+		{"precedence", false, map[string]Record{
+			// Pages scraped take precedence over errors, pages with WebAssembly over pages without
+			"http://a.com": {4, 0, "[http://a.com/x.wasm]"},
+			"http://b.com": {3, 0, ""},
+			"http://c.com": {4, 1, "[http://c.com/y.wasm]"},
+			"http://d.com": {3, 0, ""},
+			"http://e.com": {3, 1, ""},
+		}},
+		{"last wins", true, map[string]Record{
+			// The result of the last archive is kept, precedence decides within an archive
+			"http://a.com": {3, 1, ""},
+			"http://b.com": {0, 1, ""},
+			"http://c.com": {4, 1, "[http://c.com/y.wasm]"},
+			"http://d.com": {3, 0, ""},
+			"http://e.com": {3, 1, ""},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records := make(map[string]Record)
+			reports := []ArchiveReport{ReadArchive(first, 0, records, test.lastWins), ReadArchive(second, 1, records, test.lastWins)}
+			if !reflect.DeepEqual(records, test.want) {
+				t.Errorf("records %v, expected %v", records, test.want)
+			}
+			if want := map[string]int{"scripts.log": 1, "noscripts.log": 2, "timeouts.log": 1, "failures.log": 1}; !reflect.DeepEqual(reports[0].Read, want) || reports[0].Invalid != 0 {
+				t.Errorf("first archive read %v and %d invalid lines, expected %v", reports[0].Read, reports[0].Invalid, want)
+			}
+			if want := map[string]int{"scripts.log": 1, "noscripts.log": 2, "dnserrors.log": 1, "failures.log": 1}; !reflect.DeepEqual(reports[1].Read, want) || reports[1].Invalid != 1 {
+				t.Errorf("second archive read %v and %d invalid lines, expected %v", reports[1].Read, reports[1].Invalid, want)
+			}
+		})
+	}
+}
@@ -1,11 +1,11 @@
 #!/bin/sh
-if [[ ! -f ./coordinator || (-f ../../coordinator && ../../coordinator -nt ./coordinator) ]]; then
-    if [ ! -f ../../coordinator ]; then
+if [[ ! -f ./coordinator || (-f ../../bin/coordinator && ../../bin/coordinator -nt ./coordinator) ]]; then
+    if [ ! -f ../../bin/coordinator ]; then
         echo 'coordinator must be built first with make.sh in ../../'
         exit 1
     else
-        echo 'Importing coordinator binary from ../../'
-        cp ../../coordinator ./
+        echo 'Importing coordinator binary from ../../bin/'
+        cp ../../bin/coordinator ./
     fi
 fi
 
@@ -1,10 +1,10 @@
 #!/bin/sh
-if [[ ! -f ./node || (-f ../../node && ../../node -nt ./node) ]]; then
-    if [ ! -f ../../node ]; then
+if [[ ! -f ./node || (-f ../../bin/node && ../../bin/node -nt ./node) ]]; then
+    if [ ! -f ../../bin/node ]; then
         echo 'node must be built first with make.sh in ../../'
         exit 1
     else
-        echo 'Importing node binary from ../../'
-        cp ../../node ./
+        echo 'Importing node binary from ../../bin/'
+        cp ../../bin/node ./
     fi
 fi
@@ -1,7 +1,8 @@
 #!/bin/sh
-export GOPATH=$(pwd)
-go get scraping
-go get node
-CGO_ENABLED=0 GOOS=linux go build -a -ldflags '-extldflags "-static"' node
-go get coordinator
-CGO_ENABLED=0 GOOS=linux go build -a -ldflags '-extldflags "-static"' coordinator
+# Builds the node, the coordinator, scrapectl and reschedule in bin/. Each program is its
+# own Go module, depending on the shared scraping and metrics modules in src/.
+mkdir -p bin
+for program in node coordinator scrapectl reschedule
+do
+    (cd src/$program && CGO_ENABLED=0 GOOS=linux go build -a -ldflags '-extldflags "-static"' -o ../../bin/$program .) || exit 1
+done
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"scraping"
+)
+
+const BLOB_INDEX_FILE = "blobs.jsonl"
+
+// Directory and extension of the files of each kind of blob. These are the
+// directories that the analysis scripts expect.
+var blobLayout = map[string]struct{ dir, ext string }{
+	scraping.BlobWasm: {"bytecode", ".wasm"},
+	scraping.BlobJS: {"source", ".js"},
+}
+
+// A content-addressed store of blobs, which keeps track of the pages and
+// domains on which each blob has been found
+type BlobStore interface {
+	Has(hash string) bool
+	Put(kind string, hash string, data []byte) error
+	Get(hash string) ([]byte, error)
+	Reference(hash string, page string) error
+	List(kind string) []scraping.BlobInfo
+}
+
+// An entry of the index of the blob store
+type BlobIndexEntry struct {
+	Op string // "put" or "ref"
+	Hash string
+	Kind string `json:",omitempty"`
+	Size int `json:",omitempty"`
+	Time time.Time
+	Page string `json:",omitempty"`
+	Domain string `json:",omitempty"`
+}
+
+type blobMeta struct {
+	info scraping.BlobInfo
+	pages map[string]bool
+	domains map[string]bool
+}
+
+// A blob store backed by a directory. Blobs are stored in one file per blob,
+// named after their hash, and an index keeps their metadata and references.
+type FSBlobStore struct {
+	lock sync.Mutex
+	dir string
+	blobs map[string]*blobMeta
+	index *os.File
+}
+
+// Open the blob store in the given directory, loading its index
+func OpenFSBlobStore(dir string) (*FSBlobStore, error) {
+	s := &FSBlobStore{dir: dir, blobs: make(map[string]*blobMeta)}
+	path := filepath.Join(dir, BLOB_INDEX_FILE)
+	if f, err := os.Open(path); err == nil {
+		decoder := json.NewDecoder(bufio.NewReader(f))
+		for {
+			var entry BlobIndexEntry
+			if err := decoder.Decode(&entry); err != nil {
+				break
+			}
+			s.apply(entry)
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var err error
+	s.index, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Register blobs that are present on disk but missing from the index
+	for kind, layout := range blobLayout {
+		files, _ := filepath.Glob(filepath.Join(dir, layout.dir, "*" + layout.ext))
+		for _, file := range files {
+			hash := strings.TrimSuffix(filepath.Base(file), layout.ext)
+			if _, present := s.blobs[hash]; present {
+				continue
+			}
+			stat, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			if err := s.write(BlobIndexEntry{Op: "put", Hash: hash, Kind: kind, Size: int(stat.Size()), Time: stat.ModTime()}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// Apply an entry of the index to the metadata. Must be called with the lock held.
+func (s *FSBlobStore) apply(entry BlobIndexEntry) {
+	meta, present := s.blobs[entry.Hash]
+	switch entry.Op {
+	case "put":
+		if !present {
+			s.blobs[entry.Hash] = &blobMeta{
+				info: scraping.BlobInfo{Hash: entry.Hash, Kind: entry.Kind, Size: entry.Size, FirstSeen: entry.Time},
+				pages: make(map[string]bool),
+				domains: make(map[string]bool),
+			}
+		}
+	case "ref":
+		if present {
+			meta.pages[entry.Page] = true
+			meta.domains[entry.Domain] = true
+			meta.info.Pages = len(meta.pages)
+			meta.info.Domains = len(meta.domains)
+		}
+	}
+}
+
+// Append an entry to the index and apply it. Must be called with the lock held.
+func (s *FSBlobStore) write(entry BlobIndexEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.index.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.apply(entry)
+	return nil
+}
+
+func (s *FSBlobStore) path(kind string, hash string) (string, error) {
+	layout, present := blobLayout[kind]
+	if !present {
+		return "", fmt.Errorf("unknown blob kind %q", kind)
+	}
+	return filepath.Join(s.dir, layout.dir, hash + layout.ext), nil
+}
+
+func (s *FSBlobStore) Has(hash string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, present := s.blobs[hash]
+	return present
+}
+
+// Store a blob, unless it is already stored. The hash must be the SHA-256 of the data.
+func (s *FSBlobStore) Put(kind string, hash string, data []byte) error {
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return fmt.Errorf("hash mismatch for blob %s", hash)
+	}
+	path, err := s.path(kind, hash)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, present := s.blobs[hash]; present {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that the blob is never partially stored
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash + ".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return s.write(BlobIndexEntry{Op: "put", Hash: hash, Kind: kind, Size: len(data), Time: time.Now()})
+}
+
+func (s *FSBlobStore) Get(hash string) ([]byte, error) {
+	s.lock.Lock()
+	meta, present := s.blobs[hash]
+	s.lock.Unlock()
+	if !present {
+		return nil, fmt.Errorf("unknown blob %s", hash)
+	}
+	path, err := s.path(meta.info.Kind, hash)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// Record that a blob has been found on a page. Pages are grouped by
+// registrable domain, as for politeness (see DomainOf).
+func (s *FSBlobStore) Reference(hash string, page string) error {
+	domain := DomainOf(page)
+	if domain == "" {
+		domain = page
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	meta, present := s.blobs[hash]
+	if !present {
+		return fmt.Errorf("unknown blob %s", hash)
+	}
+	if meta.pages[page] {
+		return nil
+	}
+	return s.write(BlobIndexEntry{Op: "ref", Hash: hash, Time: time.Now(), Page: page, Domain: domain})
+}
+
+// The metadata of the blobs of the given kind, or of all blobs if kind is empty, sorted by hash
+func (s *FSBlobStore) List(kind string) []scraping.BlobInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	blobs := make([]scraping.BlobInfo, 0, len(s.blobs))
+	for _, meta := range s.blobs {
+		if kind == "" || meta.info.Kind == kind {
+			blobs = append(blobs, meta.info)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Hash < blobs[j].Hash })
+	return blobs
+}
+
+// Whether the server stores the blob with the given hash
+func (t *Server) HasBlob(args *string, reply *bool) error {
+	*reply = state.blobs.Has(*args)
+	return nil
+}
+
+// Receive a blob found by a node
+func (t *Server) UploadBlob(args *scraping.BlobUpload, reply *bool) error {
+	if err := state.blobs.Put((*args).Kind, (*args).Hash, (*args).Data); err != nil {
+		log.Printf("Cannot store blob %s from %s: %v", (*args).Hash, (*args).Node.URL, err)
+		return err
+	}
+	log.Printf("Stored blob %s (%s, %d bytes) from %s", (*args).Hash, (*args).Kind, len((*args).Data), (*args).Node.URL)
+	*reply = true
+	return nil
+}
+
+// List the blobs of a kind, or all blobs if the kind is empty
+func (t *Server) ListBlobs(args *string, reply *[]scraping.BlobInfo) error {
+	*reply = state.blobs.List(*args)
+	return nil
+}
+
+// Record the blobs found on the page of a result
+func ReferenceBlobs(result scraping.Result) {
+	for _, module := range result.Modules {
+		for _, hash := range []string{module.Hash, module.Loader} {
+			if hash == "" {
+				continue
+			}
+			if err := state.blobs.Reference(hash, result.URL); err != nil {
+				// The node may have failed to upload the blob
+				log.Printf("Cannot reference blob %s on %s: %v", hash, result.URL, err)
+			}
+		}
+	}
+}
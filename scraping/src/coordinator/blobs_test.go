@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"scraping"
+)
+
+func TestBlobReferences(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFSBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("\x00asm\x01\x00\x00\x00")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if err := s.Put(scraping.BlobWasm, hash, data); err != nil {
+		t.Fatal(err)
+	}
+	// Pages are grouped by registrable domain, with or without scheme
+	for _, page := range []string{"http://www.example.co.uk/", "https://games.example.co.uk/a", "example.co.uk/b", "http://www.example.co.uk/", "other.test", "http://127.0.0.1:8080/"} {
+		if err := s.Reference(hash, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Reference("unknown", "http://example.com/"); err == nil {
+		t.Errorf("reference to an unknown blob")
+	}
+	// The references are kept in the index
+	s, err = OpenFSBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := s.List(scraping.BlobWasm)
+	if len(blobs) != 1 || blobs[0].Pages != 5 || blobs[0].Domains != 3 {
+		t.Errorf("blobs %+v, expected one found on 5 pages of 3 domains", blobs)
+	}
+}
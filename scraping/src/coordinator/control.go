@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"scraping"
+)
+
+// Whether dispatching batches has been paused
+func Paused() bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.paused
+}
+
+func SetPaused(paused bool) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.paused = paused
+}
+
+// The progress of the jobs and the state of the nodes
+func (t *Server) Status(args *bool, reply *scraping.Status) error {
+	jobs := state.jobs.All()
+	nodes := state.registry.Nodes()
+	*reply = scraping.Status{
+		Version: scraping.ProtocolVersion,
+		Started: state.startTime,
+		Paused: Paused(),
+		Jobs: make([]scraping.JobStatus, len(jobs)),
+		Nodes: make([]scraping.NodeStatus, len(nodes)),
+	}
+	for i, job := range jobs {
+		reply.Jobs[i] = job.Status()
+	}
+	for i, info := range nodes {
+		reply.Nodes[i] = NodeStatusOf(info)
+	}
+	return nil
+}
+
+func NodeStatusOf(info NodeInfo) scraping.NodeStatus {
+	return scraping.NodeStatus{
+		URL: info.Node.URL,
+		State: info.State.String(),
+		FirstSeen: info.FirstSeen,
+		LastSeen: info.LastSeen,
+		BatchesDone: info.BatchesDone,
+		Results: info.Results,
+		Heartbeat: info.LastHeartbeat,
+		Config: info.Config,
+	}
+}
+
+// Stop dispatching batches, the batches in flight are still received
+func (t *Server) Pause(args *bool, reply *bool) error {
+	log.Printf("Pausing the dispatch of batches")
+	SetPaused(true)
+	*reply = true
+	return nil
+}
+
+// Dispatch batches again
+func (t *Server) Resume(args *bool, reply *bool) error {
+	log.Printf("Resuming the dispatch of batches")
+	SetPaused(false)
+	*reply = true
+	return nil
+}
+
+// Stop sending batches to a node, which finishes its current batch
+func (t *Server) Drain(args *scraping.Node, reply *bool) error {
+	if !state.registry.Drain(*args) {
+		return fmt.Errorf("unknown node %s", (*args).URL)
+	}
+	log.Printf("Draining node %s upon request", (*args).URL)
+	*reply = true
+	return nil
+}
+
+// Queue top level URLs in an existing job
+func (t *Server) AddURLs(args *scraping.URLSubmission, reply *int) error {
+	job, found := state.jobs.Get((*args).Job)
+	if !found {
+		return fmt.Errorf("unknown job %s", (*args).Job)
+	}
+	*reply = job.Add((*args).URLs)
+	log.Printf("Added %d URLs to job %s", *reply, job.Name)
+	return nil
+}
+
+// Terminate the nodes and the coordinator, the journals allowing to resume the scraping later
+func (t *Server) Shutdown(args *bool, reply *bool) error {
+	log.Printf("Shutting down upon request")
+	// Replying before the coordinator terminates
+	go EndScraping()
+	*reply = true
+	return nil
+}
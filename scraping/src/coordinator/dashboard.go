@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"scraping"
+)
+
+// A WebAssembly module found on a page
+type RecentModule struct {
+	scraping.Module
+	Page string // The page on which the module has been found
+	Job string
+	Found time.Time // When the result has been received
+}
+
+// The last modules found, most recent first
+type RecentModules struct {
+	lock sync.Mutex
+	modules []RecentModule
+	max int
+}
+
+func NewRecentModules(max int) *RecentModules {
+	return &RecentModules{modules: make([]RecentModule, 0, max), max: max}
+}
+
+// Record the modules found on the page of a result
+func (r *RecentModules) Add(job string, result scraping.Result) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, module := range result.Modules {
+		if module.Hash == "" {
+			continue // The bytecode could not be retrieved
+		}
+		r.modules = append([]RecentModule{{Module: module, Page: result.URL, Job: job, Found: time.Now()}}, r.modules...)
+		if len(r.modules) > r.max {
+			r.modules = r.modules[:r.max]
+		}
+	}
+}
+
+func (r *RecentModules) All() []RecentModule {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]RecentModule{}, r.modules...)
+}
+
+// A node, along with the rate at which it scrapes pages
+type DashboardNode struct {
+	scraping.NodeStatus
+	PagesPerMinute float64 // Since the node was first seen
+}
+
+// The status of the coordinator, served as JSON on /api/status
+type DashboardStatus struct {
+	Started time.Time
+	ElapsedSeconds float64
+	Paused bool
+	Queued int // Requests waiting to be dispatched
+	Delayed int // Requests waiting to be retried
+	Checking int // Requests waiting to be checked before dispatch
+	InFlight int // Batches dispatched to nodes
+	URLsToRequest int
+	Scraped int
+	Scripts int // Pages on which WebAssembly has been found
+	Failures int
+	DNSErrors int
+	Timeouts int
+	Retries int // Requests retried after an error
+	Skipped int // Links not followed as they are disallowed by robots.txt
+	Duplicates int // New URLs left out as their job had already queued them
+	Errors map[scraping.ErrorCode]int // Number of results for each error code
+	Rate float64 // URLs scraped per second
+	// Bounds on the remaining time, 0 if unknown
+	MinRemainingSeconds float64
+	MaxRemainingSeconds float64
+	Jobs []scraping.JobStatus
+	Nodes []DashboardNode
+	RecentModules []RecentModule
+}
+
+func ComputeDashboardStatus() DashboardStatus {
+	progress := ComputeProgress()
+	status := DashboardStatus{
+		Started: state.startTime,
+		ElapsedSeconds: progress.Elapsed.Seconds(),
+		Paused: Paused(),
+		Queued: progress.Queued,
+		Delayed: progress.Delayed,
+		Checking: progress.Checking,
+		InFlight: progress.InFlight,
+		URLsToRequest: progress.TotalURLsToRequest,
+		Scraped: progress.TotalScraped,
+		Scripts: progress.TotalScripts,
+		Failures: progress.TotalFailures,
+		DNSErrors: progress.TotalDNSErrors,
+		Timeouts: progress.TotalTimeouts,
+		Retries: progress.Retries,
+		Skipped: progress.Skipped,
+		Duplicates: progress.Duplicates,
+		Errors: progress.Errors,
+		Rate: progress.Rate,
+		MinRemainingSeconds: progress.MinRemaining.Seconds(),
+		MaxRemainingSeconds: progress.MaxRemaining.Seconds(),
+		Jobs: make([]scraping.JobStatus, 0),
+		Nodes: make([]DashboardNode, 0),
+		RecentModules: state.recent.All(),
+	}
+	for _, job := range state.jobs.All() {
+		status.Jobs = append(status.Jobs, job.Status())
+	}
+	for _, info := range state.registry.Nodes() {
+		node := DashboardNode{NodeStatus: NodeStatusOf(info)}
+		if minutes := time.Now().Sub(info.FirstSeen).Minutes(); minutes > 0 {
+			node.PagesPerMinute = float64(info.Results) / minutes
+		}
+		status.Nodes = append(status.Nodes, node)
+	}
+	return status
+}
+
+// Serve the dashboard on / and the status as JSON on /api/status, next to the RPC endpoint
+func HandleDashboard() {
+	http.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(ComputeDashboardStatus()); err != nil {
+			log.Printf("Cannot send status to %s: %v", r.RemoteAddr, err)
+		}
+	})
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, ComputeDashboardStatus()); err != nil {
+			log.Printf("Cannot send dashboard to %s: %v", r.RemoteAddr, err)
+		}
+	})
+}
+
+func seconds(s float64) string {
+	return (time.Duration(s) * time.Second).String()
+}
+
+func percent(n int, total int) string {
+	if total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n) * 100 / float64(total))
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{"seconds": seconds, "percent": percent}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Scraping coordinator</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.paused { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Scraping coordinator</h1>
+<p>Up for {{seconds .ElapsedSeconds}}{{if .Paused}}, <span class="paused">dispatch paused</span>{{end}}.
+Scraped {{.Scraped}} URLs on {{.URLsToRequest}} to scrape so far, at {{printf "%.2f" .Rate}} URL/s.
+{{if .MaxRemainingSeconds}}Remaining time: between {{seconds .MinRemainingSeconds}} and {{seconds .MaxRemainingSeconds}}.{{end}}</p>
+<p>{{.Queued}} requests queued, {{.Checking}} waiting to be checked, {{.Delayed}} waiting to be retried ({{.Retries}} retries so far), {{.Skipped}} links disallowed by robots.txt, {{.Duplicates}} duplicate URLs left out, {{.InFlight}} batches in flight.</p>
+
+<h2>Results</h2>
+<table>
+<tr><th>Outcome</th><th>Pages</th><th>Share</th></tr>
+<tr><td>WebAssembly found</td><td>{{.Scripts}}</td><td>{{percent .Scripts .Scraped}}</td></tr>
+<tr><td>Failures</td><td>{{.Failures}}</td><td>{{percent .Failures .Scraped}}</td></tr>
+<tr><td>DNS errors</td><td>{{.DNSErrors}}</td><td>{{percent .DNSErrors .Scraped}}</td></tr>
+<tr><td>Timeouts</td><td>{{.Timeouts}}</td><td>{{percent .Timeouts .Scraped}}</td></tr>
+</table>
+
+{{if .Errors}}<h2>Errors</h2>
+<table>
+<tr><th>Error</th><th>Pages</th><th>Share</th></tr>
+{{range $code, $count := .Errors}}<tr><td>{{$code}}</td><td>{{$count}}</td><td>{{percent $count $.Scraped}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Jobs</h2>
+<table>
+<tr><th>Job</th><th>Priority</th><th>Scraped</th><th>To scrape</th><th>Queued</th><th>Checking</th><th>Retrying</th><th>Skipped</th><th>In flight</th><th>Scripts</th><th>Failures</th><th>DNS errors</th><th>Timeouts</th><th>Done</th></tr>
+{{range .Jobs}}<tr><td>{{.Name}}</td><td>{{.Priority}}</td><td>{{.Scraped}}</td><td>{{.URLsToRequest}}</td><td>{{.Queued}}</td><td>{{.Checking}}</td><td>{{.Delayed}}</td><td>{{.Skipped}}</td><td>{{.InFlight}}</td><td>{{.Scripts}}</td><td>{{.Failures}}</td><td>{{.DNSErrors}}</td><td>{{.Timeouts}}</td><td>{{.Done}}</td></tr>
+{{end}}</table>
+
+<h2>Nodes</h2>
+<table>
+<tr><th>Node</th><th>State</th><th>Batches done</th><th>Results</th><th>Pages/min</th><th>Busy workers</th><th>Current batch</th><th>Last seen</th></tr>
+{{range .Nodes}}<tr><td>{{.URL}}</td><td>{{.State}}</td><td>{{.BatchesDone}}</td><td>{{.Results}}</td><td>{{printf "%.1f" .PagesPerMinute}}</td><td>{{.Heartbeat.BusyWorkers}}/{{.Heartbeat.Workers}}</td><td>{{if .Heartbeat.BatchSize}}{{.Heartbeat.BatchDone}}/{{.Heartbeat.BatchSize}}{{else}}-{{end}}</td><td>{{.LastSeen.Format "15:04:05"}}</td></tr>
+{{end}}</table>
+
+<h2>Recently found WebAssembly modules</h2>
+<table>
+<tr><th>Hash</th><th>Size</th><th>Page</th><th>Job</th><th>Found</th></tr>
+{{range .RecentModules}}<tr><td><code>{{.Hash}}</code></td><td>{{.Size}}</td><td>{{.Page}}</td><td>{{.Job}}</td><td>{{.Found.Format "15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"scraping"
+)
+
+// How long a single lookup can take before the host name is considered unknown
+const DNS_LOOKUP_TIMEOUT = 10 * time.Second
+
+// The outcome of the resolution of a host name
+type DNSOutcome int
+
+const (
+	DNSResolved DNSOutcome = iota // The host name has at least one address
+	DNSNotFound // The host name does not exist (NXDOMAIN) or has no address
+	DNSFailed // The lookup failed (e.g., timeout or server failure), nothing is known about the host name
+)
+
+func (o DNSOutcome) String() string {
+	switch o {
+	case DNSResolved:
+		return "resolved"
+	case DNSNotFound:
+		return "not_found"
+	default:
+		return "failed"
+	}
+}
+
+// A cached answer
+type dnsAnswer struct {
+	outcome DNSOutcome
+	detail string // The error of the lookup, if the host name was not found
+	expires time.Time
+}
+
+// The DNS pre-resolution stage: the host names of new top level requests are
+// resolved before the requests are dispatched, so that requests to host names
+// that do not exist are answered by the coordinator without being sent to a node
+type Preflight struct {
+	resolver *net.Resolver
+	ttl time.Duration // How long answers are cached
+	lock sync.Mutex // Protects cache and lookups
+	cache map[string]dnsAnswer
+	lookups map[string]int // Number of host names checked, by outcome ("cached" for answers taken from the cache)
+}
+
+// Create the DNS stage. Lookups are sent to the given resolvers (host:port,
+// the port being 53 if not given) in turn, or to the resolvers of the system
+// if there are none.
+func NewPreflight(resolvers []string, ttl time.Duration) *Preflight {
+	p := &Preflight{ttl: ttl, cache: make(map[string]dnsAnswer), lookups: make(map[string]int)}
+	p.resolver = net.DefaultResolver
+	if len(resolvers) > 0 {
+		servers := make([]string, len(resolvers))
+		for i, resolver := range resolvers {
+			if _, _, err := net.SplitHostPort(resolver); err != nil {
+				resolver = net.JoinHostPort(resolver, "53")
+			}
+			servers[i] = resolver
+		}
+		var next uint32
+		p.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				server := servers[int(atomic.AddUint32(&next, 1)) % len(servers)]
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return p
+}
+
+// Parse a comma-separated list of resolvers
+func ParseResolvers(list string) []string {
+	resolvers := make([]string, 0)
+	for _, resolver := range strings.Split(list, ",") {
+		if resolver = strings.TrimSpace(resolver); resolver != "" {
+			resolvers = append(resolvers, resolver)
+		}
+	}
+	return resolvers
+}
+
+// The host name of an URL, empty if it cannot be found
+func HostOf(link string) string {
+	if !strings.Contains(link, "://") {
+		// Chrome navigates to URLs without scheme over http
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// Resolve a host name, using the cache if possible. Returns the outcome and
+// the error of the lookup if the host name was not found.
+func (p *Preflight) Lookup(host string) (DNSOutcome, string) {
+	now := time.Now()
+	p.lock.Lock()
+	if answer, present := p.cache[host]; present && now.Before(answer.expires) {
+		p.lookups["cached"] += 1
+		p.lock.Unlock()
+		return answer.outcome, answer.detail
+	}
+	p.lock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), DNS_LOOKUP_TIMEOUT)
+	addresses, err := p.resolver.LookupIPAddr(ctx, host)
+	cancel()
+	answer := dnsAnswer{outcome: DNSResolved, expires: now.Add(p.ttl)}
+	var dnsError *net.DNSError
+	if err != nil && errors.As(err, &dnsError) && dnsError.IsNotFound {
+		answer.outcome = DNSNotFound
+		answer.detail = fmt.Sprintf("lookup %s: %s", host, dnsError.Err) // Without the server, which may not be the one queried
+	} else if err != nil {
+		answer.outcome = DNSFailed
+	} else if len(addresses) == 0 {
+		answer.outcome = DNSNotFound
+		answer.detail = fmt.Sprintf("lookup %s: no address", host)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lookups[answer.outcome.String()] += 1
+	if answer.outcome != DNSFailed {
+		// Failures are not cached, the next request to the host will try again
+		p.cache[host] = answer
+	}
+	return answer.outcome, answer.detail
+}
+
+// Number of host names checked so far, by outcome
+func (p *Preflight) Lookups() map[string]int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	lookups := make(map[string]int, len(p.lookups))
+	for outcome, count := range p.lookups {
+		lookups[outcome] = count
+	}
+	return lookups
+}
+
+// Check the host name of a request of a job. Requests whose host name does not
+// exist are answered with a name_not_resolved result, the other ones become
+// available for dispatch. Requests are dispatched when the lookup failed, as
+// the failure may be specific to the resolvers of the coordinator.
+func (p *Preflight) Check(job *Job, request QueuedRequest) {
+	host := HostOf(request.Request.URL)
+	if host == "" || net.ParseIP(host) != nil {
+		// Nothing to resolve, the node will report any error
+		job.queue.Checked(request)
+		return
+	}
+	outcome, detail := p.Lookup(host)
+	if outcome != DNSNotFound {
+		job.queue.Checked(request)
+		return
+	}
+	result := scraping.Result{URL: request.Request.URL, Job: job.Name}
+	result.SetError(scraping.ErrorNameNotResolved, detail)
+	job.queue.Answered(request, result)
+	StoreResult(job, ResultRecord{Result: result, TopLevel: request.Request.TopLevel, Parent: request.Parent, Depth: request.Request.Depth, Attempt: request.Attempt, Received: time.Now()})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The file of a job in which the canonical URLs it has queued are written
+const SEEN_FILE = "seen.txt"
+
+// How jobs remember the URLs they have queued
+const (
+	FRONTIER_NONE = "none" // URLs are not deduplicated
+	FRONTIER_EXACT = "exact" // The canonical URLs are kept in memory
+	FRONTIER_BLOOM = "bloom" // A Bloom filter of the canonical URLs is kept in memory, a few new URLs being taken for duplicates
+)
+
+var FRONTIER_KINDS = []string{FRONTIER_NONE, FRONTIER_EXACT, FRONTIER_BLOOM}
+
+// Query parameters that only track where visitors come from, removed from canonical URLs
+var TRACKING_PARAMETERS = map[string]bool{
+	"gclid": true, "dclid": true, "gbraid": true, "wbraid": true, "fbclid": true, "msclkid": true, "yclid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "_ga": true, "_gl": true, "_hsenc": true, "_hsmi": true,
+}
+
+// The canonical form of an URL, under which the same page is written in the
+// same way: lowercase scheme and host, no default port, no fragment, no
+// trailing slash and no tracking parameter. URLs without scheme are taken as
+// http URLs, as Chrome does, and URLs that cannot be parsed are kept as they are.
+func CanonicalURL(link string) string {
+	link = strings.TrimSpace(link)
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		// IPv6 address
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+	if path := strings.TrimRight(u.EscapedPath(), "/"); path != "" {
+		u.RawPath = path
+		u.Path, _ = url.PathUnescape(path)
+	} else {
+		u.Path, u.RawPath = "/", ""
+	}
+	// The order of the parameters is kept, as servers may depend on it
+	parameters := make([]string, 0)
+	for _, parameter := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(parameter, "=")
+		name = strings.ToLower(name)
+		if parameter == "" || TRACKING_PARAMETERS[name] || strings.HasPrefix(name, "utm_") {
+			continue
+		}
+		parameters = append(parameters, parameter)
+	}
+	u.RawQuery = strings.Join(parameters, "&")
+	u.ForceQuery = false
+	return u.String()
+}
+
+// A set of strings, that tells whether a string has been added before
+type SeenSet interface {
+	Add(key string) bool // Add a string, returns false if it was already in the set
+}
+
+// A set keeping all its strings
+type ExactSet map[string]bool
+
+func (s ExactSet) Add(key string) bool {
+	if s[key] {
+		return false
+	}
+	s[key] = true
+	return true
+}
+
+// A Bloom filter: a set that only keeps a few bits per string, sized for a
+// number of strings and a rate of false positives (new strings taken for
+// strings already added)
+type BloomFilter struct {
+	bits []uint64
+	size uint64 // Number of bits
+	hashes int // Number of bits set per string
+}
+
+func NewBloomFilter(capacity int, errorRate float64) *BloomFilter {
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	size = max(size, 64)
+	hashes := max(int(math.Round(float64(size) / float64(capacity) * math.Ln2)), 1)
+	return &BloomFilter{bits: make([]uint64, (size + 63) / 64), size: size, hashes: hashes}
+}
+
+// The bits of a string: double hashing, the bits being h1 + i*h2
+func (b *BloomFilter) bitsOf(key string) []uint64 {
+	hash := fnv.New128a()
+	hash.Write([]byte(key))
+	sum := hash.Sum(nil)
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1 << 8 | uint64(sum[i])
+		h2 = h2 << 8 | uint64(sum[8 + i])
+	}
+	bits := make([]uint64, b.hashes)
+	for i := range bits {
+		bits[i] = (h1 + uint64(i) * h2) % b.size
+	}
+	return bits
+}
+
+func (b *BloomFilter) Add(key string) bool {
+	added := false
+	for _, bit := range b.bitsOf(key) {
+		if b.bits[bit / 64] & (1 << (bit % 64)) == 0 {
+			b.bits[bit / 64] |= 1 << (bit % 64)
+			added = true
+		}
+	}
+	return added
+}
+
+// Whether a string may have been added, without adding it
+func (b *BloomFilter) Contains(key string) bool {
+	for _, bit := range b.bitsOf(key) {
+		if b.bits[bit / 64] & (1 << (bit % 64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// The URLs a job has queued, so that each page is only requested once by the
+// job. The canonical URLs are written in the seen.txt file of the job, from
+// which the set is rebuilt when the coordinator restarts.
+type Frontier struct {
+	lock sync.Mutex
+	seen SeenSet
+	file *os.File
+	writer *bufio.Writer
+}
+
+// Open the frontier of the job stored in the given directory, with the given
+// kind of set (see FRONTIER_KINDS). The capacity and error rate size Bloom filters.
+func OpenFrontier(dir string, kind string, capacity int, errorRate float64) (*Frontier, error) {
+	f := &Frontier{}
+	switch kind {
+	case FRONTIER_EXACT:
+		f.seen = make(ExactSet)
+	case FRONTIER_BLOOM:
+		f.seen = NewBloomFilter(capacity, errorRate)
+	default:
+		return nil, fmt.Errorf("unknown frontier %q", kind)
+	}
+	path := filepath.Join(dir, SEEN_FILE)
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+		for scanner.Scan() {
+			// A truncated last line only makes the URL it was written for be queued again
+			f.seen.Add(scanner.Text())
+		}
+		err = scanner.Err()
+		existing.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	return f, nil
+}
+
+// Remember the URLs of new requests, returns the requests whose URL had not
+// been seen yet, and their canonical URLs, which must be saved once the
+// requests have been queued
+func (f *Frontier) Filter(requests []QueuedRequest) ([]QueuedRequest, []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	fresh := make([]QueuedRequest, 0, len(requests))
+	canonical := make([]string, 0, len(requests))
+	for _, request := range requests {
+		link := CanonicalURL(request.Request.URL)
+		if f.seen.Add(link) {
+			fresh = append(fresh, request)
+			canonical = append(canonical, link)
+		}
+	}
+	return fresh, canonical
+}
+
+// Write canonical URLs in the seen.txt file. They are written after their
+// requests have been queued, so that a crash in between may lead to requesting
+// a page twice, but not to never requesting it.
+func (f *Frontier) Save(canonical []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, link := range canonical {
+		f.writer.WriteString(link + "\n")
+	}
+	return f.writer.Flush()
+}
+
+func (f *Frontier) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.writer.Flush()
+	return f.file.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		url string
+		want string
+	}{
+		{"http://example.com/", "http://example.com/"},
+		{"http://example.com", "http://example.com/"},
+		// Case
+		{"HTTP://Example.COM/Path", "http://example.com/Path"},
+		{"https://EXAMPLE.com./", "https://example.com/"},
+		// Default ports
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"https://example.com:80/a", "https://example.com:80/a"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"http://[::1]:80/", "http://[::1]/"},
+		{"http://[2001:DB8::1]:8080/", "http://[2001:db8::1]:8080/"},
+		// Fragments
+		{"http://example.com/page#section", "http://example.com/page"},
+		{"http://example.com/#", "http://example.com/"},
+		{"http://example.com/page?a=1#section", "http://example.com/page?a=1"},
+		// Trailing slashes
+		{"http://example.com/dir/", "http://example.com/dir"},
+		{"http://example.com/dir//", "http://example.com/dir"},
+		{"http://example.com//", "http://example.com/"},
+		{"http://example.com/dir/?a=1", "http://example.com/dir?a=1"},
+		// Query: the order is kept, tracking parameters are removed
+		{"http://example.com/?b=2&a=1", "http://example.com/?b=2&a=1"},
+		{"http://example.com/?a=1&b=2", "http://example.com/?a=1&b=2"},
+		{"http://example.com/?utm_source=x&a=1&UTM_Medium=y&gclid=z&fbclid=w", "http://example.com/?a=1"},
+		{"http://example.com/?utm_source=x", "http://example.com/"},
+		{"http://example.com/?", "http://example.com/"},
+		{"http://example.com/?a=1&&b", "http://example.com/?a=1&b"},
+		{"http://example.com/?utmost=1", "http://example.com/?utmost=1"},
+		// Escapes are kept as they are written
+		{"http://example.com/a%2Fb/", "http://example.com/a%2Fb"},
+		{"http://example.com/caf%C3%A9", "http://example.com/caf%C3%A9"},
+		// URLs without scheme, as in urls.txt
+		{"example.com", "http://example.com/"},
+		{"  Example.com/page/  ", "http://example.com/page"},
+		// URLs that cannot be parsed are kept
+		{"http://exa mple.com:port/", "http://exa mple.com:port/"},
+	}
+	for _, test := range tests {
+		if got := CanonicalURL(test.url); got != test.want {
+			t.Errorf("CanonicalURL(%q) = %q, expected %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalURLIsIdempotent(t *testing.T) {
+	for _, url := range []string{"HTTP://Example.com:80/a/?b=1&utm_x=2#f", "example.com", "https://[::1]:443//", "http://example.com/a%2Fb/"} {
+		once := CanonicalURL(url)
+		if twice := CanonicalURL(once); twice != once {
+			t.Errorf("%q canonicalized as %q, then %q", url, once, twice)
+		}
+	}
+}
+
+func TestExactSet(t *testing.T) {
+	s := make(ExactSet)
+	if !s.Add("a") || !s.Add("b") || s.Add("a") {
+		t.Errorf("wrong membership")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	const capacity = 20000
+	const errorRate = 0.01
+	b := NewBloomFilter(capacity, errorRate)
+	for i := 0; i < capacity; i++ {
+		b.Add(fmt.Sprintf("http://example.com/page/%d", i))
+	}
+	// No false negative
+	for i := 0; i < capacity; i++ {
+		if !b.Contains(fmt.Sprintf("http://example.com/page/%d", i)) || b.Add(fmt.Sprintf("http://example.com/page/%d", i)) {
+			t.Fatalf("page %d taken for a new URL", i)
+		}
+	}
+	// False positives stay close to the error rate at the capacity
+	falsePositives := 0
+	for i := 0; i < capacity; i++ {
+		if b.Contains(fmt.Sprintf("http://other.example.com/%d", i)) {
+			falsePositives += 1
+		}
+	}
+	if rate := float64(falsePositives) / capacity; rate > 2 * errorRate {
+		t.Errorf("false positive rate %.4f, expected at most %.4f", rate, 2 * errorRate)
+	}
+	if b.hashes != 7 || b.size < 9 * capacity || b.size > 10 * capacity {
+		t.Errorf("%d bits and %d hashes for %d strings", b.size, b.hashes, capacity)
+	}
+}
+
+func TestSmallBloomFilter(t *testing.T) {
+	b := NewBloomFilter(1, 0.5)
+	if b.size != 64 || b.hashes < 1 {
+		t.Errorf("%d bits and %d hashes", b.size, b.hashes)
+	}
+	if !b.Add("a") || b.Add("a") {
+		t.Errorf("wrong membership")
+	}
+}
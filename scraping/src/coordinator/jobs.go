@@ -0,0 +1,519 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/BurntSushi/toml"
+	"scraping"
+)
+
+// The directory of the data directory in which each job has its own directory
+const JOBS_DIR = "jobs"
+
+// The metadata of a job, saved in its directory
+const JOB_FILE = "job.json"
+
+// A job: a set of URLs to scrape, with its own queue, counters and results
+type Job struct {
+	Name string
+	Spec *scraping.JobSpec // nil if nodes use their own settings
+	Priority int
+	Created time.Time
+	dir string // Where the queue and the results of the job are stored
+	queue *Queue
+	frontier *Frontier // nil if URLs are not deduplicated
+	sink ResultSink
+	lastDispatch time.Time // Used to alternate between jobs of the same priority
+	filling atomic.Bool // Set while the URLs of a new job are being queued
+}
+
+// The jobs of the coordinator
+type Jobs struct {
+	lock sync.Mutex
+	jobs map[string]*Job
+	nextBatch int // Identifier of the next batch, unique across jobs
+}
+
+// Load the jobs stored in the data directory
+func LoadJobs(dir string, sinks string) *Jobs {
+	jobs := &Jobs{jobs: make(map[string]*Job), nextBatch: 1}
+	dirs, _ := filepath.Glob(filepath.Join(dir, JOBS_DIR, "*", JOB_FILE))
+	for i := range dirs {
+		dirs[i] = filepath.Dir(dirs[i])
+	}
+	for _, jobDir := range dirs {
+		job, err := openJob(jobDir, sinks)
+		if err != nil {
+			log.Fatalf("Cannot open job in %s: %v", jobDir, err)
+		}
+		jobs.jobs[job.Name] = job
+		if next := job.queue.NextBatch(); next > jobs.nextBatch {
+			jobs.nextBatch = next
+		}
+		log.Printf("Resuming job %s", job.Name)
+	}
+	return jobs
+}
+
+// Open a job from its directory
+func openJob(dir string, sinks string) (*Job, error) {
+	content, err := os.ReadFile(filepath.Join(dir, JOB_FILE))
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{dir: dir}
+	if err := json.Unmarshal(content, job); err != nil {
+		return nil, err
+	}
+	if job.Spec != nil {
+		spec := WithDefaults(*job.Spec)
+		job.Spec = &spec
+	}
+	job.queue, _ = OpenQueue(dir, job.NeedsCheck)
+	if job.frontier, err = openFrontier(dir); err != nil {
+		return nil, err
+	}
+	if job.sink, err = OpenSinks(sinks, dir); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Open the frontier of a job stored in the given directory, following the
+// settings of the coordinator, nil if URLs are not deduplicated
+func openFrontier(dir string) (*Frontier, error) {
+	if state.config.frontier == FRONTIER_NONE {
+		return nil, nil
+	}
+	return OpenFrontier(dir, state.config.frontier, state.config.frontierCapacity, state.config.frontierErrorRate)
+}
+
+// Save the metadata of a job in its directory
+func saveJob(job *Job) error {
+	content, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(job.dir, JOB_FILE), content, 0644)
+}
+
+var jobNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// Create a new job, storing its data in the data directory, and queue its URLs
+func (j *Jobs) Create(submission scraping.JobSubmission, dir string, sinks string) (*Job, error) {
+	if !jobNameRegexp.MatchString(submission.Name) {
+		return nil, fmt.Errorf("invalid job name %q, names can only contain letters, digits, '_', '.' and '-'", submission.Name)
+	}
+	if submission.Spec != nil {
+		spec := WithDefaults(*submission.Spec)
+		spec.Name = submission.Name
+		if err := ValidateJobSpec(spec); err != nil {
+			return nil, err
+		}
+		submission.Spec = &spec
+	}
+	job, err := j.register(submission, dir, sinks)
+	if err != nil {
+		return nil, err
+	}
+	// Queued once the lock is released, as large submissions take a while to
+	// go through the frontier and the journal of the queue
+	added := job.Add(submission.URLs) + job.AddRequests(submission.Requests)
+	job.filling.Store(false)
+	log.Printf("Created job %s with %d URLs", job.Name, added)
+	return job, nil
+}
+
+// Create the directory, queue and sinks of a new job, and add it to the jobs
+func (j *Jobs) register(submission scraping.JobSubmission, dir string, sinks string) (*Job, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, present := j.jobs[submission.Name]; present {
+		return nil, fmt.Errorf("job %s already exists", submission.Name)
+	}
+	job := &Job{Name: submission.Name, Spec: submission.Spec, Priority: submission.Priority, Created: time.Now(), dir: filepath.Join(dir, JOBS_DIR, submission.Name)}
+	if _, err := os.Stat(job.dir); err == nil {
+		return nil, fmt.Errorf("the directory of job %s already exists", submission.Name)
+	}
+	if err := os.MkdirAll(job.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := saveJob(job); err != nil {
+		return nil, err
+	}
+	var err error
+	job.queue, _ = OpenQueue(job.dir, job.NeedsCheck)
+	if job.frontier, err = openFrontier(job.dir); err != nil {
+		job.queue.Close()
+		return nil, err
+	}
+	if job.sink, err = OpenSinks(sinks, job.dir); err != nil {
+		job.queue.Close()
+		if job.frontier != nil {
+			job.frontier.Close()
+		}
+		return nil, err
+	}
+	job.filling.Store(true) // Not done before its URLs are queued
+	j.jobs[job.Name] = job
+	return job, nil
+}
+
+// Find a job by its name
+func (j *Jobs) Get(name string) (*Job, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	job, present := j.jobs[name]
+	return job, present
+}
+
+// All jobs, sorted by decreasing priority and then by name
+func (j *Jobs) All() []*Job {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	jobs := make([]*Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		if jobs[a].Priority != jobs[b].Priority {
+			return jobs[a].Priority > jobs[b].Priority
+		}
+		return jobs[a].Name < jobs[b].Name
+	})
+	return jobs
+}
+
+// The job from which the next batch should be taken: the job with the
+// highest priority that has requests waiting, alternating between jobs of
+// the same priority. Returns nil if no job has requests waiting.
+func (j *Jobs) Next() *Job {
+	var next *Job
+	for _, job := range j.All() {
+		if job.queue.Len() == 0 {
+			continue
+		}
+		if next != nil && job.Priority < next.Priority {
+			break
+		}
+		if next == nil || job.lastDispatch.Before(next.lastDispatch) {
+			next = job
+		}
+	}
+	if next != nil {
+		j.lock.Lock()
+		next.lastDispatch = time.Now()
+		j.lock.Unlock()
+	}
+	return next
+}
+
+// Allocate the identifier of a new batch
+func (j *Jobs) NextBatchID() int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	id := j.nextBatch
+	j.nextBatch += 1
+	return id
+}
+
+// Whether there is nothing left to do in any job
+func (j *Jobs) Done() bool {
+	for _, job := range j.All() {
+		if !job.Done() {
+			return false
+		}
+	}
+	return true
+}
+
+// Take a top level request or a link that must be checked from the job with
+// the highest priority that has one, returns false if there is none
+func (j *Jobs) NextUnchecked(topLevel bool) (*Job, QueuedRequest, bool) {
+	for _, job := range j.All() {
+		if request, found := job.queue.PopUnchecked(topLevel); found {
+			return job, request, true
+		}
+	}
+	return nil, QueuedRequest{}, false
+}
+
+// Find the job and batch with the given identifier that is in flight on the given node
+func (j *Jobs) BatchOf(id int, node scraping.Node) (*Job, *InFlightBatch, bool) {
+	for _, job := range j.All() {
+		if batch, found := job.queue.BatchOf(id, node); found {
+			return job, batch, true
+		}
+	}
+	return nil, nil, false
+}
+
+// Find the job and expired batch with the given identifier that was held by the given node
+func (j *Jobs) ExpiredBatchOf(id int, node scraping.Node) (*Job, *ExpiredBatch, bool) {
+	for _, job := range j.All() {
+		if batch, found := job.queue.ExpiredBatchOf(id, node); found {
+			return job, batch, true
+		}
+	}
+	return nil, nil, false
+}
+
+// Expire the leases of the batches of all jobs, see Queue.Expire
+func (j *Jobs) Expire(now time.Time, lostNodes []scraping.Node) []*ExpiredBatch {
+	expired := make([]*ExpiredBatch, 0)
+	for _, job := range j.All() {
+		expired = append(expired, job.queue.Expire(now, lostNodes)...)
+	}
+	if state.politeness != nil {
+		for _, batch := range expired {
+			state.politeness.Release(batch.Requests)
+		}
+	}
+	return expired
+}
+
+// Close the queues and sinks of all jobs
+func (j *Jobs) Close() {
+	for _, job := range j.All() {
+		job.queue.Close()
+		if job.frontier != nil {
+			if err := job.frontier.Close(); err != nil {
+				log.Printf("Cannot close the frontier of job %s: %v", job.Name, err)
+			}
+		}
+		if err := job.sink.Close(); err != nil {
+			log.Printf("Cannot close result sinks of job %s: %v", job.Name, err)
+		}
+	}
+}
+
+// Queue top level URLs to scrape, returns the number of URLs queued
+func (job *Job) Add(urls []string) int {
+	requests := make([]QueuedRequest, 0, len(urls))
+	for _, url := range urls {
+		if url != "" { // Filter empty lines, in case there are any
+			requests = append(requests, QueuedRequest{Request: scraping.Request{URL: url, TopLevel: true, Job: job.Name}, Attempt: 1}) // This is a top level request
+		}
+	}
+	return job.Enqueue(requests)
+}
+
+// Queue requests given with their context, returns the number of requests queued
+func (job *Job) AddRequests(submitted []scraping.SubmittedRequest) int {
+	requests := make([]QueuedRequest, 0, len(submitted))
+	for _, request := range submitted {
+		if request.URL != "" {
+			// Attempts start again from 1, the retry policy applies to the new job
+			requests = append(requests, QueuedRequest{Request: scraping.Request{URL: request.URL, TopLevel: request.TopLevel, Job: job.Name, Depth: request.Depth}, Attempt: 1, Parent: request.Parent})
+		}
+	}
+	return job.Enqueue(requests)
+}
+
+// Queue new requests, leaving out those whose URL the job has already queued
+// (see CanonicalURL). Returns the number of requests queued.
+func (job *Job) Enqueue(requests []QueuedRequest) int {
+	defer WakeCheckers()
+	if job.frontier == nil {
+		job.queue.Push(requests, true)
+		return len(requests)
+	}
+	fresh, canonical := job.frontier.Filter(requests)
+	job.queue.Push(fresh, true)
+	job.queue.Duplicates(len(requests) - len(fresh))
+	if err := job.frontier.Save(canonical); err != nil {
+		log.Fatalf("Cannot save the frontier of job %s: %v", job.Name, err)
+	}
+	return len(fresh)
+}
+
+// How the job handles links disallowed by robots.txt
+func (job *Job) Robots() string {
+	if job.Spec != nil && job.Spec.Robots != "" {
+		return job.Spec.Robots
+	}
+	return state.config.robots
+}
+
+// Whether a new request must be checked before being dispatched: the host
+// names of top level requests are resolved (see Preflight), and links are
+// looked up in robots.txt unless the job ignores it (see RobotsCache)
+func (job *Job) NeedsCheck(request QueuedRequest) bool {
+	if request.Request.TopLevel {
+		return state.preflight != nil
+	}
+	return job.Robots() != ROBOTS_IGNORE
+}
+
+// Whether all requests of the job have been performed
+func (job *Job) Done() bool {
+	return !job.filling.Load() && job.queue.Len() == 0 && job.queue.Delayed() == 0 && job.queue.Unchecked() == 0 && job.queue.InFlight() == 0
+}
+
+// The progress of the job
+func (job *Job) Status() scraping.JobStatus {
+	counters := job.queue.Counters()
+	return scraping.JobStatus{
+		Name: job.Name,
+		Spec: job.Spec,
+		Priority: job.Priority,
+		Created: job.Created,
+		Done: job.Done(),
+		Queued: job.queue.Len(),
+		Delayed: job.queue.Delayed(),
+		Checking: job.queue.Unchecked(),
+		InFlight: job.queue.InFlight(),
+		URLsToRequest: counters.TotalURLsToRequest,
+		Scraped: counters.TotalScraped,
+		Scripts: counters.TotalScripts,
+		Failures: counters.TotalFailures,
+		DNSErrors: counters.TotalDNSErrors,
+		Timeouts: counters.TotalTimeouts,
+		Retries: counters.Retries,
+		Skipped: counters.Skipped,
+		Duplicates: counters.Duplicates,
+		Errors: counters.Errors,
+	}
+}
+
+// The specs sent along with the batches of the job, none if nodes use their own settings
+func (job *Job) Specs() []scraping.JobSpec {
+	if job.Spec == nil {
+		return nil
+	}
+	return []scraping.JobSpec{*job.Spec}
+}
+
+// Maximum number of pages scraped for each top level page, the page itself
+// and the links followed up to the maximum depth, used to estimate the remaining time
+func (job *Job) PagesPerTopLevel() int {
+	spec := DefaultJobSpec(job.Name) // The defaults of the nodes
+	if job.Spec != nil {
+		spec = *job.Spec
+	}
+	pages, level := 1, 1
+	for depth := 1; depth <= spec.MaxDepth && pages < 1000000; depth++ {
+		level *= spec.URLsToExtract
+		pages += level
+	}
+	return pages
+}
+
+// The spec of a job, for the settings that are not given in the jobs file
+func DefaultJobSpec(name string) scraping.JobSpec {
+	return scraping.JobSpec{Name: name, TimeoutSeconds: 35, URLsToExtract: 3, MaxDepth: 1, LinkSelection: scraping.LinkSelectionRandom, LinkPatterns: scraping.DefaultLinkPatterns, PostLoadWaitSeconds: 5}
+}
+
+// Fill the settings of a spec that were added after it was written: links are
+// then only followed from top level pages, and selected at random
+func WithDefaults(spec scraping.JobSpec) scraping.JobSpec {
+	if spec.MaxDepth == 0 {
+		spec.MaxDepth = 1
+	}
+	if spec.LinkSelection == "" {
+		spec.LinkSelection = scraping.LinkSelectionRandom
+	}
+	return spec
+}
+
+// Load the specs of the jobs from a TOML file, in which each job is a [[job]] table
+func LoadJobSpecs(path string) ([]scraping.JobSpec, error) {
+	var file struct {
+		Jobs []toml.Primitive `toml:"job"`
+	}
+	metadata, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]scraping.JobSpec, 0, len(file.Jobs))
+	names := make(map[string]bool)
+	for i, primitive := range file.Jobs {
+		job := DefaultJobSpec("")
+		if err := metadata.PrimitiveDecode(primitive, &job); err != nil {
+			return nil, fmt.Errorf("job %d: %v", i + 1, err)
+		}
+		if err := ValidateJobSpec(job); err != nil {
+			return nil, fmt.Errorf("job %d: %v", i + 1, err)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("job %s is defined twice", job.Name)
+		}
+		names[job.Name] = true
+		jobs = append(jobs, job)
+	}
+	if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown setting %s", undecoded[0].String())
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no job defined in %s", path)
+	}
+	return jobs, nil
+}
+
+// Load a job from a JSON file holding a scraping.JobSubmission
+func LoadJobSubmission(path string) (scraping.JobSubmission, error) {
+	var submission scraping.JobSubmission
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return submission, err
+	}
+	if err := json.Unmarshal(content, &submission); err != nil {
+		return submission, err
+	}
+	return submission, nil
+}
+
+// Check that the spec of a job makes sense
+func ValidateJobSpec(job scraping.JobSpec) error {
+	if !jobNameRegexp.MatchString(job.Name) {
+		return fmt.Errorf("invalid job name %q, names can only contain letters, digits, '_', '.' and '-'", job.Name)
+	}
+	if job.TimeoutSeconds < 1 {
+		return fmt.Errorf("timeout_seconds must be at least 1, got %d", job.TimeoutSeconds)
+	}
+	if job.URLsToExtract < 0 {
+		return fmt.Errorf("urls_to_extract cannot be negative, got %d", job.URLsToExtract)
+	}
+	if job.MaxDepth < 1 {
+		return fmt.Errorf("max_depth must be at least 1, got %d", job.MaxDepth)
+	}
+	if err := scraping.ValidateLinkSelection(job.LinkSelection, job.LinkPatterns); err != nil {
+		return err
+	}
+	if job.PostLoadWaitSeconds < 0 {
+		return fmt.Errorf("post_load_wait_seconds cannot be negative, got %d", job.PostLoadWaitSeconds)
+	}
+	if job.Robots != "" && !ValidRobotsMode(job.Robots) {
+		return fmt.Errorf("robots must be one of %s, got %q", strings.Join(ROBOTS_MODES, ", "), job.Robots)
+	}
+	return nil
+}
+
+// Start a new job
+func (t *Server) SubmitJob(args *scraping.JobSubmission, reply *bool) error {
+	if _, err := state.jobs.Create(*args, state.config.dataDir, state.config.sinks); err != nil {
+		log.Printf("Cannot create job %s: %v", (*args).Name, err)
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// List the jobs and their progress
+func (t *Server) ListJobs(args *bool, reply *[]scraping.JobStatus) error {
+	jobs := state.jobs.All()
+	*reply = make([]scraping.JobStatus, len(jobs))
+	for i, job := range jobs {
+		(*reply)[i] = job.Status()
+	}
+	return nil
+}
@@ -3,44 +3,69 @@ package main
 import (
 	"os/signal"
 	"syscall"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"time"
+	"sort"
 	"strings"
+	"sync"
 	"net"
 	"net/http"
 	"net/rpc"
 	"math/rand"
 	"io/ioutil"
+	"metrics"
 	"scraping"
 )
 
-const MAX_URLS_PER_DOMAIN = 4
-
 // Configuration of the coordinator node
 type Config struct {
 	batchSize int // Size of the batches sent to the nodes
 	myAddress string // The IP and port on which the coordinator is listening
 	myPort string // Only the port
+	dataDir string // The directory in which the queue and the results are stored
+	sinks string // The sinks in which results are stored, separated by commas
+	leaseDuration time.Duration // How long a node can hold a batch before it is given to another node
+	heartbeatTimeout time.Duration // How long a node can stay silent before being considered dead
+	jobsFile string // TOML file with the specs of the jobs, empty to let nodes use their own settings
+	urlsFile string // The URLs to scrape when starting from an empty data directory
+	jobFile string // JSON job to create when starting from an empty data directory, instead of scraping the URLs of urlsFile
+	keepRunning bool // Whether to wait for new jobs once all jobs are done
+	retryPolicy RetryPolicy // Which failed requests are retried
+	dnsWorkers int // Number of host names resolved concurrently before dispatch, 0 to let nodes resolve them
+	dnsResolvers string // The DNS resolvers used before dispatch, separated by commas, empty for the ones of the system
+	dnsCacheTTL time.Duration // How long the answers of the resolvers are cached
+	robots string // How jobs that do not say otherwise handle links disallowed by robots.txt (see ROBOTS_MODES)
+	robotsAgent string // The user-agent token whose robots.txt rules are followed
+	robotsWorkers int // Number of links checked against robots.txt concurrently
+	robotsCacheTTL time.Duration // How long robots.txt files are cached
+	frontier string // How jobs remember the URLs they have queued (see FRONTIER_KINDS)
+	frontierCapacity int // Number of URLs per job for which Bloom filters are sized
+	frontierErrorRate float64 // Rate of new URLs taken for duplicates by Bloom filters, at their capacity
+	hostDelay time.Duration // Minimum delay between the dispatch of two requests to the same domain
+	hostInFlight int // Maximum number of requests to the same domain in batches that have not completed, 0 for no limit
 }
 
 type State struct {
 	config Config
-	nodes []scraping.Node
-	queueChan chan scraping.Request
+	registry *Registry
+	jobs *Jobs
+	blobs BlobStore
+	preflight *Preflight // nil if host names are not resolved before dispatch
+	robots *RobotsCache
+	hostChecks Wakeup // Signaled when top level requests may be waiting to be resolved
+	linkChecks Wakeup // Signaled when links may be waiting to be looked up in robots.txt
+	politeness *Politeness // nil if requests to the same domain are not limited
 	shutdownChan chan bool
 	nodeReadyChan chan scraping.Node
 	startTime time.Time
 	lastReadyTime time.Time
-	totalURLsToRequest int
-	totalScraped int
-	totalTimeouts int
-	totalDNSErrors int
-	totalFailures int
-	totalScripts int
-	batchesDispatched int
-	resultsReceived int
+	batchDurations *metrics.Histogram // Observed when the results of a batch are received
+	recent *RecentModules // The WebAssembly modules found last, shown on the dashboard
+	lock sync.Mutex // Protects paused
+	paused bool // Whether dispatching batches has been paused
 }
 var state State
 
@@ -53,46 +78,132 @@ func (t *Server) Results(args *scraping.BatchResult, reply *bool) error {
 	} else {
 		log.Printf("Received results from %s", (*args).Node.URL)
 	}
-	for _, req := range (*args).NotQueried {
-		state.queueChan <- req // Reschedule failed requests
+	if err := scraping.CheckVersion((*args).Version); err != nil {
+		log.Printf("Rejecting results from %s: %v", (*args).Node.URL, err)
+		return err
+	}
+	job, batch, found := state.jobs.BatchOf((*args).BatchID, (*args).Node)
+	expiredJob, expired, late := state.jobs.ExpiredBatchOf((*args).BatchID, (*args).Node)
+	if !found && !late {
+		log.Printf("No batch is known to be in flight on %s, keeping the results whose requests are still queued", (*args).Node.URL)
+	} else if late {
+		job = expiredJob
 	}
-	if len((*args).Results) > 0 {
-		for _, result := range (*args).Results {
-			state.resultsReceived += 1
-			StoreResult(result)
-			for _, url := range result.URLs {
-				state.queueChan <- scraping.Request{url, false} // Not a toplevel url
+	state.registry.Received((*args).Node, len((*args).Results))
+	config := state.registry.Config((*args).Node)
+	for _, result := range (*args).Results {
+		if !found && !late {
+			// Rely on the node to know to which job the result belongs
+			var known bool
+			if job, known = state.jobs.Get(result.Job); !known {
+				log.Printf("Ignoring result for %s from %s, job %q is unknown", result.URL, (*args).Node.URL, result.Job)
+				continue
+			}
+		}
+		record := ResultRecord{Result: result, Node: (*args).Node.URL, Config: config, Attempt: 1, Received: time.Now()}
+		if !found && !late {
+			// The batch was dispatched before a restart, its requests have been put back in the queue
+			request, keep := job.queue.ClaimResult(result.Key())
+			if !keep {
+				log.Printf("Ignoring result for %s from %s, its request is not queued anymore", result.URL, (*args).Node.URL)
+				continue
+			}
+			record.TopLevel = request.Request.TopLevel
+			record.Parent = request.Parent
+			record.Depth = request.Request.Depth
+			record.Attempt = request.Attempt
+		} else if found {
+			request, _ := batch.Find(result.Key())
+			record.TopLevel = request.Request.TopLevel
+			record.Parent = request.Parent
+			record.Depth = request.Request.Depth
+			record.Attempt = request.Attempt
+			record.Dispatched = batch.Dispatched
+		} else if late {
+			request, keep := job.queue.ClaimLateResult(expired, result.Key())
+			if !keep {
+				log.Printf("Ignoring late result for %s from %s, it has been rescheduled", result.URL, (*args).Node.URL)
+				continue
 			}
-			state.totalURLsToRequest += len(result.URLs)
+			log.Printf("Keeping late result for %s from %s", result.URL, (*args).Node.URL)
+			record.TopLevel = request.Request.TopLevel
+			record.Parent = request.Parent
+			record.Depth = request.Request.Depth
+			record.Attempt = request.Attempt
+			record.Dispatched = expired.Dispatched
+		}
+		delay, retry := time.Duration(0), false
+		if result.Error != scraping.ErrorNone {
+			delay, retry = state.config.retryPolicy.Retry(result.Error, record.Attempt)
+			record.Retried = retry
+		}
+		if found {
+			job.queue.Stored(batch.ID, result)
+		} else {
+			job.queue.Stored(0, result)
+		}
+		StoreResult(job, record)
+		if retry {
+			log.Printf("Retrying %s in %s after %s (attempt %d)", result.URL, delay.String(), result.Error, record.Attempt)
+			job.queue.Retry(QueuedRequest{Request: scraping.Request{URL: result.URL, TopLevel: record.TopLevel, Job: job.Name, Depth: record.Depth}, Attempt: record.Attempt + 1, Parent: record.Parent}, time.Now().Add(delay))
+		}
+		urls := make([]QueuedRequest, 0, len(result.URLs))
+		for _, url := range result.URLs {
+			urls = append(urls, QueuedRequest{Request: scraping.Request{URL: url, TopLevel: false, Job: job.Name, Depth: record.Depth + 1}, Attempt: 1, Parent: result.URL}) // Not a toplevel url
+		}
+		job.Enqueue(urls)
+	}
+	if found {
+		// Reschedule the requests without result, such as those that have not
+		// been performed (NotQueried). Those of an expired batch have already been rescheduled.
+		job.queue.Complete(batch.ID)
+		if state.politeness != nil {
+			state.politeness.Release(batch.Requests)
 		}
+		state.batchDurations.Observe(time.Now().Sub(batch.Dispatched).Seconds(), job.Name)
 	}
 	*reply = true
 	return nil
 }
 
-func (t *Server) NodeReady(args *scraping.Node, reply *bool) error {
-	log.Printf("Node is ready: %s", (*args).URL)
-	MarkReady(*args)
-	nodeAlreadySeen := false
-	for _, node := range state.nodes {
-		if node == *args {
-			nodeAlreadySeen = true
-		}
+func (t *Server) NodeReady(args *scraping.Ready, reply *bool) error {
+	node := (*args).Node()
+	if err := scraping.CheckVersion((*args).Version); err != nil {
+		// The node runs another build, it cannot be sent batches
+		log.Printf("Rejecting node %s: %v", node.URL, err)
+		return err
+	}
+	log.Printf("Node is ready: %s", node.URL)
+	for _, job := range state.jobs.All() {
+		job.queue.ForgetExpired(node)
 	}
-	if !nodeAlreadySeen {
-		state.nodes = append(state.nodes, *args)
+	if state.registry.Ready(node, (*args).Config) {
+		MarkReady(node)
+	} else {
+		log.Printf("Node %s is draining, not sending it new batches", node.URL)
 	}
 	*reply = true
 	return nil
 }
 
-func (t *Server) Shutdown(args *bool, reply *bool) error {
-	state.shutdownChan <- true
+func (t *Server) Heartbeat(args *scraping.Heartbeat, reply *bool) error {
+	if err := scraping.CheckVersion((*args).Version); err != nil {
+		return err
+	}
+	if state.registry.Heartbeat(*args) {
+		log.Printf("Node %s is back", (*args).Node.URL)
+		MarkReady((*args).Node)
+	}
+	for _, job := range state.jobs.All() {
+		job.queue.Renew((*args).Node, (*args).BatchDone, state.config.leaseDuration)
+	}
+	*reply = true
 	return nil
 }
 
+
 func SetupSIGTERMHandler() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -106,6 +217,8 @@ func StartServer() {
 	server := new(Server)
 	rpc.Register(server)
 	rpc.HandleHTTP()
+	HandleDashboard()
+	HandleMetrics()
 	l, err := net.Listen("tcp", ":" + state.config.myPort)
 	if err != nil {
 		log.Fatalf("Could not listen on port %s: %v", state.config.myPort, err)
@@ -120,29 +233,162 @@ func ExtractPort(url string) string {
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
-	if len(os.Args) != 2 {
-		log.Fatalf("Expected 1 argument, got %d", len(os.Args)-1)
+	flag.StringVar(&state.config.dataDir, "data", "/tmp/out", "directory in which the queue and the results are stored")
+	flag.StringVar(&state.config.sinks, "results", "jsonl,logs", "where to store results, as a comma-separated list of jsonl, sqlite and logs")
+	flag.DurationVar(&state.config.leaseDuration, "lease", 30 * time.Minute, "how long a node can hold a batch before it is given to another node")
+	flag.DurationVar(&state.config.heartbeatTimeout, "heartbeat-timeout", 2 * time.Minute, "how long a node can stay silent before being considered dead")
+	flag.StringVar(&state.config.jobsFile, "jobs", "", "TOML file with the specs of the jobs, each URL being scraped once per job")
+	flag.StringVar(&state.config.urlsFile, "urls", "urls.txt", "URLs to scrape when starting from an empty data directory")
+	flag.StringVar(&state.config.jobFile, "job-file", "", "JSON job (e.g., written by reschedule) to create when starting from an empty data directory, instead of scraping urls.txt")
+	flag.BoolVar(&state.config.keepRunning, "keep-running", false, "wait for new jobs once all jobs are done, instead of terminating")
+	flag.IntVar(&state.config.dnsWorkers, "dns-workers", 16, "number of host names of top level URLs resolved concurrently before dispatch, 0 to disable resolution")
+	flag.StringVar(&state.config.dnsResolvers, "dns-resolvers", "", "comma-separated DNS resolvers (host:port) used before dispatch, the ones of the system if empty")
+	flag.DurationVar(&state.config.dnsCacheTTL, "dns-cache-ttl", 1 * time.Hour, "how long the answers of the DNS resolvers are cached")
+	flag.StringVar(&state.config.robots, "robots", ROBOTS_IGNORE, "how jobs that do not set robots handle links disallowed by robots.txt: ignore, respect (skip them) or record (follow them, but record them)")
+	flag.StringVar(&state.config.robotsAgent, "robots-agent", "*", "user-agent token whose robots.txt rules are followed, only the rules for all crawlers being followed if *")
+	flag.IntVar(&state.config.robotsWorkers, "robots-workers", 8, "number of links checked against robots.txt concurrently")
+	flag.DurationVar(&state.config.robotsCacheTTL, "robots-cache-ttl", 24 * time.Hour, "how long robots.txt files are cached")
+	flag.StringVar(&state.config.frontier, "frontier", FRONTIER_EXACT, "how jobs remember the URLs they have queued, so that each page is requested once: exact, bloom (less memory, a few new URLs being taken for duplicates) or none")
+	flag.IntVar(&state.config.frontierCapacity, "frontier-capacity", 10000000, "number of URLs per job for which Bloom filters are sized")
+	flag.Float64Var(&state.config.frontierErrorRate, "frontier-error-rate", 0.001, "rate of new URLs taken for duplicates by Bloom filters, once they hold as many URLs as their capacity")
+	flag.DurationVar(&state.config.hostDelay, "host-delay", 10 * time.Second, "minimum delay between the dispatch of two requests to the same registrable domain")
+	flag.IntVar(&state.config.hostInFlight, "host-in-flight", 2, "maximum number of requests to the same registrable domain in batches that have not completed, across all nodes, 0 for no limit")
+	retriesFile := flag.String("retries", "", "TOML file with the policy to retry failed requests, a default policy being used if empty")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("Expected 1 argument, got %d", flag.NArg())
+	}
+	if !ValidRobotsMode(state.config.robots) {
+		log.Fatalf("Invalid -robots %q, expected one of %s", state.config.robots, strings.Join(ROBOTS_MODES, ", "))
+	}
+	switch {
+	case state.config.frontier != FRONTIER_NONE && state.config.frontier != FRONTIER_EXACT && state.config.frontier != FRONTIER_BLOOM:
+		log.Fatalf("Invalid -frontier %q, expected one of %s", state.config.frontier, strings.Join(FRONTIER_KINDS, ", "))
+	case state.config.frontierCapacity < 1:
+		log.Fatalf("-frontier-capacity must be at least 1, got %d", state.config.frontierCapacity)
+	case state.config.frontierErrorRate <= 0 || state.config.frontierErrorRate >= 1:
+		log.Fatalf("-frontier-error-rate must be between 0 and 1, got %v", state.config.frontierErrorRate)
+	}
+	if state.config.robotsWorkers < 1 {
+		log.Fatalf("-robots-workers must be at least 1, got %d", state.config.robotsWorkers)
 	}
-	urls := LoadURLs("urls.txt")
-	queueBufferSize := MAX_URLS_PER_DOMAIN * len(urls)
 
-	state.config.myAddress = os.Args[1]
-	state.config.myPort = ExtractPort(os.Args[1])
+	state.config.myAddress = flag.Arg(0)
+	state.config.myPort = ExtractPort(flag.Arg(0))
 	state.config.batchSize = 100
-	state.nodes = make([]scraping.Node, 0)
-	state.queueChan = make(chan scraping.Request, queueBufferSize)
+	state.config.retryPolicy = DefaultRetryPolicy()
+	if *retriesFile != "" {
+		policy, err := LoadRetryPolicy(*retriesFile)
+		if err != nil {
+			log.Fatalf("Invalid retry policy in %s: %v", *retriesFile, err)
+		}
+		state.config.retryPolicy = policy
+	}
+	state.registry = NewRegistry()
 	state.shutdownChan = make(chan bool, 0)
 	state.nodeReadyChan = make(chan scraping.Node, 100)
 	state.startTime = time.Now()
 	state.lastReadyTime = state.startTime
-	// All other counters are initialized to 0 by default
+	state.recent = NewRecentModules(20)
+	if state.config.hostDelay > 0 || state.config.hostInFlight > 0 {
+		state.politeness = NewPoliteness(state.config.hostDelay, state.config.hostInFlight)
+	}
+	if state.config.dnsWorkers > 0 {
+		state.preflight = NewPreflight(ParseResolvers(state.config.dnsResolvers), state.config.dnsCacheTTL)
+	}
+	state.robots = NewRobotsCache(state.config.robotsAgent, state.config.robotsCacheTTL)
+	state.hostChecks = NewWakeup()
+	state.linkChecks = NewWakeup()
+	if err := os.MkdirAll(state.config.dataDir, 0755); err != nil {
+		log.Fatalf("Cannot create data directory %s: %v", state.config.dataDir, err)
+	}
+	// All counters are initialized to 0 by default, or restored from the journals
+	state.jobs = LoadJobs(state.config.dataDir, state.config.sinks)
+	blobs, err := OpenFSBlobStore(state.config.dataDir)
+	if err != nil {
+		log.Fatalf("Cannot open blob store in %s: %v", state.config.dataDir, err)
+	}
+	state.blobs = blobs
+	if len(state.jobs.All()) > 0 {
+		log.Printf("Resuming scraping from %s", state.config.dataDir)
+		for _, file := range []string{state.config.jobsFile, state.config.jobFile} {
+			if file != "" {
+				log.Printf("Ignoring %s, using the jobs of the resumed scraping", file)
+			}
+		}
+	} else if state.config.jobFile != "" {
+		submission, err := LoadJobSubmission(state.config.jobFile)
+		if err != nil {
+			log.Fatalf("Invalid job in %s: %v", state.config.jobFile, err)
+		}
+		if _, err := state.jobs.Create(submission, state.config.dataDir, state.config.sinks); err != nil {
+			log.Fatalf("Cannot create job %s: %v", submission.Name, err)
+		}
+	} else if _, err := os.Stat(state.config.urlsFile); err == nil || !state.config.keepRunning {
+		Initialize(LoadURLs(state.config.urlsFile))
+	}
 
 	StartServer()
+	CheckRequests()
 	go ServeBatches()
+	go MonitorLeases()
+	go MonitorNodes()
 	go FrequentlyPrintStats()
-	Initialize(urls)
 	SetupSIGTERMHandler()
 	<- state.shutdownChan
+	state.jobs.Close()
+	state.robots.Close()
+}
+
+// Wakes up idle workers when there may be work for them
+type Wakeup chan bool
+
+func NewWakeup() Wakeup {
+	return make(chan bool, 1)
+}
+
+// Wake up one waiting worker, or the next one to wait
+func (w Wakeup) Notify() {
+	select {
+	case w <- true:
+	default:
+	}
+}
+
+// Wake up the workers checking requests, after requests have been queued
+func WakeCheckers() {
+	state.hostChecks.Notify()
+	state.linkChecks.Notify()
+}
+
+// Check the new requests of all jobs before they are dispatched, each kind
+// with its own workers so that slow robots.txt fetches do not hold up DNS
+// lookups: the host names of top level requests are resolved (-dns-workers),
+// and links are looked up in robots.txt (-robots-workers)
+func CheckRequests() {
+	if state.preflight != nil {
+		StartCheckers("host name", state.config.dnsWorkers, true, state.hostChecks, state.preflight.Check)
+	}
+	StartCheckers("robots.txt", state.config.robotsWorkers, false, state.linkChecks, state.robots.Check)
+}
+
+// Start workers checking the top level requests or the links of all jobs
+func StartCheckers(kind string, workers int, topLevel bool, wakeup Wakeup, check func(*Job, QueuedRequest)) {
+	log.Printf("Starting %d %s checkers", workers, kind)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				job, request, found := state.jobs.NextUnchecked(topLevel)
+				if !found {
+					<-wakeup
+					continue
+				}
+				// There may be more requests to check, let another worker look for them
+				wakeup.Notify()
+				check(job, request)
+			}
+		}()
+	}
 }
 
 // Returns the URLs to scrape
@@ -159,13 +405,24 @@ func LoadURLs(urlsFile string) []string {
 	return lines
 }
 
-// Initialize the scraping, queuing all top level urls to scrape
+// Initialize the scraping, creating a job for each spec of the jobs file, or
+// a single default job if there is none, to scrape the given top level urls
 func Initialize(lines []string) {
-	// Put all URLs in the queue
-	for _, line := range lines {
-		if line != "" { // Filter empty lines, in case there are any
-			state.queueChan <- scraping.Request{line, true} // This is a top level request
-			state.totalURLsToRequest += 1
+	submissions := []scraping.JobSubmission{{Name: "default", URLs: lines}}
+	if state.config.jobsFile != "" {
+		specs, err := LoadJobSpecs(state.config.jobsFile)
+		if err != nil {
+			log.Fatalf("Invalid jobs: %v", err)
+		}
+		submissions = make([]scraping.JobSubmission, len(specs))
+		for i := range specs {
+			// Jobs with the same priority alternate, so the same URLs are scraped by all jobs at around the same time
+			submissions[i] = scraping.JobSubmission{Name: specs[i].Name, Spec: &specs[i], URLs: lines}
+		}
+	}
+	for _, submission := range submissions {
+		if _, err := state.jobs.Create(submission, state.config.dataDir, state.config.sinks); err != nil {
+			log.Fatalf("Cannot create job %s: %v", submission.Name, err)
 		}
 	}
 }
@@ -180,16 +437,21 @@ func MarkReady(node scraping.Node) {
 // Terminate scraping
 func EndScraping() {
 	log.Println("Terminating scraping nodes")
-	// Notify all nodes to terminate
-	for _, node := range state.nodes {
-		client, err := rpc.DialHTTP("tcp", node.URL)
+	// Notify all nodes that are still alive to terminate
+	for _, info := range state.registry.Nodes() {
+		if info.State == NodeDead {
+			continue
+		}
+		client, err := rpc.DialHTTP("tcp", info.Node.URL)
 		if err != nil {
-			log.Fatalf("Could not notify node %s of shutdown: %v", node.URL, err)
+			log.Printf("Could not notify node %s of shutdown: %v", info.Node.URL, err)
+			continue
 		}
 		var reply bool
 		err = client.Call("NodeServer.Shutdown", true, &reply)
+		client.Close()
 		if err != nil {
-			log.Fatalf("Could not notify node %s of shutdown: %v", node.URL, err)
+			log.Printf("Could not notify node %s of shutdown: %v", info.Node.URL, err)
 		}
 	}
 	// Terminate main server
@@ -197,53 +459,97 @@ func EndScraping() {
 }
 
 // Dispatch a batch of request to a node that is ready, wait for one if needed
-func DispatchBatch(requests []scraping.Request) {
+func DispatchBatch(job *Job, queued []QueuedRequest) {
+	requests := make([]scraping.Request, len(queued))
+	for i, request := range queued {
+		requests[i] = request.Request
+	}
 	for {
 		log.Println("Waiting for a node to be ready")
 		node := <- state.nodeReadyChan
-		state.batchesDispatched += 1
-		log.Printf("Dispatching batch %d/%d to %s", state.batchesDispatched, state.totalURLsToRequest / state.config.batchSize, node.URL)
+		for Paused() {
+			// Hold the batch until the dispatch is resumed
+			<-time.After(1 * time.Second)
+		}
+		if !state.registry.Busy(node) {
+			// The node has been drained or died since it was ready
+			continue
+		}
+		batch := job.queue.Dispatch(state.jobs.NextBatchID(), node, queued, state.config.leaseDuration)
+		counters := job.queue.Counters()
+		log.Printf("Dispatching batch %d of job %s (%d/%d) to %s", batch.ID, job.Name, counters.BatchesDispatched, counters.TotalURLsToRequest / state.config.batchSize, node.URL)
 		var reply bool
 		client, err := rpc.DialHTTP("tcp", node.URL)
 		if err != nil {
-			log.Fatalf("Could not send batch to node %s: %v", node.URL, err)
+			// The node is gone, give the batch to another node
+			log.Printf("Could not send batch to node %s: %v", node.URL, err)
+			job.queue.Abort(batch)
+			state.registry.Lost(node)
+			continue
 		}
-		err = client.Call("NodeServer.Batch", scraping.Batch{requests}, &reply)
+		err = client.Call("NodeServer.Batch", scraping.Batch{Version: scraping.ProtocolVersion, ID: batch.ID, Requests: requests, Jobs: job.Specs()}, &reply)
+		client.Close()
 		if err != nil {
-			log.Fatalf("Could not send batch to node %s: %v", node.URL, err)
+			log.Printf("Could not send batch to node %s: %v", node.URL, err)
+			job.queue.Abort(batch)
+			if scraping.IsIncompatible(err) {
+				// The node runs another build, it is alive but cannot be used
+				state.registry.Drain(node)
+			} else {
+				state.registry.Lost(node)
+			}
+			continue
 		}
 		return
 	}
 }
 
-// Serve the batches by looking for requests on queueChan
+// Frequently check for batches whose lease has expired, and put their requests back in the queue
+func MonitorLeases() {
+	for {
+		<-time.After(1 * time.Minute)
+		for _, batch := range state.jobs.Expire(time.Now(), nil) {
+			log.Printf("Lease of batch %d on %s expired, its requests have been rescheduled", batch.ID, batch.Node.URL)
+		}
+	}
+}
+
+// Serve the batches by looking for requests in the queues of the jobs
 func ServeBatches() {
 	for {
-		batch := make([]scraping.Request, 0, state.config.batchSize)
-		// Get enough URLs
-		for i := 0; i < state.config.batchSize; {
-			select {
-			case request := <- state.queueChan:
-				batch = append(batch, request)
-				i++
-			case <-time.After(10 * time.Second):
-				// No more responses to expect?
-				if state.batchesDispatched == state.resultsReceived {
-					// Yes, stop looking for more URLs for this batch
-					i = state.config.batchSize // no way to break out of the for loop without this
-				} // Otherwise, really wait for next URL
-			}
+		if Paused() {
+			<-time.After(1 * time.Second)
+			continue
 		}
-		if len(batch) == 0 {
+		job := state.jobs.Next()
+		if job == nil {
 			// No URLs to dispatch, check if all nodes are finished
-			if state.batchesDispatched == state.resultsReceived {
+			if state.jobs.Done() && !state.config.keepRunning {
 				// If so, scraping is done
 				EndScraping()
 				return;
-			} // Otherwise, do nothing, there might be later batches coming
-		} else {
+			} // Otherwise, wait, there might be later batches coming or new jobs submitted
+			<-time.After(1 * time.Second)
+			continue
+		}
+		batch := make([]QueuedRequest, 0, state.config.batchSize)
+		var accept func(QueuedRequest) bool
+		if state.politeness != nil {
+			// Skip the requests to domains that have been requested too recently or too much
+			accept = state.politeness.Acquire
+		}
+		// Get enough URLs from the job
+		for len(batch) < state.config.batchSize {
+			request, ok := job.queue.Pop(10 * time.Second, accept)
+			if !ok {
+				// Do not hold back the requests found so far, later ones will go in the next batch
+				break
+			}
+			batch = append(batch, request)
+		}
+		if len(batch) > 0 {
 			// Dispatch the batch to one of the nodes that are ready
-			DispatchBatch(batch)
+			DispatchBatch(job, batch)
 		}
 	}
 }
@@ -255,54 +561,96 @@ func FrequentlyPrintStats() {
 		PrintStats()
 	}
 }
+// The overall progress of the scraping
+type Progress struct {
+	Counters
+	Queued int // Requests waiting to be dispatched
+	Delayed int // Requests waiting to be retried
+	Checking int // Requests waiting to be checked before dispatch
+	InFlight int // Batches dispatched to nodes
+	Elapsed time.Duration
+	Rate float64 // URLs scraped per second
+	// Bounds on the remaining time, depending on how many links are followed, 0 if unknown
+	MinRemaining time.Duration
+	MaxRemaining time.Duration
+}
+
+// Compute the progress of the scraping over all jobs
+func ComputeProgress() Progress {
+	var progress Progress
+	// Upper bound on the number of URLs to scrape, if all links are followed
+	maxURLs := 0
+	for _, job := range state.jobs.All() {
+		c := job.queue.Counters()
+		progress.Add(c)
+		progress.Queued += job.queue.Len()
+		progress.Delayed += job.queue.Delayed()
+		progress.Checking += job.queue.Unchecked()
+		progress.InFlight += job.queue.InFlight()
+		maxURLs += c.TotalURLsToRequest * job.PagesPerTopLevel() + c.Retries
+	}
+	progress.Elapsed = time.Now().Sub(state.startTime)
+	if progress.Elapsed.Seconds() != 0 {
+		progress.Rate = float64(progress.TotalScraped) / progress.Elapsed.Seconds()
+	}
+	if progress.Rate != 0 {
+		// Each retry is an additional request to perform, and skipped links will never be requested
+		progress.MinRemaining = time.Duration(float64(progress.TotalURLsToRequest + progress.Retries - progress.Skipped - progress.TotalScraped) / progress.Rate) * time.Second
+		progress.MaxRemaining = time.Duration(float64(maxURLs - progress.Skipped - progress.TotalScraped) / progress.Rate) * time.Second
+	}
+	return progress
+}
+
 // Print the stats of the scraping process
 func PrintStats() {
-	var t = time.Now().Sub(state.startTime)
-	var rate float64
-	if t.Seconds() == 0 {
-		rate = 0
-	} else {
-		rate = float64(state.totalScraped) / t.Seconds()
+	progress := ComputeProgress()
+	log.Printf("Scraped %d URLs (on %d to scrape so far) in %s [%v URL/s]:", progress.TotalScraped, progress.TotalURLsToRequest, progress.Elapsed.String(), progress.Rate)
+	log.Printf("\t%d scripts found, %d failures, %d DNS errors, %d timeouts", progress.TotalScripts, progress.TotalFailures, progress.TotalDNSErrors, progress.TotalTimeouts)
+	if len(progress.Errors) > 0 {
+		log.Printf("\tErrors: %s", FormatErrors(progress.Errors))
+	}
+	log.Printf("\t%d requests queued, %d waiting to be checked, %d waiting to be retried (%d retries so far), %d batches in flight, %d links disallowed by robots.txt, %d duplicate URLs left out", progress.Queued, progress.Checking, progress.Delayed, progress.Retries, progress.InFlight, progress.Skipped, progress.Duplicates)
+	for _, job := range state.jobs.All() {
+		status := job.Status()
+		log.Printf("\tJob %s (priority %d): scraped %d URLs on %d, %d queued, %d batches in flight", status.Name, status.Priority, status.Scraped, status.URLsToRequest, status.Queued, status.InFlight)
 	}
-	log.Printf("Scraped %d URLs (on %d to scrape so far) in %s [%v URL/s]:", state.totalScraped, state.totalURLsToRequest, t.String(), rate)
-	log.Printf("\t%d scripts found, %d failures, %d DNS errors, %d timeouts", state.totalScripts, state.totalFailures, state.totalDNSErrors, state.totalTimeouts)
 	log.Printf("\tLast node ready was %s ago", time.Now().Sub(state.lastReadyTime).String())
-	if rate != 0 {
-		log.Printf("\tRemaining time: between %s and %s",
-			(time.Duration(float64(state.totalURLsToRequest - state.totalScraped) / rate) * time.Second).String(),
-			(time.Duration(float64((state.totalURLsToRequest * 4) - state.totalScraped) / rate) * time.Second).String())
+	for _, info := range state.registry.Nodes() {
+		log.Printf("\tNode %s is %s, %d batches done", info.Node.URL, info.State.String(), info.BatchesDone)
+	}
+	if progress.Rate != 0 {
+		log.Printf("\tRemaining time: between %s and %s", progress.MinRemaining.String(), progress.MaxRemaining.String())
 	}
 }
 
-// Add a line to a given file
-func AddLine(file string, line string) {
-	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("Cannot write to file %s: %v", file, err)
+// The number of results for each error code, by decreasing number
+func FormatErrors(errors map[scraping.ErrorCode]int) string {
+	codes := make([]scraping.ErrorCode, 0, len(errors))
+	for code := range errors {
+		codes = append(codes, code)
 	}
-	defer f.Close()
-	if _, err := f.WriteString(line + "\n"); err != nil {
-		log.Fatalf("Cannot write to file %s: %v", file, err)
+	sort.Slice(codes, func(i, j int) bool {
+		if errors[codes[i]] != errors[codes[j]] {
+			return errors[codes[i]] > errors[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%d %s", errors[code], code)
 	}
+	return strings.Join(parts, ", ")
 }
 
-// Store the result of a query
-func StoreResult(result scraping.Result) {
-	state.totalScraped += 1
-	if result.Timeout {
-		AddLine("/tmp/out/timeouts.log", result.URL)
-		state.totalTimeouts += 1
-	} else if result.DNSError {
-		AddLine("/tmp/out/dnserrors.log", result.URL)
-		state.totalDNSErrors += 1
-	} else if result.Failure {
-		AddLine("/tmp/out/failures.log", result.URL)
-		state.totalFailures += 1
-	} else if (len(result.Scripts) == 0) {
-		AddLine("/tmp/out/noscripts.log", result.URL)
-	} else {
-		log.Printf("Found a script! On page %s, scripts are %v", result.URL, result.Scripts)
-		AddLine("/tmp/out/scripts.log", fmt.Sprintf("%s %v", result.URL, result.Scripts))
-		state.totalScripts += 1
+// Store the result of a query in the results of its job, once it has been
+// journaled and counted (see Queue.Stored and Queue.Answered)
+func StoreResult(job *Job, record ResultRecord) {
+	if !record.Timeout && !record.DNSError && !record.Failure && len(record.Scripts) > 0 {
+		log.Printf("Found a script! On page %s, scripts are %v", record.URL, record.Scripts)
+	}
+	if err := job.sink.Store(record); err != nil {
+		log.Fatalf("Cannot store result for %s: %v", record.URL, err)
 	}
+	ReferenceBlobs(record.Result)
+	state.recent.Add(job.Name, record.Result)
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"metrics"
+)
+
+// Upper bounds of the buckets of the durations of batches, in seconds
+var batchDurationBuckets = []float64{30, 60, 120, 300, 600, 900, 1200, 1800, 3600}
+
+// Register the metrics of the coordinator, computed from the jobs and the
+// registry when they are scraped, and serve them on /metrics
+func HandleMetrics() {
+	registry := metrics.NewRegistry()
+	perJob := func(value func(job *Job) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			samples := make([]metrics.Sample, 0)
+			for _, job := range state.jobs.All() {
+				samples = append(samples, metrics.Sample{Labels: []string{job.Name}, Value: value(job)})
+			}
+			return samples
+		}
+	}
+	counter := func(name string, help string, value func(c Counters) int) {
+		registry.CounterFunc(name, help, perJob(func(job *Job) float64 { return float64(value(job.queue.Counters())) }), "job")
+	}
+	counter("scraping_urls_to_request_total", "URLs queued to be scraped", func(c Counters) int { return c.TotalURLsToRequest })
+	counter("scraping_pages_scraped_total", "Pages for which a result has been received", func(c Counters) int { return c.TotalScraped })
+	counter("scraping_pages_with_scripts_total", "Pages on which WebAssembly has been found", func(c Counters) int { return c.TotalScripts })
+	counter("scraping_failures_total", "Pages that could not be scraped", func(c Counters) int { return c.TotalFailures })
+	counter("scraping_dns_errors_total", "Pages whose host name could not be resolved", func(c Counters) int { return c.TotalDNSErrors })
+	counter("scraping_timeouts_total", "Pages that did not load in time", func(c Counters) int { return c.TotalTimeouts })
+	counter("scraping_retries_total", "Requests retried after an error", func(c Counters) int { return c.Retries })
+	counter("scraping_robots_skipped_total", "Links not followed as robots.txt disallows them", func(c Counters) int { return c.Skipped })
+	counter("scraping_duplicate_urls_total", "New URLs left out as the job had already queued them", func(c Counters) int { return c.Duplicates })
+	counter("scraping_batches_dispatched_total", "Batches sent to nodes", func(c Counters) int { return c.BatchesDispatched })
+	registry.CounterFunc("scraping_errors_total", "Results by error code", func() []metrics.Sample {
+		samples := make([]metrics.Sample, 0)
+		for _, job := range state.jobs.All() {
+			for code, count := range job.queue.Counters().Errors {
+				samples = append(samples, metrics.Sample{Labels: []string{job.Name, string(code)}, Value: float64(count)})
+			}
+		}
+		return samples
+	}, "job", "code")
+	registry.GaugeFunc("scraping_queued_requests", "Requests waiting to be dispatched", perJob(func(job *Job) float64 { return float64(job.queue.Len()) }), "job")
+	registry.GaugeFunc("scraping_delayed_requests", "Requests waiting to be retried", perJob(func(job *Job) float64 { return float64(job.queue.Delayed()) }), "job")
+	registry.GaugeFunc("scraping_checking_requests", "Requests waiting for their host name to be resolved or for robots.txt to be looked up", perJob(func(job *Job) float64 { return float64(job.queue.Unchecked()) }), "job")
+	registry.GaugeFunc("scraping_batches_in_flight", "Batches dispatched to nodes and not completed yet", perJob(func(job *Job) float64 { return float64(job.queue.InFlight()) }), "job")
+	registry.GaugeFunc("scraping_nodes", "Nodes known to the coordinator, by state", func() []metrics.Sample {
+		counts := make(map[NodeState]int)
+		for _, info := range state.registry.Nodes() {
+			counts[info.State] += 1
+		}
+		samples := make([]metrics.Sample, 0)
+		for _, s := range []NodeState{NodeReady, NodeBusy, NodeDraining, NodeDead} {
+			samples = append(samples, metrics.Sample{Labels: []string{s.String()}, Value: float64(counts[s])})
+		}
+		return samples
+	}, "state")
+	registry.CounterFunc("scraping_node_results_total", "Results received from each node", func() []metrics.Sample {
+		samples := make([]metrics.Sample, 0)
+		for _, info := range state.registry.Nodes() {
+			samples = append(samples, metrics.Sample{Labels: []string{info.Node.URL}, Value: float64(info.Results)})
+		}
+		return samples
+	}, "node")
+	registry.CounterFunc("scraping_dns_lookups_total", "Host names checked before dispatch, by outcome", func() []metrics.Sample {
+		samples := make([]metrics.Sample, 0)
+		if state.preflight != nil {
+			for outcome, count := range state.preflight.Lookups() {
+				samples = append(samples, metrics.Sample{Labels: []string{outcome}, Value: float64(count)})
+			}
+		}
+		return samples
+	}, "outcome")
+	registry.CounterFunc("scraping_robots_fetches_total", "Robots.txt files looked up before following links, by outcome", func() []metrics.Sample {
+		samples := make([]metrics.Sample, 0)
+		for outcome, count := range state.robots.Fetches() {
+			samples = append(samples, metrics.Sample{Labels: []string{outcome}, Value: float64(count)})
+		}
+		return samples
+	}, "outcome")
+	registry.GaugeFunc("scraping_domains_in_flight", "Registrable domains with requests in batches that have not completed", func() []metrics.Sample {
+		if state.politeness == nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(state.politeness.Domains())}}
+	})
+	registry.GaugeFunc("scraping_dispatch_paused", "Whether the dispatch of batches has been paused", func() []metrics.Sample {
+		paused := 0.0
+		if Paused() {
+			paused = 1
+		}
+		return []metrics.Sample{{Value: paused}}
+	})
+	state.batchDurations = registry.Histogram("scraping_batch_duration_seconds", "Time between the dispatch of a batch and the reception of its results", batchDurationBuckets, "job")
+	http.Handle("/metrics", registry)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"scraping"
+)
+
+// The state of a node, as seen by the coordinator
+type NodeState int
+
+const (
+	NodeReady NodeState = iota // Waiting for a batch
+	NodeBusy // Performing a batch
+	NodeDraining // Finishing its current batch, will not receive new ones
+	NodeDead // Did not send a heartbeat in time
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NodeReady:
+		return "ready"
+	case NodeBusy:
+		return "busy"
+	case NodeDraining:
+		return "draining"
+	case NodeDead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// What the coordinator knows about a node
+type NodeInfo struct {
+	Node scraping.Node
+	State NodeState
+	FirstSeen time.Time
+	LastSeen time.Time // Last time the node sent a heartbeat or was ready
+	LastHeartbeat scraping.Heartbeat
+	BatchesDone int
+	Results int // Number of results received from the node
+	Config *scraping.NodeConfig // The settings last reported by the node, nil if unknown
+}
+
+// The registry of all nodes that have been seen by the coordinator
+type Registry struct {
+	lock sync.Mutex
+	nodes map[string]*NodeInfo
+}
+
+func NewRegistry() *Registry {
+	return &Registry{nodes: make(map[string]*NodeInfo)}
+}
+
+// Get the information about a node, registering it if needed. Must be called with the lock held.
+func (r *Registry) get(node scraping.Node) *NodeInfo {
+	info, present := r.nodes[node.URL]
+	if !present {
+		now := time.Now()
+		info = &NodeInfo{Node: node, State: NodeReady, FirstSeen: now, LastSeen: now}
+		r.nodes[node.URL] = info
+	}
+	return info
+}
+
+// Record that a node is ready to receive a batch, along with its settings if it reported them.
+// Returns false if the node should not receive new batches.
+func (r *Registry) Ready(node scraping.Node, config *scraping.NodeConfig) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	info := r.get(node)
+	info.LastSeen = time.Now()
+	if config != nil && (info.Config == nil || !reflect.DeepEqual(*info.Config, *config)) {
+		log.Printf("Node %s uses settings %+v", node.URL, *config)
+		info.Config = config
+	}
+	if info.State == NodeDraining {
+		return false
+	}
+	if info.State == NodeBusy {
+		info.BatchesDone += 1
+	}
+	info.State = NodeReady
+	return true
+}
+
+// Record that a batch is being sent to a node. Returns false if the node cannot receive it.
+func (r *Registry) Busy(node scraping.Node) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	info := r.get(node)
+	if info.State != NodeReady {
+		return false
+	}
+	info.State = NodeBusy
+	return true
+}
+
+// The settings last reported by a node, nil if unknown
+func (r *Registry) Config(node scraping.Node) *scraping.NodeConfig {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.get(node).Config
+}
+
+// Record a heartbeat received from a node. Returns true if the node came back
+// while idle: it will not tell that it is ready, as it only does so after a
+// batch, so it must be given a batch again (see MarkReady).
+func (r *Registry) Heartbeat(heartbeat scraping.Heartbeat) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	info := r.get(heartbeat.Node)
+	info.LastSeen = time.Now()
+	info.LastHeartbeat = heartbeat
+	if heartbeat.Draining {
+		info.State = NodeDraining
+	} else if info.State == NodeDead {
+		if heartbeat.BatchSize > 0 {
+			// The node came back, it will tell when it is ready
+			info.State = NodeBusy
+		} else {
+			info.State = NodeReady
+			return true
+		}
+	}
+	return false
+}
+
+// Record that results have been received from a node
+func (r *Registry) Received(node scraping.Node, results int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(node).Results += results
+}
+
+// Stop sending new batches to a node. Returns false if the node is unknown.
+func (r *Registry) Drain(node scraping.Node) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	info, present := r.nodes[node.URL]
+	if !present {
+		return false
+	}
+	if info.State != NodeDead {
+		info.State = NodeDraining
+	}
+	return true
+}
+
+// Record that a node could not be reached
+func (r *Registry) Lost(node scraping.Node) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(node).State = NodeDead
+}
+
+// Mark as dead the nodes that have not been seen since the given timeout, and return them
+func (r *Registry) MarkDead(timeout time.Duration) []scraping.Node {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	dead := make([]scraping.Node, 0)
+	for _, info := range r.nodes {
+		if info.State != NodeDead && time.Now().Sub(info.LastSeen) > timeout {
+			info.State = NodeDead
+			dead = append(dead, info.Node)
+		}
+	}
+	return dead
+}
+
+// A copy of the information about every node, sorted by URL
+func (r *Registry) Nodes() []NodeInfo {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	nodes := make([]NodeInfo, 0, len(r.nodes))
+	for _, info := range r.nodes {
+		nodes = append(nodes, *info)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node.URL < nodes[j].Node.URL })
+	return nodes
+}
+
+// Frequently check for nodes that stopped sending heartbeats, and reschedule their batches
+func MonitorNodes() {
+	for {
+		<-time.After(state.config.heartbeatTimeout / 4)
+		dead := state.registry.MarkDead(state.config.heartbeatTimeout)
+		for _, node := range dead {
+			log.Printf("Node %s did not send a heartbeat for %s, considering it dead", node.URL, state.config.heartbeatTimeout.String())
+		}
+		if len(dead) > 0 {
+			for _, batch := range state.jobs.Expire(time.Now(), dead) {
+				log.Printf("Batch %d of dead node %s has been rescheduled", batch.ID, batch.Node.URL)
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+	"scraping"
+)
+
+func TestHeartbeatRevivesNode(t *testing.T) {
+	r := NewRegistry()
+	idle := scraping.Node{URL: "127.0.0.1:7001"}
+	busy := scraping.Node{URL: "127.0.0.1:7002"}
+	r.Ready(idle, nil)
+	r.Ready(busy, nil)
+	r.Busy(busy)
+	time.Sleep(time.Millisecond)
+	if dead := r.MarkDead(0); len(dead) != 2 {
+		t.Fatalf("%d nodes marked dead, expected 2", len(dead))
+	}
+	// An idle node does not tell when it is ready, it must be given a batch again
+	if !r.Heartbeat(scraping.Heartbeat{Node: idle}) {
+		t.Errorf("idle node not revived")
+	}
+	if !r.Busy(idle) {
+		t.Errorf("revived node cannot receive a batch")
+	}
+	// A node that is still performing a batch will tell when it is ready
+	if r.Heartbeat(scraping.Heartbeat{Node: busy, BatchSize: 10}) {
+		t.Errorf("busy node revived as ready")
+	}
+	// Heartbeats of live nodes do not change their state
+	if r.Heartbeat(scraping.Heartbeat{Node: idle}) || r.Heartbeat(scraping.Heartbeat{Node: busy}) {
+		t.Errorf("live node revived")
+	}
+	if r.Ready(busy, nil); r.Nodes()[1].BatchesDone != 1 {
+		t.Errorf("batch of the revived node not counted")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Limits the load put on each site: requests to the same registrable domain
+// (e.g., example.co.uk for www.example.co.uk) are dispatched at least delay
+// apart, and at most maxInFlight of them are in batches that have not
+// completed yet, across all nodes
+type Politeness struct {
+	delay time.Duration // 0 for no delay
+	maxInFlight int // 0 for no limit
+	lock sync.Mutex
+	last map[string]time.Time // When the last request to each domain has been dispatched
+	inFlight map[string]int // Number of requests to each domain in batches that have not completed
+	cleaned time.Time // When the domains whose delay has passed were last forgotten
+}
+
+func NewPoliteness(delay time.Duration, maxInFlight int) *Politeness {
+	return &Politeness{delay: delay, maxInFlight: maxInFlight, last: make(map[string]time.Time), inFlight: make(map[string]int)}
+}
+
+// The registrable domain of the host of an URL, or the host itself if it has
+// none (e.g., an IP address)
+func DomainOf(link string) string {
+	host := strings.ToLower(strings.TrimSuffix(HostOf(link), "."))
+	if host == "" || net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// Decide whether a request can be dispatched now, in which case it is counted
+// as in flight until it is released
+func (p *Politeness) Acquire(request QueuedRequest) bool {
+	domain := DomainOf(request.Request.URL)
+	now := time.Now()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.maxInFlight > 0 && p.inFlight[domain] >= p.maxInFlight {
+		return false
+	}
+	if last, present := p.last[domain]; present && now.Sub(last) < p.delay {
+		return false
+	}
+	p.inFlight[domain] += 1
+	p.last[domain] = now
+	return true
+}
+
+// Release requests that are not in flight anymore, because their batch completed or expired
+func (p *Politeness) Release(requests []QueuedRequest) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, request := range requests {
+		domain := DomainOf(request.Request.URL)
+		if p.inFlight[domain] -= 1; p.inFlight[domain] <= 0 {
+			delete(p.inFlight, domain)
+		}
+	}
+	// Forget the domains whose delay has passed from time to time, so that the map does not grow with every domain scraped
+	now := time.Now()
+	if now.Sub(p.cleaned) < 1 * time.Minute {
+		return
+	}
+	p.cleaned = now
+	for domain, last := range p.last {
+		if now.Sub(last) >= p.delay && p.inFlight[domain] == 0 {
+			delete(p.last, domain)
+		}
+	}
+}
+
+// Number of domains with requests in flight
+func (p *Politeness) Domains() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.inFlight)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"scraping"
+)
+
+// A result along with the context in which it has been obtained
+type ResultRecord struct {
+	scraping.Result
+	TopLevel bool
+	Parent string // The page on which the URL has been found, empty for top level URLs
+	Depth int // Number of links followed from a top level page to reach the URL
+	Node string // The node that performed the request
+	Config *scraping.NodeConfig `json:",omitempty"` // The settings of the node, if it reported them
+	Attempt int // 1 for the first time the request is performed
+	Retried bool // Whether the request has been queued again after this result
+	Dispatched time.Time // When the batch containing the request was sent to the node
+	Received time.Time // When the result was received by the coordinator
+}
+
+// A place where results are stored
+type ResultSink interface {
+	Store(record ResultRecord) error
+	Close() error
+}
+
+// Open the sinks listed in spec, separated by commas, storing their data in the given directory.
+// Supported sinks are jsonl, sqlite and logs.
+func OpenSinks(spec string, dir string) (ResultSink, error) {
+	sinks := make(MultiSink, 0)
+	for _, name := range strings.Split(spec, ",") {
+		var sink ResultSink
+		var err error
+		switch strings.TrimSpace(name) {
+		case "jsonl":
+			sink, err = NewJSONLSink(filepath.Join(dir, "results.jsonl"))
+		case "sqlite":
+			sink, err = NewSQLiteSink(filepath.Join(dir, "results.db"))
+		case "logs":
+			sink = LogSink{dir}
+		case "":
+			continue
+		default:
+			err = fmt.Errorf("unknown result sink %q", name)
+		}
+		if err != nil {
+			sinks.Close()
+			return nil, fmt.Errorf("cannot open result sink %s: %v", name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// Stores results in multiple sinks
+type MultiSink []ResultSink
+
+func (sinks MultiSink) Store(record ResultRecord) error {
+	for _, sink := range sinks {
+		if err := sink.Store(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sinks MultiSink) Close() error {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stores results as one JSON object per line
+type JSONLSink struct {
+	lock sync.Mutex
+	file *os.File
+	writer *bufio.Writer
+	encoder *json.Encoder
+}
+
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(f)
+	return &JSONLSink{file: f, writer: writer, encoder: json.NewEncoder(writer)}, nil
+}
+
+func (s *JSONLSink) Store(record ResultRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.encoder.Encode(record); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+func (s *JSONLSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+// Stores results in the .log files expected by the processing scripts, one URL per line
+type LogSink struct {
+	dir string
+}
+
+func (s LogSink) Store(record ResultRecord) error {
+	result := record.Result
+	if result.Timeout {
+		return AddLine(filepath.Join(s.dir, "timeouts.log"), result.URL)
+	} else if result.DNSError {
+		return AddLine(filepath.Join(s.dir, "dnserrors.log"), result.URL)
+	} else if result.Failure {
+		return AddLine(filepath.Join(s.dir, "failures.log"), result.URL)
+	} else if (len(result.Scripts) == 0) {
+		return AddLine(filepath.Join(s.dir, "noscripts.log"), result.URL)
+	}
+	return AddLine(filepath.Join(s.dir, "scripts.log"), fmt.Sprintf("%s %v", result.URL, result.Scripts))
+}
+
+func (s LogSink) Close() error {
+	return nil
+}
+
+// Add a line to a given file
+func AddLine(file string, line string) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
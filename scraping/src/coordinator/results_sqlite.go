@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS results (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	timeout BOOLEAN NOT NULL,
+	dns_error BOOLEAN NOT NULL,
+	failure BOOLEAN NOT NULL,
+	scripts TEXT NOT NULL, -- JSON array of the URLs of the WebAssembly scripts
+	urls TEXT NOT NULL, -- JSON array of the links followed from the page
+	modules TEXT NOT NULL, -- JSON array of the modules (url, hash and size) found on the page
+	top_level BOOLEAN NOT NULL,
+	parent TEXT NOT NULL,
+	node TEXT NOT NULL,
+	attempt INTEGER NOT NULL,
+	dispatched TIMESTAMP NOT NULL,
+	received TIMESTAMP NOT NULL,
+	config TEXT NOT NULL, -- JSON object of the settings of the node, empty if unknown
+	job TEXT NOT NULL,
+	error TEXT NOT NULL, -- Error code, empty if the page has been scraped
+	error_detail TEXT NOT NULL,
+	http_status INTEGER NOT NULL, -- Status code of the main document, 0 if unknown
+	retried BOOLEAN NOT NULL, -- Whether the request has been queued again after this result
+	depth INTEGER NOT NULL, -- Number of links followed from a top level page to reach the URL
+	link_seed INTEGER NOT NULL -- Seed with which the links have been selected at random, 0 if they were not
+);
+CREATE INDEX IF NOT EXISTS results_url ON results(url);
+CREATE INDEX IF NOT EXISTS results_error ON results(error);
+`
+
+// Stores results in an SQLite database, in the results table
+type SQLiteSink struct {
+	lock sync.Mutex
+	db *sql.DB
+	insert *sql.Stmt
+}
+
+func NewSQLiteSink(path string) (*SQLiteSink, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	insert, err := db.Prepare(`INSERT INTO results
+		(url, timeout, dns_error, failure, scripts, urls, modules, top_level, parent, node, attempt, dispatched, received, config, job, error, error_detail, http_status, retried, depth, link_seed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteSink{db: db, insert: insert}, nil
+}
+
+func (s *SQLiteSink) Store(record ResultRecord) error {
+	scripts, err := json.Marshal(record.Scripts)
+	if err != nil {
+		return err
+	}
+	urls, err := json.Marshal(record.URLs)
+	if err != nil {
+		return err
+	}
+	modules, err := json.Marshal(record.Modules)
+	if err != nil {
+		return err
+	}
+	config := ""
+	if record.Config != nil {
+		encoded, err := json.Marshal(record.Config)
+		if err != nil {
+			return err
+		}
+		config = string(encoded)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.insert.Exec(record.URL, record.Timeout, record.DNSError, record.Failure, string(scripts), string(urls), string(modules),
+		record.TopLevel, record.Parent, record.Node, record.Attempt, record.Dispatched, record.Received, config, record.Job,
+		string(record.Error), record.ErrorDetail, record.HTTPStatus, record.Retried, record.Depth, record.LinkSeed)
+	return err
+}
+
+func (s *SQLiteSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.insert.Close()
+	return s.db.Close()
+}
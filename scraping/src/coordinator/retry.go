@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"github.com/BurntSushi/toml"
+	"scraping"
+)
+
+// How requests are retried after an error with a given code
+type CodeRetryPolicy struct {
+	MaxAttempts int `toml:"max_attempts"`
+	Backoff time.Duration `toml:"backoff"`
+}
+
+// Which failed requests are retried, and when
+type RetryPolicy struct {
+	MaxAttempts int `toml:"max_attempts"` // Including the first attempt, 1 to never retry
+	Backoff time.Duration `toml:"backoff"` // Delay before the second attempt
+	Multiplier float64 `toml:"multiplier"` // Factor applied to the delay for each following attempt
+	MaxBackoff time.Duration `toml:"max_backoff"`
+	Retryable []scraping.ErrorCode `toml:"retryable"` // The error codes for which requests are retried
+	Codes map[scraping.ErrorCode]CodeRetryPolicy `toml:"code"` // Overrides of the attempts and delay for some codes, which are then retryable
+}
+
+// The policy used when none is given: transient errors are retried, while
+// errors that would happen again (e.g., a host name that does not resolve) are not
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		Backoff: 10 * time.Minute,
+		Multiplier: 2,
+		MaxBackoff: 2 * time.Hour,
+		Retryable: append([]scraping.ErrorCode{}, scraping.TransientErrorCodes...),
+		Codes: make(map[scraping.ErrorCode]CodeRetryPolicy),
+	}
+}
+
+// Load the retry policy from a TOML file, the settings that are not given taking their default value
+func LoadRetryPolicy(path string) (RetryPolicy, error) {
+	policy := DefaultRetryPolicy()
+	metadata, err := toml.DecodeFile(path, &policy)
+	if err != nil {
+		return policy, err
+	}
+	if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+		return policy, fmt.Errorf("unknown setting %s", undecoded[0].String())
+	}
+	// Settings of a code that are not given take the value of the policy
+	for code, override := range policy.Codes {
+		if !metadata.IsDefined("code", string(code), "max_attempts") {
+			override.MaxAttempts = policy.MaxAttempts
+		}
+		if !metadata.IsDefined("code", string(code), "backoff") {
+			override.Backoff = policy.Backoff
+		}
+		policy.Codes[code] = override
+	}
+	return policy, ValidateRetryPolicy(policy)
+}
+
+func knownErrorCode(code scraping.ErrorCode) bool {
+	for _, known := range scraping.ErrorCodes {
+		if code == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that the retry policy makes sense
+func ValidateRetryPolicy(policy RetryPolicy) error {
+	if policy.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1, got %d", policy.MaxAttempts)
+	}
+	if policy.Backoff < 0 || policy.MaxBackoff < 0 {
+		return fmt.Errorf("backoff and max_backoff cannot be negative")
+	}
+	if policy.Multiplier < 1 {
+		return fmt.Errorf("multiplier must be at least 1, got %v", policy.Multiplier)
+	}
+	for _, code := range policy.Retryable {
+		if !knownErrorCode(code) {
+			return fmt.Errorf("unknown error code %s in retryable", code)
+		}
+	}
+	for code, override := range policy.Codes {
+		if !knownErrorCode(code) {
+			return fmt.Errorf("unknown error code %s", code)
+		}
+		if override.MaxAttempts < 1 {
+			return fmt.Errorf("max_attempts of %s must be at least 1, got %d", code, override.MaxAttempts)
+		}
+		if override.Backoff < 0 {
+			return fmt.Errorf("backoff of %s cannot be negative", code)
+		}
+	}
+	return nil
+}
+
+// Whether a request whose given attempt failed with the given code should be
+// retried, and after how long
+func (policy RetryPolicy) Retry(code scraping.ErrorCode, attempt int) (time.Duration, bool) {
+	override, overridden := policy.Codes[code]
+	retryable := overridden
+	for _, c := range policy.Retryable {
+		if c == code {
+			retryable = true
+		}
+	}
+	if !retryable {
+		return 0, false
+	}
+	maxAttempts, backoff := policy.MaxAttempts, policy.Backoff
+	if overridden {
+		maxAttempts, backoff = override.MaxAttempts, override.Backoff
+	}
+	if attempt >= maxAttempts {
+		return 0, false
+	}
+	delay := time.Duration(float64(backoff) * math.Pow(policy.Multiplier, float64(attempt - 1)))
+	if delay > policy.MaxBackoff || delay < 0 {
+		delay = policy.MaxBackoff
+	}
+	return delay, true
+}
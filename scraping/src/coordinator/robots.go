@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How a job handles links disallowed by robots.txt
+const (
+	ROBOTS_IGNORE = "ignore" // Links are followed without looking at robots.txt
+	ROBOTS_RESPECT = "respect" // Disallowed links are not followed, and recorded
+	ROBOTS_RECORD = "record" // Disallowed links are followed, and recorded
+)
+
+var ROBOTS_MODES = []string{ROBOTS_IGNORE, ROBOTS_RESPECT, ROBOTS_RECORD}
+
+func ValidRobotsMode(mode string) bool {
+	for _, valid := range ROBOTS_MODES {
+		if mode == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// The file of a job in which disallowed links are recorded
+const ROBOTS_FILE = "robots.jsonl"
+
+// How long fetching a robots.txt file can take
+const ROBOTS_FETCH_TIMEOUT = 10 * time.Second
+
+// Robots.txt files are only read up to this size, as crawlers are allowed to do (RFC 9309)
+const MAX_ROBOTS_SIZE = 512 * 1024
+
+// How long a robots.txt file that could not be fetched is considered to disallow everything
+const ROBOTS_FAILURE_TTL = 10 * time.Minute
+
+// A rule of a robots.txt file
+type robotsRule struct {
+	allow bool
+	pattern string // As written in the file, used as the reason of decisions
+	match *regexp.Regexp
+}
+
+// The rules of a site that apply to the coordinator
+type robotsFile struct {
+	rules []robotsRule
+	disallowAll string // If not empty, everything is disallowed for this reason (e.g., the file could not be fetched)
+	expires time.Time
+}
+
+// A link disallowed by robots.txt, as recorded in the robots.jsonl file of its job
+type RobotsDecision struct {
+	URL string
+	Parent string // The page on which the link was found
+	Mode string // The robots.txt setting of the job
+	Skipped bool // Whether the link has been skipped, or only recorded
+	Reason string
+	Time time.Time
+}
+
+// Fetches and caches the robots.txt files of the sites linked to, keyed by
+// scheme and host, and decides which links can be followed. The rules that
+// apply are those of the groups of the given user-agent token, or those for
+// all crawlers (*) if there are none.
+type RobotsCache struct {
+	agent string
+	ttl time.Duration // How long the files are cached
+	client *http.Client
+	lock sync.Mutex // Protects cache, fetching, fetches and cleaned
+	cache map[string]*robotsFile // By origin (e.g., https://example.com)
+	fetching map[string]chan bool // Closed once the file of the origin has been fetched
+	fetches map[string]int // Number of robots.txt files looked up, by outcome ("cached" for files taken from the cache)
+	cleaned time.Time // When expired files were last forgotten
+	recordLock sync.Mutex // Protects records
+	records map[string]*os.File // The robots.jsonl file of each job
+}
+
+func NewRobotsCache(agent string, ttl time.Duration) *RobotsCache {
+	return &RobotsCache{
+		agent: agent,
+		ttl: ttl,
+		client: &http.Client{Timeout: ROBOTS_FETCH_TIMEOUT},
+		cache: make(map[string]*robotsFile),
+		fetching: make(map[string]chan bool),
+		fetches: make(map[string]int),
+		records: make(map[string]*os.File),
+	}
+}
+
+// Parse the content of a robots.txt file, keeping the rules of the groups
+// that apply to the given user-agent token
+func ParseRobots(content string, agent string) []robotsRule {
+	agent = strings.ToLower(agent)
+	specific := make([]robotsRule, 0)
+	generic := make([]robotsRule, 0)
+	matched := false // Whether a group for the agent has been found
+	var agents []string // The user agents of the current group
+	inRules := false // Whether the rules of the current group have started
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				// A new group starts
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				// An empty disallow allows everything, which is the default
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value, match: compileRobotsPattern(value)}
+			for _, a := range agents {
+				if a == agent && agent != "*" {
+					specific = append(specific, rule)
+					matched = true
+				} else if a == "*" {
+					generic = append(generic, rule)
+				}
+			}
+		}
+	}
+	if matched {
+		return specific
+	}
+	return generic
+}
+
+// Compile the path pattern of a rule, in which * matches any sequence of characters and a final $ the end of the URL
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expression := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expression += "$"
+	}
+	return regexp.MustCompile(expression)
+}
+
+// Whether a path (with its query) is allowed by the rules: the longest
+// matching rule applies, allow rules winning ties. Returns the rule that
+// disallows the path if it is not allowed.
+func (f *robotsFile) Allowed(path string) (bool, string) {
+	if f.disallowAll != "" {
+		return false, f.disallowAll
+	}
+	if path == "/robots.txt" {
+		return true, ""
+	}
+	var best *robotsRule
+	for i, rule := range f.rules {
+		if !rule.match.MatchString(path) {
+			continue
+		}
+		if best == nil || len(rule.pattern) > len(best.pattern) || (len(rule.pattern) == len(best.pattern) && rule.allow) {
+			best = &f.rules[i]
+		}
+	}
+	if best == nil || best.allow {
+		return true, ""
+	}
+	return false, "Disallow: " + best.pattern
+}
+
+// Fetch the robots.txt file of an origin. Following RFC 9309, a file that does
+// not exist (4xx) allows everything, and a file that cannot be fetched (5xx,
+// network error) disallows everything.
+func (c *RobotsCache) fetch(origin string) (*robotsFile, string) {
+	now := time.Now()
+	failed := func(reason string) (*robotsFile, string) {
+		return &robotsFile{disallowAll: reason, expires: now.Add(min(c.ttl, ROBOTS_FAILURE_TTL))}, "unavailable"
+	}
+	request, err := http.NewRequest("GET", origin + "/robots.txt", nil)
+	if err != nil {
+		return failed(fmt.Sprintf("robots.txt cannot be requested: %v", err))
+	}
+	if c.agent != "*" {
+		request.Header.Set("User-Agent", c.agent)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return failed(fmt.Sprintf("robots.txt unreachable: %v", err))
+	}
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode >= 500:
+		return failed(fmt.Sprintf("robots.txt unavailable: %s", response.Status))
+	case response.StatusCode >= 400:
+		return &robotsFile{expires: now.Add(c.ttl)}, "missing"
+	case response.StatusCode >= 300:
+		// More redirects than the client follows
+		return failed(fmt.Sprintf("robots.txt unavailable: %s", response.Status))
+	}
+	content, err := io.ReadAll(io.LimitReader(response.Body, MAX_ROBOTS_SIZE))
+	if err != nil {
+		return failed(fmt.Sprintf("robots.txt unreachable: %v", err))
+	}
+	return &robotsFile{rules: ParseRobots(string(content), c.agent), expires: now.Add(c.ttl)}, "fetched"
+}
+
+// The robots.txt file of an origin, from the cache if possible. Concurrent
+// lookups of the same origin wait for a single fetch.
+func (c *RobotsCache) get(origin string) *robotsFile {
+	c.lock.Lock()
+	for {
+		now := time.Now()
+		if now.Sub(c.cleaned) >= 1 * time.Minute {
+			// Forget expired files from time to time, so that the cache does not grow with every site linked to
+			c.cleaned = now
+			for other, file := range c.cache {
+				if !now.Before(file.expires) {
+					delete(c.cache, other)
+				}
+			}
+		}
+		if file, present := c.cache[origin]; present && now.Before(file.expires) {
+			c.fetches["cached"] += 1
+			c.lock.Unlock()
+			return file
+		}
+		done, fetching := c.fetching[origin]
+		if !fetching {
+			break
+		}
+		c.lock.Unlock()
+		<-done
+		c.lock.Lock()
+	}
+	done := make(chan bool)
+	c.fetching[origin] = done
+	c.lock.Unlock()
+	file, outcome := c.fetch(origin)
+	c.lock.Lock()
+	c.cache[origin] = file
+	c.fetches[outcome] += 1
+	delete(c.fetching, origin)
+	close(done)
+	c.lock.Unlock()
+	return file
+}
+
+// Whether robots.txt allows to request an URL, and the reason if it does not.
+// URLs that cannot be parsed are allowed, the node will report the error.
+func (c *RobotsCache) Allowed(link string) (bool, string) {
+	if !strings.Contains(link, "://") {
+		// Chrome navigates to URLs without scheme over http
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return true, ""
+	}
+	origin := u.Scheme + "://" + u.Host
+	allowed, reason := c.get(origin).Allowed(u.RequestURI())
+	if !allowed {
+		reason = fmt.Sprintf("%s (%s/robots.txt)", reason, origin)
+	}
+	return allowed, reason
+}
+
+// Number of robots.txt files looked up so far, by outcome
+func (c *RobotsCache) Fetches() map[string]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fetches := make(map[string]int, len(c.fetches))
+	for outcome, count := range c.fetches {
+		fetches[outcome] = count
+	}
+	return fetches
+}
+
+// Append a decision to the robots.jsonl file of a job
+func (c *RobotsCache) Record(job *Job, decision RobotsDecision) {
+	c.recordLock.Lock()
+	defer c.recordLock.Unlock()
+	f, present := c.records[job.dir]
+	if !present {
+		var err error
+		path := filepath.Join(job.dir, ROBOTS_FILE)
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			log.Fatalf("Cannot open %s: %v", path, err)
+		}
+		c.records[job.dir] = f
+	}
+	writer := bufio.NewWriter(f)
+	if err := json.NewEncoder(writer).Encode(decision); err != nil {
+		log.Fatalf("Cannot record robots.txt decision of job %s: %v", job.Name, err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatalf("Cannot record robots.txt decision of job %s: %v", job.Name, err)
+	}
+}
+
+// Check a link of a job against robots.txt. Disallowed links are recorded,
+// and only become available for dispatch if the job does not respect robots.txt.
+func (c *RobotsCache) Check(job *Job, request QueuedRequest) {
+	mode := job.Robots()
+	if mode == ROBOTS_IGNORE {
+		job.queue.Checked(request)
+		return
+	}
+	allowed, reason := c.Allowed(request.Request.URL)
+	if allowed {
+		job.queue.Checked(request)
+		return
+	}
+	skipped := mode == ROBOTS_RESPECT
+	c.Record(job, RobotsDecision{URL: request.Request.URL, Parent: request.Parent, Mode: mode, Skipped: skipped, Reason: reason, Time: time.Now()})
+	if skipped {
+		job.queue.Skip(request)
+	} else {
+		job.queue.Checked(request)
+	}
+}
+
+// Close the robots.jsonl files of the jobs
+func (c *RobotsCache) Close() {
+	c.recordLock.Lock()
+	defer c.recordLock.Unlock()
+	for dir, f := range c.records {
+		if err := f.Close(); err != nil {
+			log.Printf("Cannot close %s: %v", filepath.Join(dir, ROBOTS_FILE), err)
+		}
+	}
+}
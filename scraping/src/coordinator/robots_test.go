@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const robotsExample = `# Rules of example.com
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.php$
+Disallow: /search
+
+User-agent: WasmBot
+User-agent: OtherBot
+Disallow: /
+Allow: /games/ # Only the games
+
+user-agent: strictbot
+disallow: /
+`
+
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		name string
+		content string
+		agent string
+		path string
+		allowed bool
+		reason string // Rule that disallows the path
+	}{
+		{"no rule", robotsExample, "*", "/index.html", true, ""},
+		{"disallowed prefix", robotsExample, "*", "/private/data", false, "Disallow: /private/"},
+		{"longest match wins", robotsExample, "*", "/private/public/page", true, ""},
+		{"prefix of the longest rule", robotsExample, "*", "/private/publi", false, "Disallow: /private/"},
+		{"prefix with query", robotsExample, "*", "/search?q=wasm", false, "Disallow: /search"},
+		{"prefix not at the start", robotsExample, "*", "/a/search", true, ""},
+		{"wildcard and end", robotsExample, "*", "/dir/page.php", false, "Disallow: /*.php$"},
+		{"end not matched", robotsExample, "*", "/dir/page.php?id=1", true, ""},
+		{"end not matched by directory", robotsExample, "*", "/page.php/", true, ""},
+		{"group of the agent", robotsExample, "WasmBot", "/index.html", false, "Disallow: /"},
+		{"group of the agent allowing", robotsExample, "WasmBot", "/games/tetris", true, ""},
+		{"group with several agents", robotsExample, "otherbot", "/games/", true, ""},
+		{"generic rules not applied to the agent", robotsExample, "WasmBot", "/games/page.php", true, ""},
+		{"case-insensitive agent and keys", robotsExample, "StrictBot", "/games/", false, "Disallow: /"},
+		{"agent without group", robotsExample, "UnknownBot", "/private/data", false, "Disallow: /private/"},
+		{"robots.txt always allowed", robotsExample, "WasmBot", "/robots.txt", true, ""},
+		{"allow wins ties", "User-agent: *\nDisallow: /page\nAllow: /page\n", "*", "/page", true, ""},
+		{"allow wins ties in any order", "User-agent: *\nAllow: /page\nDisallow: /page\n", "*", "/page", true, ""},
+		{"longer pattern with wildcard", "User-agent: *\nDisallow: /*.js\nAllow: /a*.js\n", "*", "/a/b.js", true, ""},
+		{"longer disallow", "User-agent: *\nAllow: /a\nDisallow: /a/b\n", "*", "/a/b/c", false, "Disallow: /a/b"},
+		{"empty disallow", "User-agent: *\nDisallow:\n", "*", "/anything", true, ""},
+		{"several groups for the agent", "User-agent: a\nDisallow: /x\n\nUser-agent: b\nDisallow: /\n\nUser-agent: a\nDisallow: /y\n", "a", "/y/1", false, "Disallow: /y"},
+		{"special characters are literal", "User-agent: *\nDisallow: /a.b+(c)\n", "*", "/aXb+(c)", true, ""},
+		{"no file", "", "*", "/private/", true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := &robotsFile{rules: ParseRobots(test.content, test.agent)}
+			allowed, reason := file.Allowed(test.path)
+			if allowed != test.allowed || reason != test.reason {
+				t.Errorf("%s: allowed %t (%q), expected %t (%q)", test.path, allowed, reason, test.allowed, test.reason)
+			}
+		})
+	}
+}
+
+func TestRobotsFetch(t *testing.T) {
+	status := http.StatusOK
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		if r.URL.Path != "/robots.txt" {
+			t.Errorf("request for %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer server.Close()
+	tests := []struct {
+		status int
+		allowed bool
+		reason string
+	}{
+		{http.StatusOK, false, "Disallow: /private"},
+		{http.StatusNotFound, true, ""},
+		{http.StatusForbidden, true, ""},
+		{http.StatusServiceUnavailable, false, "robots.txt unavailable: 503 Service Unavailable"},
+		{http.StatusInternalServerError, false, "robots.txt unavailable: 500 Internal Server Error"},
+	}
+	for _, test := range tests {
+		status = test.status
+		c := NewRobotsCache("*", time.Hour)
+		allowed, reason := c.Allowed(server.URL + "/private/page")
+		if allowed != test.allowed || !strings.HasPrefix(reason, test.reason) {
+			t.Errorf("status %d: allowed %t (%q), expected %t (%q)", test.status, allowed, reason, test.allowed, test.reason)
+		}
+		// The file is cached
+		c.Allowed(server.URL + "/other")
+		if fetches := c.Fetches(); fetches["cached"] != 1 {
+			t.Errorf("status %d: fetches %v", test.status, fetches)
+		}
+	}
+	if requests != len(tests) {
+		t.Errorf("%d requests for %d files", requests, len(tests))
+	}
+
+	// A site that cannot be reached disallows everything
+	url := server.URL
+	server.Close()
+	if allowed, reason := NewRobotsCache("*", time.Hour).Allowed(url + "/"); allowed || !strings.HasPrefix(reason, "robots.txt unreachable") {
+		t.Errorf("unreachable site: allowed %t (%q)", allowed, reason)
+	}
+}
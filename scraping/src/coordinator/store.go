@@ -0,0 +1,854 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"scraping"
+)
+
+const JOURNAL_FILE = "queue.log"
+
+// An entry of the on-disk journal. The journal is an append-only log of
+// operations on the queue, that is replayed when the coordinator restarts.
+type JournalEntry struct {
+	Op string // One of "enqueue", "dispatch", "abort", "result", "complete", "expire", "cancel", "skip", "duplicates", "counters"
+	ID int `json:",omitempty"` // Identifier of the enqueued request
+	Request *scraping.Request `json:",omitempty"` // The enqueued request
+	New bool `json:",omitempty"` // Whether the request is a new URL to scrape (and not a rescheduled one)
+	Retry bool `json:",omitempty"` // Whether the request is retried after an error
+	NotBefore time.Time `json:",omitzero"` // When the request can be dispatched, zero if it can be dispatched right away
+	Attempt int `json:",omitempty"` // How many times the request will have been dispatched
+	Parent string `json:",omitempty"` // The page on which the URL of the request was found
+	Batch int `json:",omitempty"` // Identifier of the batch
+	IDs []int `json:",omitempty"` // Identifiers of the requests in the batch
+	Count int `json:",omitempty"` // Number of new URLs left out as duplicates
+	Stored *StoredResult `json:",omitempty"` // A result that has been stored
+	Counters *Counters `json:",omitempty"` // Snapshot of the counters, written upon compaction
+}
+
+// The counters of a job, persisted in the journal
+type Counters struct {
+	TotalURLsToRequest int
+	TotalScraped int
+	TotalTimeouts int
+	TotalDNSErrors int
+	TotalFailures int
+	TotalScripts int
+	BatchesDispatched int
+	ResultsReceived int
+	Retries int // Requests retried after an error
+	Skipped int // Requests not dispatched as robots.txt disallows them
+	Duplicates int // New URLs left out as the job had already queued them
+	Errors map[scraping.ErrorCode]int `json:",omitempty"` // Number of results for each error code
+}
+
+// What the journal keeps of a stored result: its key, and what the counters need
+type StoredResult struct {
+	Key string
+	Timeout bool `json:",omitempty"`
+	DNSError bool `json:",omitempty"`
+	Failure bool `json:",omitempty"`
+	Scripts bool `json:",omitempty"` // Whether WebAssembly scripts have been found
+	Error scraping.ErrorCode `json:",omitempty"`
+}
+
+func StoredResultOf(result scraping.Result) StoredResult {
+	return StoredResult{result.Key(), result.Timeout, result.DNSError, result.Failure, len(result.Scripts) > 0, result.Error}
+}
+
+// A request along with its identifier in the journal
+type QueuedRequest struct {
+	ID int
+	Request scraping.Request
+	Attempt int // 1 for the first time the request is dispatched
+	Parent string // The page on which the URL has been found, empty for top level URLs
+	NotBefore time.Time // When the request can be dispatched, zero if it can be dispatched right away
+}
+
+// The journal entry that enqueues a request
+func (r QueuedRequest) entry(new bool) JournalEntry {
+	return JournalEntry{Op: "enqueue", ID: r.ID, Request: &r.Request, New: new, Attempt: r.Attempt, Parent: r.Parent, NotBefore: r.NotBefore}
+}
+
+// A batch that has been dispatched to a node but for which no result has been received yet.
+// The node holds a lease on the batch until Deadline, after which the batch is given to another node.
+type InFlightBatch struct {
+	ID int
+	Node scraping.Node
+	Requests []QueuedRequest
+	Deadline time.Time
+	Dispatched time.Time
+	stored map[string]bool // Keys of the requests for which a result has been stored
+	progress int // Number of requests performed according to the last heartbeat that extended the lease
+}
+
+// Find the request of the batch with the given key (see scraping.Request.Key)
+func (b *InFlightBatch) Find(key string) (QueuedRequest, bool) {
+	for _, request := range b.Requests {
+		if request.Request.Key() == key {
+			return request, true
+		}
+	}
+	return QueuedRequest{}, false
+}
+
+// A batch whose lease has expired, and whose requests have been put back in the queue
+type ExpiredBatch struct {
+	ID int
+	Node scraping.Node
+	Dispatched time.Time
+	Requests []QueuedRequest // All requests of the batch
+	requests map[string]QueuedRequest // The original requests of the batch that have been requeued, by key
+	requeued map[string]int // Identifier of the requeued request, for the key of each request of the batch
+}
+
+// Add the counters of another job
+func (c *Counters) Add(other Counters) {
+	c.TotalURLsToRequest += other.TotalURLsToRequest
+	c.TotalScraped += other.TotalScraped
+	c.TotalTimeouts += other.TotalTimeouts
+	c.TotalDNSErrors += other.TotalDNSErrors
+	c.TotalFailures += other.TotalFailures
+	c.TotalScripts += other.TotalScripts
+	c.BatchesDispatched += other.BatchesDispatched
+	c.ResultsReceived += other.ResultsReceived
+	c.Retries += other.Retries
+	c.Skipped += other.Skipped
+	c.Duplicates += other.Duplicates
+	for code, count := range other.Errors {
+		c.CountError(code, count)
+	}
+}
+
+func (c *Counters) CountError(code scraping.ErrorCode, count int) {
+	if c.Errors == nil {
+		c.Errors = make(map[scraping.ErrorCode]int)
+	}
+	c.Errors[code] += count
+}
+
+// A copy of the counters, that does not share its error counts
+func (c Counters) Copy() Counters {
+	errors := c.Errors
+	c.Errors = nil
+	for code, count := range errors {
+		c.CountError(code, count)
+	}
+	return c
+}
+
+// Update the counters with the result of a query
+func (c *Counters) Count(result StoredResult) {
+	c.ResultsReceived += 1
+	c.TotalScraped += 1
+	if result.Timeout {
+		c.TotalTimeouts += 1
+	} else if result.DNSError {
+		c.TotalDNSErrors += 1
+	} else if result.Failure {
+		c.TotalFailures += 1
+	} else if result.Scripts {
+		c.TotalScripts += 1
+	}
+	if result.Error != scraping.ErrorNone {
+		c.CountError(result.Error, 1)
+	}
+}
+
+// The queue of requests to perform for a job, backed by the journal
+type Queue struct {
+	lock sync.Mutex
+	counters Counters
+	pending []QueuedRequest
+	blocked map[string][]QueuedRequest // Requests that have been set aside as their domain could not be dispatched to, by domain
+	blockedDomains []string // The domains of the requests set aside, by order of the first one set aside
+	delayed []QueuedRequest // Requests waiting until they can be dispatched, by order of NotBefore
+	check func(QueuedRequest) bool // Whether a new request must be checked before being dispatched, nil if none must
+	unchecked map[bool][]QueuedRequest // Requests that have not been checked yet, by whether they are top level
+	checking int // Requests being checked
+	inFlight map[int]*InFlightBatch
+	expired map[int]*ExpiredBatch
+	nextID int
+	nextBatch int
+	available chan bool // Signaled when a request is pushed
+	journal *os.File
+	writer *bufio.Writer
+	encoder *json.Encoder
+}
+
+// Open the queue stored in the given directory, replaying its journal if it exists.
+// New requests for which check returns true are only dispatched once they have
+// been checked (see CheckRequests).
+// Returns the queue and whether an existing journal has been found.
+func OpenQueue(dir string, check func(QueuedRequest) bool) (*Queue, bool) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Cannot create data directory %s: %v", dir, err)
+	}
+	q := &Queue{
+		pending: make([]QueuedRequest, 0),
+		blocked: make(map[string][]QueuedRequest),
+		blockedDomains: make([]string, 0),
+		delayed: make([]QueuedRequest, 0),
+		check: check,
+		unchecked: make(map[bool][]QueuedRequest),
+		inFlight: make(map[int]*InFlightBatch),
+		expired: make(map[int]*ExpiredBatch),
+		nextID: 1,
+		nextBatch: 1,
+		available: make(chan bool, 1),
+	}
+	path := filepath.Join(dir, JOURNAL_FILE)
+	resumed := q.replay(path)
+	q.compact(path)
+	return q, resumed
+}
+
+// Replay the journal at the given path, returns false if there is no journal
+func (q *Queue) replay(path string) bool {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		log.Fatalf("Cannot open journal %s: %v", path, err)
+	}
+	defer f.Close()
+	queued := make(map[int]QueuedRequest)
+	order := make([]int, 0)
+	stored := make(map[int]map[string]bool)
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	entries := 0
+	for {
+		var entry JournalEntry
+		if err := decoder.Decode(&entry); err != nil {
+			// A truncated last entry is expected if the coordinator crashed while writing it
+			break
+		}
+		entries++
+		switch entry.Op {
+		case "counters":
+			q.counters = *entry.Counters
+		case "enqueue":
+			queued[entry.ID] = QueuedRequest{entry.ID, *entry.Request, entry.Attempt, entry.Parent, entry.NotBefore}
+			order = append(order, entry.ID)
+			if entry.New {
+				q.counters.TotalURLsToRequest += 1
+			}
+			if entry.Retry {
+				q.counters.Retries += 1
+			}
+			if entry.ID >= q.nextID {
+				q.nextID = entry.ID + 1
+			}
+		case "dispatch":
+			batch := &InFlightBatch{ID: entry.Batch}
+			for _, id := range entry.IDs {
+				batch.Requests = append(batch.Requests, queued[id])
+				delete(queued, id)
+			}
+			q.inFlight[entry.Batch] = batch
+			stored[entry.Batch] = make(map[string]bool)
+			q.counters.BatchesDispatched += 1
+			if entry.Batch >= q.nextBatch {
+				q.nextBatch = entry.Batch + 1
+			}
+		case "abort":
+			// The batch could not be sent, its requests are back in the queue
+			if batch, present := q.inFlight[entry.Batch]; present {
+				for _, request := range batch.Requests {
+					queued[request.ID] = request
+					order = append(order, request.ID)
+				}
+			}
+			delete(q.inFlight, entry.Batch)
+			delete(stored, entry.Batch)
+		case "cancel":
+			delete(queued, entry.ID)
+		case "skip":
+			delete(queued, entry.ID)
+			q.counters.Skipped += 1
+		case "duplicates":
+			q.counters.Duplicates += entry.Count
+		case "result":
+			if stored[entry.Batch] != nil {
+				stored[entry.Batch][entry.Stored.Key] = true
+			}
+			q.counters.Count(*entry.Stored)
+		case "complete", "expire":
+			// The requests of an expired batch have been enqueued again in separate entries
+			delete(q.inFlight, entry.Batch)
+			delete(stored, entry.Batch)
+		}
+	}
+	// Batches that were in flight are lost: put back in the queue the requests for which no result has been stored
+	for batchID, batch := range q.inFlight {
+		for _, request := range batch.Requests {
+			if !stored[batchID][request.Request.Key()] {
+				queued[request.ID] = request
+				order = append(order, request.ID)
+			}
+		}
+		delete(q.inFlight, batchID)
+	}
+	for _, id := range order {
+		if request, present := queued[id]; present {
+			if !request.NotBefore.IsZero() {
+				q.delay(request)
+			} else if q.check != nil && request.Attempt == 1 && q.check(request) {
+				// Requests that were checked before the restart are checked again, the outcomes are not persisted
+				q.unchecked[request.Request.TopLevel] = append(q.unchecked[request.Request.TopLevel], request)
+			} else {
+				q.pending = append(q.pending, request)
+			}
+			delete(queued, id) // Avoid adding the same request twice
+		}
+	}
+	log.Printf("Replayed %d journal entries: %d requests pending, %d delayed, %d to check", entries, len(q.pending), len(q.delayed), len(q.unchecked[true]) + len(q.unchecked[false]))
+	return entries > 0
+}
+
+// Rewrite the journal so that it only contains the current state of the queue, and open it for appending
+func (q *Queue) compact(path string) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Fatalf("Cannot create journal %s: %v", tmp, err)
+	}
+	writer := bufio.NewWriter(f)
+	encoder := json.NewEncoder(writer)
+	counters := q.counters
+	if err := encoder.Encode(JournalEntry{Op: "counters", Counters: &counters}); err != nil {
+		log.Fatalf("Cannot write to journal %s: %v", tmp, err)
+	}
+	for _, requests := range [][]QueuedRequest{q.pending, q.delayed, q.unchecked[true], q.unchecked[false]} {
+		for i := range requests {
+			if err := encoder.Encode(requests[i].entry(false)); err != nil {
+				log.Fatalf("Cannot write to journal %s: %v", tmp, err)
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatalf("Cannot write to journal %s: %v", tmp, err)
+	}
+	if err := f.Sync(); err != nil {
+		log.Fatalf("Cannot write to journal %s: %v", tmp, err)
+	}
+	f.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		log.Fatalf("Cannot replace journal %s: %v", path, err)
+	}
+	q.journal, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Cannot open journal %s: %v", path, err)
+	}
+	q.writer = bufio.NewWriter(q.journal)
+	q.encoder = json.NewEncoder(q.writer)
+}
+
+// Append entries to the journal. Must be called with the lock held.
+func (q *Queue) write(entries ...JournalEntry) {
+	for _, entry := range entries {
+		if err := q.encoder.Encode(entry); err != nil {
+			log.Fatalf("Cannot write to journal: %v", err)
+		}
+	}
+	if err := q.writer.Flush(); err != nil {
+		log.Fatalf("Cannot write to journal: %v", err)
+	}
+}
+
+// Push requests at the end of the queue, assigning them an identifier.
+// New requests count towards the total of URLs to request, and first wait to
+// be checked if the queue requires it.
+func (q *Queue) Push(requests []QueuedRequest, new bool) {
+	if len(requests) == 0 {
+		return
+	}
+	q.lock.Lock()
+	if new {
+		q.counters.TotalURLsToRequest += len(requests)
+	}
+	entries := make([]JournalEntry, 0, len(requests))
+	for _, queued := range requests {
+		queued.ID = q.nextID
+		q.nextID += 1
+		if q.check != nil && new && q.check(queued) {
+			q.unchecked[queued.Request.TopLevel] = append(q.unchecked[queued.Request.TopLevel], queued)
+		} else {
+			q.pending = append(q.pending, queued)
+		}
+		entries = append(entries, queued.entry(new))
+	}
+	q.write(entries...)
+	q.lock.Unlock()
+	select {
+	case q.available <- true:
+	default:
+	}
+}
+
+// Record that new URLs have been left out as duplicates
+func (q *Queue) Duplicates(count int) {
+	if count == 0 {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.counters.Duplicates += count
+	q.write(JournalEntry{Op: "duplicates", Count: count})
+}
+
+// Queue a request again after an error, once the given time has passed
+func (q *Queue) Retry(request QueuedRequest, notBefore time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	request.ID = q.nextID
+	request.NotBefore = notBefore
+	q.nextID += 1
+	q.counters.Retries += 1
+	q.delay(request)
+	entry := request.entry(false)
+	entry.Retry = true
+	q.write(entry)
+}
+
+// Insert a request in the delayed requests. Must be called with the lock held.
+func (q *Queue) delay(request QueuedRequest) {
+	i := sort.Search(len(q.delayed), func(i int) bool { return q.delayed[i].NotBefore.After(request.NotBefore) })
+	q.delayed = append(q.delayed, QueuedRequest{})
+	copy(q.delayed[i+1:], q.delayed[i:])
+	q.delayed[i] = request
+}
+
+// Move the delayed requests that can now be dispatched to the end of the
+// queue. Must be called with the lock held.
+func (q *Queue) promote(now time.Time) {
+	due := 0
+	for due < len(q.delayed) && !q.delayed[due].NotBefore.After(now) {
+		due++
+	}
+	q.pending = append(q.pending, q.delayed[:due]...)
+	q.delayed = q.delayed[due:]
+}
+
+// Pop the first request of the queue accepted by accept (any request if
+// accept is nil), waiting at most the given duration for one to be available.
+// The requests that are not accepted, and the later requests to the same
+// registrable domain, are set aside until their domain accepts one again, so
+// that the requests to domains that cannot be dispatched to (e.g., because of
+// politeness) are not looked at again for each request popped.
+func (q *Queue) Pop(timeout time.Duration, accept func(QueuedRequest) bool) (QueuedRequest, bool) {
+	deadline := time.After(timeout)
+	for {
+		q.lock.Lock()
+		q.promote(time.Now())
+		request, found := q.popAccepted(accept)
+		more := len(q.pending) > 0 || len(q.blockedDomains) > 0
+		q.lock.Unlock()
+		if found {
+			if more {
+				// Let other waiters know that there are still requests
+				select {
+				case q.available <- true:
+				default:
+				}
+			}
+			return request, true
+		}
+		var retry <-chan time.Time
+		if more {
+			// The requests may be accepted later
+			retry = time.After(1 * time.Second)
+		}
+		select {
+		case <-q.available:
+		case <-retry:
+		case <-deadline:
+			return QueuedRequest{}, false
+		}
+	}
+}
+
+// Pop the first request accepted by accept, looking at the first request set
+// aside for each domain, then at the pending requests. Must be called with the lock held.
+func (q *Queue) popAccepted(accept func(QueuedRequest) bool) (QueuedRequest, bool) {
+	for i, domain := range q.blockedDomains {
+		request := q.blocked[domain][0]
+		if accept != nil && !accept(request) {
+			continue
+		}
+		if q.blocked[domain] = q.blocked[domain][1:]; len(q.blocked[domain]) == 0 {
+			delete(q.blocked, domain)
+			q.blockedDomains = append(q.blockedDomains[:i], q.blockedDomains[i+1:]...)
+		}
+		return request, true
+	}
+	for len(q.pending) > 0 {
+		request := q.pending[0]
+		q.pending = q.pending[1:]
+		domain := DomainOf(request.Request.URL)
+		// The requests to a domain that has requests set aside wait behind them
+		if _, present := q.blocked[domain]; !present && (accept == nil || accept(request)) {
+			return request, true
+		}
+		q.setAside(domain, request)
+	}
+	return QueuedRequest{}, false
+}
+
+// Set aside a request to a domain that cannot be dispatched to. Must be called with the lock held.
+func (q *Queue) setAside(domain string, request QueuedRequest) {
+	if _, present := q.blocked[domain]; !present {
+		q.blockedDomains = append(q.blockedDomains, domain)
+	}
+	q.blocked[domain] = append(q.blocked[domain], request)
+}
+
+// Take a top level request or a link that must be checked, returns false if
+// there is none. The request must then be given back with Checked, Answered or Skip.
+func (q *Queue) PopUnchecked(topLevel bool) (QueuedRequest, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.unchecked[topLevel]) == 0 {
+		return QueuedRequest{}, false
+	}
+	request := q.unchecked[topLevel][0]
+	q.unchecked[topLevel] = q.unchecked[topLevel][1:]
+	q.checking += 1
+	return request, true
+}
+
+// Make a request that has been checked available for dispatch
+func (q *Queue) Checked(request QueuedRequest) {
+	q.lock.Lock()
+	q.checking -= 1
+	q.pending = append(q.pending, request)
+	q.lock.Unlock()
+	select {
+	case q.available <- true:
+	default:
+	}
+}
+
+// Record that a checked request will not be dispatched as the coordinator
+// already knows its outcome (e.g., its host name does not exist), the given
+// result being stored in its place (see Queue.Stored)
+func (q *Queue) Answered(request QueuedRequest, result scraping.Result) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.checking -= 1
+	summary := StoredResultOf(result)
+	q.counters.Count(summary)
+	q.write(JournalEntry{Op: "cancel", ID: request.ID}, JournalEntry{Op: "result", Stored: &summary})
+}
+
+// Record that a checked request will not be dispatched as robots.txt disallows it
+func (q *Queue) Skip(request QueuedRequest) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.checking -= 1
+	q.counters.Skipped += 1
+	q.write(JournalEntry{Op: "skip", ID: request.ID})
+}
+
+// Record that a batch of popped requests has been dispatched to a node, returns the batch.
+// Batch identifiers are given by the caller, as they must be unique across all queues.
+func (q *Queue) Dispatch(id int, node scraping.Node, requests []QueuedRequest, lease time.Duration) *InFlightBatch {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	batch := &InFlightBatch{id, node, requests, now.Add(lease), now, make(map[string]bool), 0}
+	if id >= q.nextBatch {
+		q.nextBatch = id + 1
+	}
+	q.counters.BatchesDispatched += 1
+	ids := make([]int, len(requests))
+	for i, request := range requests {
+		ids[i] = request.ID
+	}
+	q.inFlight[batch.ID] = batch
+	q.write(JournalEntry{Op: "dispatch", Batch: batch.ID, IDs: ids})
+	return batch
+}
+
+// Record that a dispatched batch could not be sent to its node. Its requests can be dispatched again.
+func (q *Queue) Abort(batch *InFlightBatch) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.inFlight, batch.ID)
+	q.write(JournalEntry{Op: "abort", Batch: batch.ID})
+}
+
+// Record that a result of a batch (0 if its batch is not in flight anymore)
+// is about to be stored, and count it. The result is journaled before being
+// written to the sinks, so that a crash in between may lose it, but never
+// stores it twice by performing its request again.
+func (q *Queue) Stored(batch int, result scraping.Result) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	summary := StoredResultOf(result)
+	if inFlight, present := q.inFlight[batch]; present {
+		inFlight.stored[summary.Key] = true
+	}
+	q.counters.Count(summary)
+	q.write(JournalEntry{Op: "result", Batch: batch, Stored: &summary})
+}
+
+// Record that the results of a batch have been received. The requests of the
+// batch for which no result has been stored (not performed by the node, or
+// whose worker failed) are put back in the queue, with the same attempt.
+func (q *Queue) Complete(batch int) {
+	q.lock.Lock()
+	inFlight, present := q.inFlight[batch]
+	entries := make([]JournalEntry, 0)
+	requeued := false
+	if present {
+		for _, request := range inFlight.Requests {
+			if inFlight.stored[request.Request.Key()] {
+				continue
+			}
+			request.ID = q.nextID
+			q.nextID += 1
+			q.pending = append(q.pending, request)
+			entries = append(entries, request.entry(false))
+			requeued = true
+		}
+	}
+	delete(q.inFlight, batch)
+	q.write(append(entries, JournalEntry{Op: "complete", Batch: batch})...)
+	q.lock.Unlock()
+	if requeued {
+		select {
+		case q.available <- true:
+		default:
+		}
+	}
+}
+
+// Extend the lease of the batch in flight on the given node, if the node has
+// performed more of its requests (done) since the lease was last extended. A
+// node whose browser or workers are stuck still sends heartbeats, but loses
+// its batch once the lease runs out.
+func (q *Queue) Renew(node scraping.Node, done int, lease time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, batch := range q.inFlight {
+		if batch.Node == node && done > batch.progress {
+			batch.progress = done
+			batch.Deadline = time.Now().Add(lease)
+		}
+	}
+}
+
+// Expire the leases of the batches whose deadline has passed, or that are held by one of the given nodes.
+// The requests of these batches for which no result has been stored are put back in the queue.
+// Returns the expired batches.
+func (q *Queue) Expire(now time.Time, lostNodes []scraping.Node) []*ExpiredBatch {
+	q.lock.Lock()
+	expired := make([]*ExpiredBatch, 0)
+	entries := make([]JournalEntry, 0)
+	for id, batch := range q.inFlight {
+		lost := now.After(batch.Deadline)
+		for _, node := range lostNodes {
+			if batch.Node == node {
+				lost = true
+			}
+		}
+		if !lost {
+			continue
+		}
+		record := &ExpiredBatch{batch.ID, batch.Node, batch.Dispatched, batch.Requests, make(map[string]QueuedRequest), make(map[string]int)}
+		for _, request := range batch.Requests {
+			if batch.stored[request.Request.Key()] {
+				continue
+			}
+			requeued := QueuedRequest{q.nextID, request.Request, request.Attempt + 1, request.Parent, time.Time{}}
+			q.nextID += 1
+			q.pending = append(q.pending, requeued)
+			record.requests[request.Request.Key()] = request
+			record.requeued[request.Request.Key()] = requeued.ID
+			entries = append(entries, requeued.entry(false))
+		}
+		entries = append(entries, JournalEntry{Op: "expire", Batch: id})
+		delete(q.inFlight, id)
+		q.expired[id] = record
+		expired = append(expired, record)
+	}
+	if len(entries) > 0 {
+		q.write(entries...)
+	}
+	q.lock.Unlock()
+	if len(expired) > 0 {
+		select {
+		case q.available <- true:
+		default:
+		}
+	}
+	return expired
+}
+
+// Find the expired batch with the given identifier that was held by the given node.
+// If the identifier is 0, find any expired batch of the node.
+func (q *Queue) ExpiredBatchOf(id int, node scraping.Node) (*ExpiredBatch, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if id != 0 {
+		batch, present := q.expired[id]
+		return batch, present && batch.Node == node
+	}
+	for _, batch := range q.expired {
+		if batch.Node == node {
+			return batch, true
+		}
+	}
+	return nil, false
+}
+
+// Decide whether a late result of an expired batch should be kept. This is the
+// case if the corresponding request is still in the queue, in which case it is
+// removed from the queue. Otherwise, the request has already been dispatched
+// to another node and the late result is a duplicate.
+// Returns the original request of the result if it is kept.
+func (q *Queue) ClaimLateResult(batch *ExpiredBatch, key string) (QueuedRequest, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	id, present := batch.requeued[key]
+	if !present {
+		return QueuedRequest{}, false
+	}
+	delete(batch.requeued, key) // The result can only be claimed once
+	if _, found := q.remove(func(request QueuedRequest) bool { return request.ID == id }); !found {
+		return QueuedRequest{}, false
+	}
+	return batch.requests[key], true
+}
+
+// Decide whether a result of a batch that is not known to be in flight should
+// be kept, such as a batch dispatched before the coordinator restarted, whose
+// requests were put back in the queue. As for late results, it is kept if its
+// request is still waiting in the queue, in which case it is removed from the
+// queue. Returns the request of the result if it is kept.
+func (q *Queue) ClaimResult(key string) (QueuedRequest, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.remove(func(request QueuedRequest) bool { return request.Request.Key() == key })
+}
+
+// Remove from the queue the first waiting request that matches, returns false
+// if there is none. Must be called with the lock held.
+func (q *Queue) remove(match func(QueuedRequest) bool) (QueuedRequest, bool) {
+	lists := [][]QueuedRequest{q.pending, q.delayed, q.unchecked[true], q.unchecked[false]}
+	for _, domain := range q.blockedDomains {
+		lists = append(lists, q.blocked[domain])
+	}
+	for l, requests := range lists {
+		for i, request := range requests {
+			if !match(request) {
+				continue
+			}
+			lists[l] = append(requests[:i], requests[i+1:]...)
+			q.pending, q.delayed, q.unchecked[true], q.unchecked[false] = lists[0], lists[1], lists[2], lists[3]
+			if l >= 4 {
+				domain := q.blockedDomains[l - 4]
+				if q.blocked[domain] = lists[l]; len(lists[l]) == 0 {
+					delete(q.blocked, domain)
+					q.blockedDomains = append(q.blockedDomains[:l - 4], q.blockedDomains[l - 3:]...)
+				}
+			}
+			q.write(JournalEntry{Op: "cancel", ID: request.ID})
+			return request, true
+		}
+	}
+	return QueuedRequest{}, false
+}
+
+// Forget the expired batches of a node, no late result can be received for them anymore
+func (q *Queue) ForgetExpired(node scraping.Node) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for id, batch := range q.expired {
+		if batch.Node == node {
+			delete(q.expired, id)
+		}
+	}
+}
+
+// Find the batch with the given identifier that is in flight on the given node.
+// If the identifier is 0, find any batch in flight on the node.
+func (q *Queue) BatchOf(id int, node scraping.Node) (*InFlightBatch, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if id != 0 {
+		batch, present := q.inFlight[id]
+		return batch, present && batch.Node == node
+	}
+	for _, batch := range q.inFlight {
+		if batch.Node == node {
+			return batch, true
+		}
+	}
+	return nil, false
+}
+
+// The current counters of the queue
+func (q *Queue) Counters() Counters {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.counters.Copy()
+}
+
+// The identifier following the identifiers of the batches dispatched from this queue
+func (q *Queue) NextBatch() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.nextBatch
+}
+
+// Number of requests that can be dispatched, including those set aside
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.promote(time.Now())
+	count := len(q.pending)
+	for _, requests := range q.blocked {
+		count += len(requests)
+	}
+	return count
+}
+
+// Number of requests waiting to be retried
+func (q *Queue) Delayed() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.promote(time.Now())
+	return len(q.delayed)
+}
+
+// Number of requests waiting to be checked
+func (q *Queue) Unchecked() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.unchecked[true]) + len(q.unchecked[false]) + q.checking
+}
+
+// Number of batches currently in flight
+func (q *Queue) InFlight() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.inFlight)
+}
+
+// Flush and close the journal
+func (q *Queue) Close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.writer.Flush()
+	q.journal.Sync()
+	q.journal.Close()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"scraping"
+)
+
+func request(url string) QueuedRequest {
+	return QueuedRequest{Request: scraping.Request{URL: url, TopLevel: true, Job: "test"}, Attempt: 1}
+}
+
+// Pop all requests that can be dispatched right away
+func popAll(q *Queue) []QueuedRequest {
+	requests := make([]QueuedRequest, 0)
+	for {
+		request, found := q.Pop(0, nil)
+		if !found {
+			return requests
+		}
+		requests = append(requests, request)
+	}
+}
+
+func urlsOf(requests []QueuedRequest) []string {
+	urls := make([]string, len(requests))
+	for i, request := range requests {
+		urls[i] = request.Request.URL
+	}
+	return urls
+}
+
+func checkURLs(t *testing.T, requests []QueuedRequest, want ...string) {
+	t.Helper()
+	got := urlsOf(requests)
+	if len(got) != len(want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, expected %q", got, want)
+		}
+	}
+}
+
+var node = scraping.Node{URL: "127.0.0.1:7000"}
+
+func TestCompleteRequeuesMissingResults(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := OpenQueue(dir, nil)
+	q.Push([]QueuedRequest{request("http://a.test/"), request("http://b.test/"), request("http://c.test/")}, true)
+	popped := popAll(q)
+	popped[1].Attempt = 2
+	batch := q.Dispatch(1, node, popped, time.Minute)
+	// The node reports a result for a.test only: the worker of b.test failed,
+	// and c.test was not performed
+	q.Stored(batch.ID, scraping.Result{URL: "http://a.test/", Job: "test"})
+	q.Complete(batch.ID)
+	if q.InFlight() != 0 {
+		t.Errorf("%d batches in flight after completion", q.InFlight())
+	}
+	requeued := popAll(q)
+	checkURLs(t, requeued, "http://b.test/", "http://c.test/")
+	if requeued[0].Attempt != 2 || requeued[1].Attempt != 1 {
+		t.Errorf("attempts %d and %d, expected 2 and 1", requeued[0].Attempt, requeued[1].Attempt)
+	}
+	q.Close()
+
+	// The requeued requests are journaled
+	q, _ = OpenQueue(dir, nil)
+	defer q.Close()
+	checkURLs(t, popAll(q), "http://b.test/", "http://c.test/")
+	if counters := q.Counters(); counters.TotalScraped != 1 || counters.TotalURLsToRequest != 3 {
+		t.Errorf("counters %+v after replay", counters)
+	}
+}
+
+func TestClaimResultAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := OpenQueue(dir, nil)
+	q.Push([]QueuedRequest{request("http://a.test/"), request("http://b.test/")}, true)
+	q.Dispatch(1, node, popAll(q), time.Minute)
+	q.Close()
+
+	// The batch in flight is lost, its requests are back in the queue
+	q, _ = OpenQueue(dir, nil)
+	if q.InFlight() != 0 || q.Len() != 2 {
+		t.Fatalf("%d batches in flight and %d requests queued after restart", q.InFlight(), q.Len())
+	}
+	// The node reports its result for a.test, which is kept once
+	claimed, keep := q.ClaimResult(scraping.RequestKey("test", "http://a.test/"))
+	if !keep || claimed.Request.URL != "http://a.test/" || claimed.Attempt != 1 {
+		t.Fatalf("result not kept, or for request %+v", claimed)
+	}
+	if _, keep := q.ClaimResult(scraping.RequestKey("test", "http://a.test/")); keep {
+		t.Errorf("result kept twice")
+	}
+	// Once b.test has been dispatched again, the result of the old batch is a duplicate
+	q.Dispatch(2, node, popAll(q), time.Minute)
+	if _, keep := q.ClaimResult(scraping.RequestKey("test", "http://b.test/")); keep {
+		t.Errorf("result kept although its request is in flight on another node")
+	}
+	q.Close()
+
+	// The claimed request is not queued again on the next restart
+	q, _ = OpenQueue(dir, nil)
+	defer q.Close()
+	checkURLs(t, popAll(q), "http://b.test/")
+}
+
+func TestPopSetsAsideBlockedDomains(t *testing.T) {
+	q, _ := OpenQueue(t.TempDir(), nil)
+	defer q.Close()
+	requests := make([]QueuedRequest, 0)
+	for i := 0; i < 5000; i++ {
+		requests = append(requests, request(fmt.Sprintf("http://www.busy.test/%d", i)))
+	}
+	requests = append(requests, request("http://other.test/"), request("http://sub.busy.test/"), request("http://last.test/"))
+	q.Push(requests, true)
+	blocked := true
+	calls := 0
+	accept := func(request QueuedRequest) bool {
+		calls += 1
+		return !blocked || DomainOf(request.Request.URL) != "busy.test"
+	}
+	// The requests to other domains are found behind the requests to a domain that cannot be dispatched to
+	first, _ := q.Pop(0, accept)
+	second, _ := q.Pop(0, accept)
+	checkURLs(t, []QueuedRequest{first, second}, "http://other.test/", "http://last.test/")
+	if calls != 4 {
+		t.Errorf("%d requests looked at, expected 4", calls)
+	}
+	if _, found := q.Pop(0, accept); found || q.Len() != 5001 {
+		t.Fatalf("%d requests queued, expected 5001", q.Len())
+	}
+	// The requests set aside can be claimed, and are popped in order once their domain accepts them
+	if _, keep := q.ClaimResult(scraping.RequestKey("test", "http://www.busy.test/1")); !keep {
+		t.Errorf("request set aside not claimed")
+	}
+	q.Push([]QueuedRequest{request("http://new.test/"), request("http://www.busy.test/new")}, true)
+	blocked = false
+	popped := make([]QueuedRequest, 0)
+	for {
+		request, found := q.Pop(0, accept)
+		if !found {
+			break
+		}
+		popped = append(popped, request)
+	}
+	if len(popped) != 5002 || popped[0].Request.URL != "http://www.busy.test/0" || popped[1].Request.URL != "http://www.busy.test/2" {
+		t.Fatalf("%d requests popped, starting with %q", len(popped), urlsOf(popped[:2]))
+	}
+	checkURLs(t, popped[4998:], "http://www.busy.test/4999", "http://sub.busy.test/", "http://new.test/", "http://www.busy.test/new")
+	if q.Len() != 0 {
+		t.Errorf("%d requests left", q.Len())
+	}
+}
+
+// Write a journal, one entry per line
+func writeJournal(t *testing.T, dir string, lines ...string) {
+	t.Helper()
+	content := strings.Join(lines, "\n")
+	if err := os.WriteFile(filepath.Join(dir, JOURNAL_FILE), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readJournal(t *testing.T, dir string) []JournalEntry {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, JOURNAL_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]JournalEntry, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry JournalEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid journal entry %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	writeJournal(t, dir,
+		`{"Op":"enqueue","ID":1,"Request":{"URL":"http://a.test/","TopLevel":true,"Job":"test"},"New":true,"Attempt":1}`,
+		`{"Op":"enqueue","ID":2,"Request":{"URL":"http://b.test/","TopLevel":true,"Job":"test"},"New":true,"Attempt":1}`,
+		`{"Op":"enqueue","ID":3,"Request":{"URL":"http://c.test/","TopLevel":true,"Job":"test"},"New":true,"Attempt":1}`,
+		`{"Op":"enqueue","ID":4,"Request":{"URL":"http://d.test/","TopLevel":true,"Job":"test"},"New":true,"Attempt":1}`,
+		`{"Op":"enqueue","ID":5,"Request":{"URL":"http://a.test/x","Job":"test","Depth":1},"New":true,"Attempt":1,"Parent":"http://a.test/"}`,
+		// Batch 1 is completed, with one result missing which is requeued
+		`{"Op":"dispatch","Batch":1,"IDs":[1,2]}`,
+		`{"Op":"result","Batch":1,"Stored":{"Key":"test http://a.test/","Scripts":true}}`,
+		`{"Op":"enqueue","ID":6,"Request":{"URL":"http://b.test/","TopLevel":true,"Job":"test"},"Attempt":1}`,
+		`{"Op":"complete","Batch":1}`,
+		// Batch 2 is in flight, with one result stored
+		`{"Op":"dispatch","Batch":2,"IDs":[3,4]}`,
+		`{"Op":"result","Batch":2,"Stored":{"Key":"test http://c.test/","Timeout":true,"Error":"timeout"}}`,
+		`{"Op":"duplicates","Count":2}`,
+	)
+	q, resumed := OpenQueue(dir, nil)
+	defer q.Close()
+	if !resumed {
+		t.Fatalf("journal not found")
+	}
+	// Requests of the batch in flight without result are back at their place
+	requests := popAll(q)
+	checkURLs(t, requests, "http://d.test/", "http://a.test/x", "http://b.test/")
+	if requests[1].Parent != "http://a.test/" || requests[1].Request.Depth != 1 || requests[1].ID != 5 {
+		t.Errorf("link replayed as %+v", requests[1])
+	}
+	counters := q.Counters()
+	want := Counters{TotalURLsToRequest: 5, TotalScraped: 2, TotalTimeouts: 1, TotalScripts: 1, BatchesDispatched: 2, ResultsReceived: 2, Duplicates: 2, Errors: map[scraping.ErrorCode]int{"timeout": 1}}
+	if !reflect.DeepEqual(counters, want) {
+		t.Errorf("counters %+v, expected %+v", counters, want)
+	}
+	if q.NextBatch() != 3 {
+		t.Errorf("next batch %d, expected 3", q.NextBatch())
+	}
+	q.Push([]QueuedRequest{request("http://e.test/")}, true)
+	if e := popAll(q); len(e) != 1 || e[0].ID != 7 {
+		t.Errorf("new request %+v, expected identifier 7", e)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := OpenQueue(dir, nil)
+	q.Push([]QueuedRequest{request("http://a.test/"), request("http://b.test/"), request("http://c.test/")}, true)
+	first, _ := q.Pop(0, nil)
+	batch := q.Dispatch(1, node, []QueuedRequest{first}, time.Minute)
+	q.Retry(request("http://r.test/"), time.Now().Add(time.Hour))
+	q.Stored(batch.ID, scraping.Result{URL: "http://x.test/", Job: "test"})
+	q.Close()
+
+	q, _ = OpenQueue(dir, nil)
+	counters := q.Counters()
+	q.Close()
+	// The compacted journal only has the counters and the requests waiting,
+	// including the request of the batch that was in flight and the delayed one
+	entries := readJournal(t, dir)
+	if len(entries) != 5 || entries[0].Op != "counters" {
+		t.Fatalf("compacted journal %+v", entries)
+	}
+	urls := make([]string, 0)
+	for _, entry := range entries[1:] {
+		if entry.Op != "enqueue" || entry.New {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+		urls = append(urls, entry.Request.URL)
+	}
+	if want := []string{"http://a.test/", "http://b.test/", "http://c.test/", "http://r.test/"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("compacted requests %q, expected %q", urls, want)
+	}
+	if entries[4].NotBefore.IsZero() {
+		t.Errorf("delay of the retried request lost")
+	}
+
+	// Replaying the compacted journal gives the same queue
+	q, _ = OpenQueue(dir, nil)
+	defer q.Close()
+	checkURLs(t, popAll(q), "http://a.test/", "http://b.test/", "http://c.test/")
+	if q.Delayed() != 1 || !reflect.DeepEqual(q.Counters(), counters) {
+		t.Errorf("%d requests delayed, counters %+v, expected %+v", q.Delayed(), q.Counters(), counters)
+	}
+}
+
+func TestReplayTruncatedEntry(t *testing.T) {
+	dir := t.TempDir()
+	writeJournal(t, dir,
+		`{"Op":"enqueue","ID":1,"Request":{"URL":"http://a.test/","TopLevel":true,"Job":"test"},"New":true,"Attempt":1}`,
+		`{"Op":"enqueue","ID":2,"Request":{"URL":"http://b.test/","TopLevel":true,"Job":"test"},"New":true,"Attempt":1}`,
+		`{"Op":"dispatch","Batch":1,"IDs":[1,`,
+	)
+	q, _ := OpenQueue(dir, nil)
+	checkURLs(t, popAll(q), "http://a.test/", "http://b.test/")
+	q.Push([]QueuedRequest{request("http://c.test/")}, true)
+	q.Close()
+	// The truncated entry is dropped by the compaction, entries written after it are replayed
+	q, _ = OpenQueue(dir, nil)
+	defer q.Close()
+	checkURLs(t, popAll(q), "http://a.test/", "http://b.test/", "http://c.test/")
+	if counters := q.Counters(); counters.TotalURLsToRequest != 3 || counters.BatchesDispatched != 0 {
+		t.Errorf("counters %+v", counters)
+	}
+}
@@ -0,0 +1,264 @@
+// Package metrics exposes the metrics of the coordinator and of the nodes on
+// a /metrics endpoint, in the text format of Prometheus.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A value of a metric, along with the values of its labels
+type Sample struct {
+	Labels []string // In the order of the label names of the metric
+	Value float64
+}
+
+// A metric, written in the text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// The metrics exposed by a program
+type Registry struct {
+	lock sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write all metrics in the text format
+func (r *Registry) Write(w io.Writer) {
+	r.lock.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.lock.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Serve the metrics, to be registered on /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// The labels of a sample, such as {job="default",outcome="timeout"}, empty if there is none
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escape(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+// The identifier of the values of the labels of a sample
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// A metric whose samples are kept by the registry, for counters and gauges
+type valueMetric struct {
+	name string
+	help string
+	kind string
+	labels []string
+	lock sync.Mutex
+	samples map[string]*Sample
+}
+
+func (m *valueMetric) update(values []string, update func(*float64)) {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(values)))
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sample, present := m.samples[key(values)]
+	if !present {
+		sample = &Sample{Labels: append([]string{}, values...)}
+		m.samples[key(values)] = sample
+	}
+	update(&sample.Value)
+}
+
+func (m *valueMetric) write(w io.Writer) {
+	m.lock.Lock()
+	samples := make([]Sample, 0, len(m.samples))
+	for _, sample := range m.samples {
+		samples = append(samples, *sample)
+	}
+	m.lock.Unlock()
+	writeSamples(w, m.name, m.help, m.kind, m.labels, samples)
+}
+
+func writeSamples(w io.Writer, name string, help string, kind string, labels []string, samples []Sample) {
+	sort.Slice(samples, func(i, j int) bool { return key(samples[i].Labels) < key(samples[j].Labels) })
+	writeHeader(w, name, help, kind)
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, sample.Labels), formatValue(sample.Value))
+	}
+}
+
+// A value that only increases
+type Counter struct {
+	valueMetric
+}
+
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	c := &Counter{valueMetric{name: name, help: help, kind: "counter", labels: labels, samples: make(map[string]*Sample)}}
+	r.register(c)
+	return c
+}
+
+// Increase the counter with the given label values
+func (c *Counter) Add(delta float64, values ...string) {
+	c.update(values, func(value *float64) { *value += delta })
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// A value that can go up and down
+type Gauge struct {
+	valueMetric
+}
+
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{valueMetric{name: name, help: help, kind: "gauge", labels: labels, samples: make(map[string]*Sample)}}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.update(values, func(value *float64) { *value = v })
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.update(values, func(value *float64) { *value += delta })
+}
+
+// A metric whose samples are computed each time the metrics are written,
+// for values that are already tracked elsewhere
+type funcMetric struct {
+	name string
+	help string
+	kind string
+	labels []string
+	collect func() []Sample
+}
+
+func (m *funcMetric) write(w io.Writer) {
+	writeSamples(w, m.name, m.help, m.kind, m.labels, m.collect())
+}
+
+// A counter whose samples are computed by collect
+func (r *Registry) CounterFunc(name string, help string, collect func() []Sample, labels ...string) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", labels: labels, collect: collect})
+}
+
+// A gauge whose samples are computed by collect
+func (r *Registry) GaugeFunc(name string, help string, collect func() []Sample, labels ...string) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", labels: labels, collect: collect})
+}
+
+// The observations of a histogram for some label values
+type histogramSample struct {
+	labels []string
+	counts []uint64 // Per bucket, not cumulative
+	count uint64
+	sum float64
+}
+
+// Counts observations (e.g., durations) in buckets
+type Histogram struct {
+	name string
+	help string
+	labels []string
+	buckets []float64 // Upper bounds, sorted
+	lock sync.Mutex
+	samples map[string]*histogramSample
+}
+
+// A histogram with the given upper bounds of its buckets, to which +Inf is implicitly added
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, samples: make(map[string]*histogramSample)}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", h.name, len(h.labels), len(values)))
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sample, present := h.samples[key(values)]
+	if !present {
+		sample = &histogramSample{labels: append([]string{}, values...), counts: make([]uint64, len(h.buckets))}
+		h.samples[key(values)] = sample
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		sample.counts[i] += 1
+	}
+	sample.count += 1
+	sample.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	samples := make([]*histogramSample, 0, len(h.samples))
+	for _, sample := range h.samples {
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool { return key(samples[i].labels) < key(samples[j].labels) })
+	writeHeader(w, h.name, h.help, "histogram")
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, sample := range samples {
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += sample.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(append([]string{}, sample.labels...), formatValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(append([]string{}, sample.labels...), "+Inf")), sample.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, sample.labels), formatValue(sample.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, sample.labels), sample.count)
+	}
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The metrics of a registry in the text format
+func written(r *Registry) string {
+	var b strings.Builder
+	r.Write(&b)
+	return b.String()
+}
+
+func checkOutput(t *testing.T, got string, want ...string) {
+	t.Helper()
+	if expected := strings.Join(want, "\n") + "\n"; got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("scraping_results_total", "Results received", "job", "outcome")
+	g := r.Gauge("scraping_nodes", "Nodes\nconnected")
+	c.Inc("default", "timeout")
+	c.Add(2.5, "default", "scripts")
+	c.Inc("default", "timeout")
+	g.Set(3)
+	g.Add(-1)
+	checkOutput(t, written(r),
+		`# HELP scraping_results_total Results received`,
+		`# TYPE scraping_results_total counter`,
+		`scraping_results_total{job="default",outcome="scripts"} 2.5`,
+		`scraping_results_total{job="default",outcome="timeout"} 2`,
+		`# HELP scraping_nodes Nodes connected`,
+		`# TYPE scraping_nodes gauge`,
+		`scraping_nodes 2`,
+	)
+}
+
+func TestEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests", "url")
+	c.Inc(`C:\dir "quoted"` + "\nnext")
+	checkOutput(t, written(r),
+		`# HELP requests_total Requests`,
+		`# TYPE requests_total counter`,
+		`requests_total{url="C:\\dir \"quoted\"\nnext"} 1`,
+	)
+}
+
+func TestFuncMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.GaugeFunc("queue_length", "Requests queued", func() []Sample {
+		return []Sample{{Labels: []string{"b"}, Value: 2}, {Labels: []string{"a"}, Value: 1e9}}
+	}, "job")
+	r.CounterFunc("uptime_seconds_total", "Uptime", func() []Sample {
+		return []Sample{{Value: 0.5}}
+	})
+	checkOutput(t, written(r),
+		`# HELP queue_length Requests queued`,
+		`# TYPE queue_length gauge`,
+		`queue_length{job="a"} 1e+09`,
+		`queue_length{job="b"} 2`,
+		`# HELP uptime_seconds_total Uptime`,
+		`# TYPE uptime_seconds_total counter`,
+		`uptime_seconds_total 0.5`,
+	)
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	// The buckets are sorted
+	h := r.Histogram("batch_seconds", "Duration of batches", []float64{1, 0.5, 2}, "job")
+	for _, v := range []float64{0.25, 0.5, 0.75, 3} {
+		h.Observe(v, "b")
+	}
+	h.Observe(1, "a")
+	checkOutput(t, written(r),
+		`# HELP batch_seconds Duration of batches`,
+		`# TYPE batch_seconds histogram`,
+		`batch_seconds_bucket{job="a",le="0.5"} 0`,
+		`batch_seconds_bucket{job="a",le="1"} 1`,
+		`batch_seconds_bucket{job="a",le="2"} 1`,
+		`batch_seconds_bucket{job="a",le="+Inf"} 1`,
+		`batch_seconds_sum{job="a"} 1`,
+		`batch_seconds_count{job="a"} 1`,
+		// Bounds are inclusive, and bucket counts are cumulative
+		`batch_seconds_bucket{job="b",le="0.5"} 2`,
+		`batch_seconds_bucket{job="b",le="1"} 3`,
+		`batch_seconds_bucket{job="b",le="2"} 3`,
+		`batch_seconds_bucket{job="b",le="+Inf"} 4`,
+		`batch_seconds_sum{job="b"} 4.5`,
+		`batch_seconds_count{job="b"} 4`,
+	)
+}
+
+// The +Inf bucket of every histogram has the count of all observations
+func TestHistogramInfBucket(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("size_bytes", "Sizes", []float64{10, 100})
+	for _, v := range []float64{5, 50, 500, 5000, 100} {
+		h.Observe(v)
+	}
+	counts := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(written(r)))
+	for scanner.Scan() {
+		if name, value, found := strings.Cut(scanner.Text(), " "); found && !strings.HasPrefix(name, "#") {
+			counts[name] = value
+		}
+	}
+	if counts[`size_bytes_bucket{le="+Inf"}`] != "5" || counts["size_bytes_count"] != "5" {
+		t.Errorf("+Inf bucket %s and count %s, expected 5", counts[`size_bytes_bucket{le="+Inf"}`], counts["size_bytes_count"])
+	}
+	if counts[`size_bytes_bucket{le="100"}`] != "3" {
+		t.Errorf("le=100 bucket %s, expected 3", counts[`size_bytes_bucket{le="100"}`])
+	}
+}
+
+func TestLabelMismatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("c_total", "Counter", "job")
+	g := r.Gauge("g", "Gauge", "job", "node")
+	h := r.Histogram("h", "Histogram", []float64{1}, "job")
+	tests := []struct {
+		name string
+		update func()
+	}{
+		{"counter without values", func() { c.Inc() }},
+		{"counter with too many values", func() { c.Add(1, "a", "b") }},
+		{"gauge with too few values", func() { g.Set(1, "a") }},
+		{"histogram with too many values", func() { h.Observe(1, "a", "b") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("no panic")
+				}
+			}()
+			test.update()
+		})
+	}
+	// Nothing was recorded
+	checkOutput(t, written(r),
+		`# HELP c_total Counter`,
+		`# TYPE c_total counter`,
+		`# HELP g Gauge`,
+		`# TYPE g gauge`,
+		`# HELP h Histogram`,
+		`# TYPE h histogram`,
+	)
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("up", "Up").Set(1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", got)
+	}
+	checkOutput(t, w.Body.String(), `# HELP up Up`, `# TYPE up gauge`, `up 1`)
+}
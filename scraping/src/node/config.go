@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"github.com/BurntSushi/toml"
+	"scraping"
+)
+
+// The settings that can be given in the configuration file (with the key
+// as name), on the command line (with dashes instead of underscores), and in
+// the environment (in upper case, prefixed with NODE_)
+var settings = []struct {
+	key string
+	usage string
+}{
+	{"workers", "number of pages scraped in parallel"},
+	{"urls_to_extract", "number of links followed from each page"},
+	{"max_depth", "links are followed up to this many links away from top level pages"},
+	{"link_selection", "how the links followed are selected: random, first, patterns or unseen"},
+	{"link_patterns", "comma-separated regular expressions matched against the path of links, preferred by the patterns selection"},
+	{"link_seed", "seed from which the seed of the random selection of each page is derived, 0 for a new seed for each page"},
+	{"prevent_headless_detection", "hide from the pages that the browser is headless"},
+	{"use_tor", "perform requests through tor"},
+	{"tor_proxy", "proxy used when use_tor is set"},
+	{"timeout_seconds", "time given to a page to load"},
+	{"post_load_wait_seconds", "time to wait for scripts after a page has loaded"},
+	{"user_agent", "user agent of the browser"},
+	{"window_width", "width of the browser window"},
+	{"window_height", "height of the browser window"},
+}
+
+// The settings used when they are not given
+func DefaultConfig() scraping.NodeConfig {
+	return scraping.NodeConfig{
+		Workers: 4,
+		URLsToExtract: 3,
+		MaxDepth: 1,
+		LinkSelection: scraping.LinkSelectionRandom,
+		LinkPatterns: scraping.DefaultLinkPatterns,
+		PreventHeadlessDetection: false,
+		UseTor: false,
+		TorProxy: "socks5://localhost:9050",
+		TimeoutSeconds: 35,
+		PostLoadWaitSeconds: 5,
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36",
+		WindowWidth: 1920,
+		WindowHeight: 1080,
+	}
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+func envName(key string) string {
+	return "NODE_" + strings.ToUpper(key)
+}
+
+// Set a setting from its textual value
+func Set(config *scraping.NodeConfig, key string, value string) error {
+	var err error
+	switch key {
+	case "workers":
+		config.Workers, err = strconv.Atoi(value)
+	case "urls_to_extract":
+		config.URLsToExtract, err = strconv.Atoi(value)
+	case "max_depth":
+		config.MaxDepth, err = strconv.Atoi(value)
+	case "link_selection":
+		config.LinkSelection = value
+	case "link_patterns":
+		config.LinkPatterns = make([]string, 0)
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				config.LinkPatterns = append(config.LinkPatterns, pattern)
+			}
+		}
+	case "link_seed":
+		config.LinkSeed, err = strconv.ParseInt(value, 10, 64)
+	case "prevent_headless_detection":
+		config.PreventHeadlessDetection, err = strconv.ParseBool(value)
+	case "use_tor":
+		config.UseTor, err = strconv.ParseBool(value)
+	case "tor_proxy":
+		config.TorProxy = value
+	case "timeout_seconds":
+		config.TimeoutSeconds, err = strconv.Atoi(value)
+	case "post_load_wait_seconds":
+		config.PostLoadWaitSeconds, err = strconv.Atoi(value)
+	case "user_agent":
+		config.UserAgent = value
+	case "window_width":
+		config.WindowWidth, err = strconv.Atoi(value)
+	case "window_height":
+		config.WindowHeight, err = strconv.Atoi(value)
+	default:
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s", value, key)
+	}
+	return nil
+}
+
+// Check that the settings make sense
+func Validate(config scraping.NodeConfig) error {
+	if config.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", config.Workers)
+	}
+	if config.URLsToExtract < 0 {
+		return fmt.Errorf("urls_to_extract cannot be negative, got %d", config.URLsToExtract)
+	}
+	if config.MaxDepth < 1 {
+		return fmt.Errorf("max_depth must be at least 1, got %d", config.MaxDepth)
+	}
+	if err := scraping.ValidateLinkSelection(config.LinkSelection, config.LinkPatterns); err != nil {
+		return err
+	}
+	if config.TimeoutSeconds < 1 {
+		return fmt.Errorf("timeout_seconds must be at least 1, got %d", config.TimeoutSeconds)
+	}
+	if config.PostLoadWaitSeconds < 0 {
+		return fmt.Errorf("post_load_wait_seconds cannot be negative, got %d", config.PostLoadWaitSeconds)
+	}
+	if config.UseTor && config.TorProxy == "" {
+		return fmt.Errorf("tor_proxy must be given when use_tor is set")
+	}
+	if config.UserAgent == "" {
+		return fmt.Errorf("user_agent cannot be empty")
+	}
+	if config.WindowWidth < 1 || config.WindowHeight < 1 {
+		return fmt.Errorf("invalid window size %dx%d", config.WindowWidth, config.WindowHeight)
+	}
+	return nil
+}
+
+// Load the settings from, by order of precedence, the command line, the
+// environment, the configuration file, and the defaults. Returns the
+// remaining command line arguments.
+func LoadConfig(args []string) (scraping.NodeConfig, []string, error) {
+	config := DefaultConfig()
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] <server address> <node address>\n", args[0])
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", os.Getenv("NODE_CONFIG"), "TOML configuration file (can also be given with NODE_CONFIG)")
+	for _, setting := range settings {
+		usage := fmt.Sprintf("%s (%s in the environment)", setting.usage, envName(setting.key))
+		switch value := defaultValue(config, setting.key).(type) {
+		case int:
+			flags.Int(flagName(setting.key), value, usage)
+		case bool:
+			flags.Bool(flagName(setting.key), value, usage)
+		case int64:
+			flags.Int64(flagName(setting.key), value, usage)
+		case string:
+			flags.String(flagName(setting.key), value, usage)
+		case []string:
+			flags.String(flagName(setting.key), strings.Join(value, ","), usage)
+		}
+	}
+	flags.Parse(args[1:])
+
+	if *configFile != "" {
+		metadata, err := toml.DecodeFile(*configFile, &config)
+		if err != nil {
+			return config, nil, fmt.Errorf("cannot read %s: %v", *configFile, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return config, nil, fmt.Errorf("unknown setting %s in %s", undecoded[0].String(), *configFile)
+		}
+	}
+	for _, setting := range settings {
+		if value, present := os.LookupEnv(envName(setting.key)); present {
+			if err := Set(&config, setting.key, value); err != nil {
+				return config, nil, fmt.Errorf("%s: %v", envName(setting.key), err)
+			}
+		}
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && err == nil {
+			err = Set(&config, strings.ReplaceAll(f.Name, "-", "_"), f.Value.String())
+		}
+	})
+	if err != nil {
+		return config, nil, err
+	}
+	if err := Validate(config); err != nil {
+		return config, nil, err
+	}
+	return config, flags.Args(), nil
+}
+
+// The value of a setting in the given configuration, used as default value of its flag
+func defaultValue(config scraping.NodeConfig, key string) interface{} {
+	switch key {
+	case "workers":
+		return config.Workers
+	case "urls_to_extract":
+		return config.URLsToExtract
+	case "max_depth":
+		return config.MaxDepth
+	case "link_selection":
+		return config.LinkSelection
+	case "link_patterns":
+		return config.LinkPatterns
+	case "link_seed":
+		return config.LinkSeed
+	case "prevent_headless_detection":
+		return config.PreventHeadlessDetection
+	case "use_tor":
+		return config.UseTor
+	case "tor_proxy":
+		return config.TorProxy
+	case "timeout_seconds":
+		return config.TimeoutSeconds
+	case "post_load_wait_seconds":
+		return config.PostLoadWaitSeconds
+	case "user_agent":
+		return config.UserAgent
+	case "window_width":
+		return config.WindowWidth
+	case "window_height":
+		return config.WindowHeight
+	}
+	return nil
+}
+
+// The settings to use for the requests of a job, the job taking precedence over the node
+func ApplyJob(config scraping.NodeConfig, job scraping.JobSpec) scraping.NodeConfig {
+	config.TimeoutSeconds = job.TimeoutSeconds
+	config.URLsToExtract = job.URLsToExtract
+	config.MaxDepth = job.MaxDepth
+	config.LinkSelection = job.LinkSelection
+	config.LinkPatterns = job.LinkPatterns
+	config.LinkSeed = job.LinkSeed
+	config.PreventHeadlessDetection = job.PreventHeadlessDetection
+	config.PostLoadWaitSeconds = job.PostLoadWaitSeconds
+	if job.Proxy != "" {
+		config.UseTor = true
+		config.TorProxy = job.Proxy
+	}
+	return config
+}
+
+// The proxy through which requests are performed, empty if there is none
+func ProxyOf(config scraping.NodeConfig) string {
+	if config.UseTor {
+		return config.TorProxy
+	}
+	return ""
+}
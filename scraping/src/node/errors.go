@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"github.com/chromedp/chromedp"
+	"scraping"
+)
+
+// Chrome reports network errors as, e.g., "page load error net::ERR_NAME_NOT_RESOLVED"
+var netErrorRegexp = regexp.MustCompile(`net::(ERR_[A-Z0-9_]+)`)
+
+// The error code of a Chrome network error, such as ERR_CONNECTION_REFUSED
+func NetErrorCode(name string) scraping.ErrorCode {
+	switch name {
+	case "ERR_NAME_NOT_RESOLVED", "ERR_NAME_RESOLUTION_FAILED":
+		return scraping.ErrorNameNotResolved
+	case "ERR_CONNECTION_REFUSED":
+		return scraping.ErrorConnectionRefused
+	case "ERR_CONNECTION_RESET", "ERR_CONNECTION_CLOSED", "ERR_EMPTY_RESPONSE":
+		return scraping.ErrorConnectionReset
+	case "ERR_CONNECTION_TIMED_OUT", "ERR_TIMED_OUT":
+		return scraping.ErrorConnectionTimedOut
+	case "ERR_ADDRESS_UNREACHABLE", "ERR_INTERNET_DISCONNECTED", "ERR_NETWORK_CHANGED":
+		return scraping.ErrorAddressUnreachable
+	case "ERR_ABORTED":
+		return scraping.ErrorAborted
+	case "ERR_TOO_MANY_REDIRECTS":
+		return scraping.ErrorTooManyRedirects
+	}
+	switch {
+	case strings.HasPrefix(name, "ERR_CERT_"):
+		return scraping.ErrorCertInvalid
+	case strings.HasPrefix(name, "ERR_SSL_"):
+		return scraping.ErrorTLS
+	}
+	return scraping.ErrorNet
+}
+
+// The error code of an error that occurred while visiting a page. The
+// timeout is attributed to the navigation if the page did not load yet.
+func ErrorCodeOf(err error, loaded bool, crashed bool) scraping.ErrorCode {
+	if crashed || errors.Is(err, chromedp.ErrChannelClosed) {
+		return scraping.ErrorBrowserCrash
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		if loaded {
+			return scraping.ErrorPostLoadTimeout
+		}
+		return scraping.ErrorNavigationTimeout
+	}
+	if match := netErrorRegexp.FindStringSubmatch(err.Error()); match != nil {
+		return NetErrorCode(match[1])
+	}
+	return scraping.ErrorOther
+}
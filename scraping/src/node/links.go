@@ -0,0 +1,125 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"scraping"
+)
+
+// Number of links remembered by the unseen selection, which forgets all of them once reached
+const MAX_SEEN_LINKS = 1000000
+
+// The links selected so far by this node, by job, used by the unseen selection
+type SeenLinks struct {
+	lock sync.Mutex
+	links map[string]bool // By request key (see scraping.RequestKey)
+}
+
+var seenLinks = SeenLinks{links: make(map[string]bool)}
+
+func (s *SeenLinks) Seen(job string, link string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.links[scraping.RequestKey(job, link)]
+}
+
+func (s *SeenLinks) Add(job string, links []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.links) + len(links) > MAX_SEEN_LINKS {
+		s.links = make(map[string]bool)
+	}
+	for _, link := range links {
+		s.links[scraping.RequestKey(job, link)] = true
+	}
+}
+
+// The compiled link patterns, cached as the same patterns are used for every page
+var linkPatterns sync.Map // From the pattern to its *regexp.Regexp
+
+func compileLinkPattern(pattern string) *regexp.Regexp {
+	if compiled, present := linkPatterns.Load(pattern); present {
+		return compiled.(*regexp.Regexp)
+	}
+	// Patterns have been validated along with the settings
+	compiled := regexp.MustCompile("(?i)" + pattern)
+	linkPatterns.Store(pattern, compiled)
+	return compiled
+}
+
+// Whether the path of a link matches one of the patterns
+func matchesLinkPattern(link string, patterns []string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if compileLinkPattern(pattern).MatchString(parsed.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// The seed of the random selection of the links of a page: derived from the
+// seed of the settings and the URL of the page if there is one, so that the
+// same links are selected every time, and new otherwise. Never 0.
+func LinkSeedOf(settings scraping.NodeConfig, page string) int64 {
+	var seed int64
+	if settings.LinkSeed != 0 {
+		hash := fnv.New64a()
+		hash.Write([]byte(strconv.FormatInt(settings.LinkSeed, 10) + " " + page))
+		seed = int64(hash.Sum64())
+	} else {
+		seed = rand.Int63()
+	}
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
+
+// Select the links followed from a page among its links, following the link
+// selection of the settings. Returns the links and the seed with which they
+// have been selected at random, 0 if they were not.
+func SelectLinks(request scraping.Request, links []string, settings scraping.NodeConfig) ([]string, int64) {
+	// The same link may appear multiple times on a page
+	unique := make([]string, 0, len(links))
+	present := make(map[string]bool)
+	for _, link := range links {
+		if !present[link] {
+			present[link] = true
+			unique = append(unique, link)
+		}
+	}
+	count := min(settings.URLsToExtract, len(unique))
+	selected, seed := unique[:count], int64(0)
+	if settings.LinkSelection != scraping.LinkSelectionFirst && count < len(unique) {
+		preferred, others := make([]string, 0), make([]string, 0)
+		for _, link := range unique {
+			switch {
+			case settings.LinkSelection == scraping.LinkSelectionPatterns && matchesLinkPattern(link, settings.LinkPatterns):
+				preferred = append(preferred, link)
+			case settings.LinkSelection == scraping.LinkSelectionUnseen && !seenLinks.Seen(request.Job, link):
+				preferred = append(preferred, link)
+			default:
+				others = append(others, link)
+			}
+		}
+		seed = LinkSeedOf(settings, request.URL)
+		random := rand.New(rand.NewSource(seed))
+		for _, group := range [][]string{preferred, others} {
+			random.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+		}
+		selected = append(preferred, others...)[:count]
+	}
+	if settings.LinkSelection == scraping.LinkSelectionUnseen {
+		seenLinks.Add(request.Job, selected)
+	}
+	return selected, seed
+}
+
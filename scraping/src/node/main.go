@@ -14,34 +14,40 @@ import (
 	"net/rpc"
 	"math/rand"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/cdproto/debugger"
+	"github.com/chromedp/cdproto/inspector"
 	"github.com/chromedp/chromedp"
-	tld "github.com/jpillora/go-tld"
+	"golang.org/x/net/publicsuffix"
 	"scraping"
 )
 
-const (
-	NWORKERS = 4
-	URLS_TO_EXTRACT = 3
-	PREVENT_HEADLESS_DETECTION = false
-	USE_TOR = false
-	TIMEOUT_SECONDS = 35
-)
+const HEARTBEAT_SECONDS = 30
+
+// Time given to a tab beyond the page load and the post-load wait, to extract
+// the links (which has its own 2s timeout) and retrieve the WebAssembly modules
+const TAB_GRACE_SECONDS = 5
 
 type Config struct {
 	serverAddress string
 	myself scraping.Node
 	port string
+	settings scraping.NodeConfig // Settings loaded from the configuration file, environment and flags
 }
 
 type State struct {
 	config Config
-	batchChan chan []scraping.Request
+	batchChan chan scraping.Batch
 	shutdownChan chan bool
 	gracefulShutdownChan chan bool
-	chromeContext context.Context
+	chromeLock sync.Mutex // Protects the browsers
+	chromeContexts map[string]context.Context // One browser per proxy, the empty string denoting no proxy
+	progressLock sync.Mutex // Protects the progress of the current batch
+	progress scraping.Heartbeat
+	metrics NodeMetrics
 }
 var state State
 
@@ -50,7 +56,10 @@ type NodeServer int
 
 // Receive a new batch of requests
 func (t *NodeServer) Batch(args *scraping.Batch, reply *bool) error {
-	state.batchChan <- (*args).Requests
+	if err := scraping.CheckVersion((*args).Version); err != nil {
+		return err
+	}
+	state.batchChan <- *args
 	*reply = true
 	return nil
 }
@@ -64,11 +73,12 @@ func (t *NodeServer) Shutdown(args *bool, reply *bool) error {
 }
 
 func SetupSIGTERMHandler() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
 		fmt.Println("Shutting down upon request from the terminal...")
+		UpdateProgress(func(progress *scraping.Heartbeat) { progress.Draining = true })
 		state.gracefulShutdownChan <- true
 	}()
 }
@@ -78,6 +88,7 @@ func StartServer() {
 	nodeServer := new(NodeServer)
 	rpc.Register(nodeServer)
 	rpc.HandleHTTP()
+	http.Handle("/metrics", state.metrics.registry)
 	l, err := net.Listen("tcp", ":" + state.config.port)
 	if err != nil {
 		log.Fatalf("Cannot listen on port %s: %v", state.config.port, err)
@@ -92,21 +103,31 @@ func ExtractPort(url string) string {
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
-	if len(os.Args) != 3 {
-		log.Fatalf("Expected 2 argument, got %d", len(os.Args)-1)
+	settings, args, err := LoadConfig(os.Args)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if len(args) != 2 {
+		log.Fatalf("Expected 2 argument, got %d", len(args))
 	}
-	state.config.serverAddress = os.Args[1]
-	state.config.myself = scraping.Node{os.Args[2]}
-	state.config.port = ExtractPort(os.Args[2])
+	state.config.serverAddress = args[0]
+	state.config.myself = scraping.Node{URL: args[1]}
+	state.config.port = ExtractPort(args[1])
+	state.config.settings = settings
+	log.Printf("Configuration: %+v", settings)
 	// Allocate channels
-	state.batchChan = make(chan []scraping.Request, 0)
+	state.batchChan = make(chan scraping.Batch, 0)
 	state.shutdownChan = make(chan bool, 0)
 	state.gracefulShutdownChan = make(chan bool, 0)
-	SpawnChrome()
+	state.progress = scraping.Heartbeat{Version: scraping.ProtocolVersion, Node: state.config.myself, Workers: settings.Workers}
+	state.chromeContexts = make(map[string]context.Context)
+	state.metrics = NewNodeMetrics()
+	Browser(ProxyOf(settings))
 	StartServer()
 	go HandleBatches()
 	SetupSIGTERMHandler()
 	NotifyImReady()
+	go SendHeartbeats()
 	// Wait until termination
 	<- state.shutdownChan
 }
@@ -118,11 +139,43 @@ func NotifyImReady() {
 	if err != nil {
 		log.Fatalf("Cannot connect to server: %v", err)
 	}
-	err = client.Call("Server.NodeReady", state.config.myself, &reply)
-	if err != nil {
+	ready := scraping.Ready{URL: state.config.myself.URL, Version: scraping.ProtocolVersion, Config: &state.config.settings}
+	err = client.Call("Server.NodeReady", ready, &reply)
+	if scraping.IsIncompatible(err) {
+		log.Fatalf("The server runs another build: %v", err)
+	} else if err != nil {
 		log.Fatalf("Cannot connect to server: %v", err)
 	}
 }
+// Update the progress reported in heartbeats
+func UpdateProgress(update func(*scraping.Heartbeat)) {
+	state.progressLock.Lock()
+	defer state.progressLock.Unlock()
+	update(&state.progress)
+}
+
+// Periodically let the server know that we are alive, and how far we are in the current batch
+func SendHeartbeats() {
+	for {
+		<-time.After(HEARTBEAT_SECONDS * time.Second)
+		state.progressLock.Lock()
+		heartbeat := state.progress
+		state.progressLock.Unlock()
+		var reply bool
+		client, err := rpc.DialHTTP("tcp", state.config.serverAddress)
+		if err != nil {
+			// The server may be restarting, try again later
+			log.Printf("Cannot send heartbeat to server: %v", err)
+			continue
+		}
+		err = client.Call("Server.Heartbeat", heartbeat, &reply)
+		client.Close()
+		if err != nil {
+			log.Printf("Cannot send heartbeat to server: %v", err)
+		}
+	}
+}
+
 // Handle batches of requests
 func HandleBatches() {
 	for {
@@ -130,12 +183,22 @@ func HandleBatches() {
 		select {
 		case batch := <- state.batchChan:
 			// Perform the requests and send result to server
-			log.Printf("Received a new batch of %v requests\n", len(batch))
+			log.Printf("Received a new batch of %v requests\n", len(batch.Requests))
+			UpdateProgress(func(progress *scraping.Heartbeat) {
+				progress.BatchSize = len(batch.Requests)
+				progress.BatchDone = 0
+			})
 			start := time.Now()
-			results := PerformRequests(batch)
+			results := PerformRequests(batch.Requests, batch.Jobs)
+			results.BatchID = batch.ID
+			UpdateProgress(func(progress *scraping.Heartbeat) {
+				progress.BatchSize = 0
+				progress.BatchDone = 0
+			})
 			end := time.Now()
 			elapsed := end.Sub(start)
 			log.Printf("Performed all requests in %v\n", elapsed.String())
+			state.metrics.batchDurations.Observe(elapsed.Seconds())
 			SendResultsToServer(results)
 			if len(results.NotQueried) > 0 {
 				state.shutdownChan <- true // graceful shutdown has been requested
@@ -170,12 +233,16 @@ func WaitBetweenRequests(min int, max int) <- chan time.Time {
 	return time.After(time.Duration(min + (rand.Int() % (max - min))) * time.Millisecond)
 }
 
-// Performs all the requests from a given queue of requests
-func PerformRequests(queue []scraping.Request) scraping.BatchResult {
+// Performs all the requests from a given queue of requests, following the specs of their jobs
+func PerformRequests(queue []scraping.Request, jobs []scraping.JobSpec) scraping.BatchResult {
+	specs := make(map[string]scraping.JobSpec)
+	for _, job := range jobs {
+		specs[job.Name] = job
+	}
 	results := make([]scraping.Result, 0, len(queue))
 	requestChan := make(chan scraping.Request, 0)
 	finished := make(chan bool)
-	for i := 0; i < NWORKERS; i++ {
+	for i := 0; i < state.config.settings.Workers; i++ {
 		// Launch the worker
 		workerId := i
 		go func() {
@@ -188,7 +255,20 @@ func PerformRequests(queue []scraping.Request) scraping.BatchResult {
 					return; // Channel has been closed, stop the worker
 				} else {
 					// Perform the request and store the result
-					result, err := ExtractScripts(workerId, request)
+					UpdateProgress(func(progress *scraping.Heartbeat) { progress.BusyWorkers += 1 })
+					settings := state.config.settings
+					if job, present := specs[request.Job]; present {
+						settings = ApplyJob(settings, job)
+					}
+					start := time.Now()
+					result, err := ExtractScripts(workerId, request, settings)
+					if err == nil {
+						state.metrics.Visited(workerId, result, time.Now().Sub(start).Seconds())
+					}
+					UpdateProgress(func(progress *scraping.Heartbeat) {
+						progress.BusyWorkers -= 1
+						progress.BatchDone += 1
+					})
 					if err != nil {
 						state.gracefulShutdownChan <- true
 					} else {
@@ -206,7 +286,7 @@ func PerformRequests(queue []scraping.Request) scraping.BatchResult {
 		case <-state.gracefulShutdownChan:
 			// Send partial results
 			log.Printf("Asking for graceful shutdown")
-			return scraping.BatchResult{results, state.config.myself, queue[i:]}
+			return scraping.BatchResult{Version: scraping.ProtocolVersion, Results: results, Node: state.config.myself, NotQueried: queue[i:]}
 		case <-WaitBetweenRequests(250, 500):
 			requestChan <- request
 			log.Printf("Scheduled request %d/%d", i, len(queue))
@@ -216,11 +296,11 @@ func PerformRequests(queue []scraping.Request) scraping.BatchResult {
 	close(requestChan)
 	log.Println("Waiting for workers to finish")
 	// Wait for all workers to finish
-	for i := 0; i < NWORKERS; i++ {
+	for i := 0; i < state.config.settings.Workers; i++ {
 		<-finished
 	}
 	log.Println("Finished performing requests")
-	return scraping.BatchResult{results, state.config.myself, nil}
+	return scraping.BatchResult{Version: scraping.ProtocolVersion, Results: results, Node: state.config.myself}
 }
 
 // see: https://intoli.com/blog/not-possible-to-block-chrome-headless/
@@ -259,16 +339,28 @@ const script = `(function(w, n, wn) {
   );
 
 })(window, navigator, window.navigator);`
-func SpawnChrome() error {
+// The browser that performs requests through the given proxy, spawned if needed
+func Browser(proxy string) context.Context {
+	state.chromeLock.Lock()
+	defer state.chromeLock.Unlock()
+	ctx, present := state.chromeContexts[proxy]
+	if !present {
+		ctx = SpawnChrome(proxy)
+		state.chromeContexts[proxy] = ctx
+	}
+	return ctx
+}
+
+func SpawnChrome(proxy string) context.Context {
 	opts := chromedp.DefaultExecAllocatorOptions[:]
-	opts = append(opts, chromedp.UserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36"))
-	opts = append(opts, chromedp.WindowSize(1920, 1080))
+	opts = append(opts, chromedp.UserAgent(state.config.settings.UserAgent))
+	opts = append(opts, chromedp.WindowSize(state.config.settings.WindowWidth, state.config.settings.WindowHeight))
 	opts = append(opts, chromedp.NoFirstRun)
 	opts = append(opts, chromedp.NoDefaultBrowserCheck)
 	opts = append(opts, chromedp.Headless)
-	// Use a tor proxy to perform requests
-	if USE_TOR {
-		opts = append(opts, chromedp.ProxyServer("socks5://localhost:9050"))
+	// Use a proxy (e.g., tor) to perform requests
+	if proxy != "" {
+		opts = append(opts, chromedp.ProxyServer(proxy))
 	}
 	cx, _ := chromedp.NewExecAllocator(context.Background(), opts...)
 	// defer cancel()
@@ -276,24 +368,24 @@ func SpawnChrome() error {
 	ctx, _ := chromedp.NewContext(cx)
 	// defer cancel()
 
+	state.metrics.chromeStarts.Inc()
 	log.Printf("Allocating context")
 	// Allocate the context (actually runs the browser)
 	if err := chromedp.Run(ctx); err != nil {
 		log.Fatalf("Unexpected error in ExtractScripts when allocating context: %v\n", err)
 	}
-	state.chromeContext = ctx
-	return nil
+	return ctx
 }
 
-func ExtractScripts(worker int, request scraping.Request) (scraping.Result, error) {
+func ExtractScripts(worker int, request scraping.Request, settings scraping.NodeConfig) (result scraping.Result, err error) {
 	log.Printf("[worker-%d] Extracting scripts from %v\n", worker, request.URL)
-	result := scraping.Result{request.URL, false, false, false, make([]string, 0), make([]string, 0)}
+	result = scraping.Result{URL: request.URL, Job: request.Job, Scripts: make([]string, 0), URLs: make([]string, 0)}
 
 	// Create new tab
-	ctxTab, cancel := chromedp.NewContext(state.chromeContext)
+	ctxTab, cancel := chromedp.NewContext(Browser(ProxyOf(settings)))
 	defer cancel()
 
-	ctx, cancel := context.WithTimeout(ctxTab, (TIMEOUT_SECONDS+5) * time.Second)
+	ctx, cancel := context.WithTimeout(ctxTab, time.Duration(settings.TimeoutSeconds + settings.PostLoadWaitSeconds + TAB_GRACE_SECONDS) * time.Second)
 	defer cancel()
 
 	log.Printf("[worker-%d] Allocating tab", worker)
@@ -312,7 +404,7 @@ func ExtractScripts(worker int, request scraping.Request) (scraping.Result, erro
 	}
 
 	// Setup headless detection prevention mechanism
-	if PREVENT_HEADLESS_DETECTION {
+	if settings.PreventHeadlessDetection {
 		if err := chromedp.Run(ctx,
 			chromedp.ActionFunc(func(ctx context.Context) error {
 				_, err := page.AddScriptToEvaluateOnNewDocument(script).Do(ctx)
@@ -331,55 +423,81 @@ func ExtractScripts(worker int, request scraping.Request) (scraping.Result, erro
 
 	log.Printf("[worker-%d] Listen for EvenScriptParsed events", worker)
 	// Listen for EventScriptParsed events
+	var scriptsLock sync.Mutex
+	scriptsDone := false
+	capture := &ModuleCapture{}
+	// Wait for the bytecode of the modules before the tab is closed
+	defer func() {
+		scriptsLock.Lock()
+		scriptsDone = true // Ignore modules parsed from now on
+		scriptsLock.Unlock()
+		result.Modules = capture.Wait()
+	}()
+	var crashed atomic.Bool
 	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		if _, ok := ev.(*inspector.EventTargetCrashed); ok {
+			crashed.Store(true)
+		}
 		if ev, ok := ev.(*debugger.EventScriptParsed); ok {
-			if ev.ScriptLanguage == "WebAssembly" {
+			scriptsLock.Lock()
+			defer scriptsLock.Unlock()
+			if ev.ScriptLanguage == "WebAssembly" && !scriptsDone {
 				log.Printf("Script found: %v", ev.URL)
 				result.Scripts = append(result.Scripts, ev.URL)
+				capture.Capture(ctx, ev)
 			}
 		}
 	})
 
 	log.Printf("[worker-%d] Setup timeout", worker)
 	// Setup a timeout
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, TIMEOUT_SECONDS * time.Second)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, time.Duration(settings.TimeoutSeconds) * time.Second)
 	defer cancel()
 
 	log.Printf("[worker-%d] Visit the page", worker)
 	// Actually visits the page
-	var realurl string
-	if err := chromedp.Run(ctxWithTimeout,
+	loaded := false
+	response, err := chromedp.RunResponse(ctxWithTimeout,
 		chromedp.Navigate(request.URL),
 		chromedp.WaitReady("body"),
-		// Wait 5 extra seconds after page has loaded to ensure other scripts have loaded as well
-		chromedp.Sleep(5 * time.Second),
-		chromedp.Location(&realurl),
-	); err != nil {
-		if err == context.DeadlineExceeded {
-			log.Printf("[worker-%d] Deadline exceeded (timeout) when visiting: %v\n", worker, request.URL)
-			result.Timeout = true
-		} else if fmt.Sprintf("%v", err) == "page load error net::ERR_NAME_NOT_RESOLVED" { // Ugly, but I don't see how else to do it
-			log.Printf("[worker-%d] DNS error in ExtractScripts for %v: %v\n", worker, request.URL, err)
-			result.DNSError = true
-		} else {
-			log.Printf("[worker-%d] Unexpected error in ExtractScripts when visiting %v: %v\n", worker, request.URL, err)
-			result.Failure = true
+	)
+	if err == nil {
+		loaded = true
+		if response != nil {
+			result.HTTPStatus = int(response.Status)
+			if response.Status >= 400 {
+				log.Printf("[worker-%d] HTTP status %d when visiting %v\n", worker, response.Status, request.URL)
+				result.SetError(scraping.ErrorHTTP, response.StatusText)
+			}
 		}
+	}
+	var realurl string
+	if err == nil {
+		err = chromedp.Run(ctxWithTimeout,
+			// Wait a few extra seconds after page has loaded to ensure other scripts have loaded as well
+			chromedp.Sleep(time.Duration(settings.PostLoadWaitSeconds) * time.Second),
+			chromedp.Location(&realurl),
+		)
+	}
+	if err != nil {
+		code := ErrorCodeOf(err, loaded, crashed.Load())
+		log.Printf("[worker-%d] Error %s in ExtractScripts when visiting %v: %v\n", worker, code, request.URL, err)
+		result.SetError(code, err.Error())
 		return result, nil
 	}
 
 	log.Printf("[worker-%d] Extract URLs", worker)
-	if request.TopLevel {
+	if request.Depth < settings.MaxDepth {
 		realURL, err := url.Parse(realurl)
 		if err != nil {
 			log.Printf("[worker-%d] Unexpected error in ExtractScripts when retrieving url of %v: %v\n", worker, request.URL, err)
-			result.Failure = true
+			result.SetError(scraping.ErrorExtraction, err.Error())
 			return result, nil
 		}
-		realURLTLD, err := tld.Parse(realurl)
+		realURLDomain, err := publicsuffix.EffectiveTLDPlusOne(realURL.Hostname())
 		if err != nil {
 			log.Printf("[worker-%d] Unexpected error in ExtractScripts when parsing TLD of %v: %v\n", worker, request.URL, err)
-			result.Failure = true
+			result.SetError(scraping.ErrorExtraction, err.Error())
 			return result, nil
 		}
 		var nodes []*cdp.Node
@@ -389,7 +507,7 @@ func ExtractScripts(worker int, request scraping.Request) (scraping.Result, erro
 			chromedp.Nodes("a", &nodes, chromedp.ByQueryAll, chromedp.AtLeast(0)),
 		); err != nil {
 			log.Printf("[worker-%d] Unexpected error in ExtractScripts when extracting links of %v: %v\n", worker, request.URL, err)
-			result.Failure = true
+			result.SetError(scraping.ErrorExtraction, err.Error())
 			return result, nil
 		}
 
@@ -408,25 +526,19 @@ func ExtractScripts(worker int, request scraping.Request) (scraping.Result, erro
 					parsed.Scheme = realURL.Scheme
 					parsed.Host = realURL.Host
 				}
-				tld, err := tld.Parse(parsed.String())
+				domain, err := publicsuffix.EffectiveTLDPlusOne(parsed.Hostname())
 				if err != nil {
 					continue
 				}
-				if tld.Domain == realURLTLD.Domain && tld.TLD == realURLTLD.TLD {
+				if domain == realURLDomain {
 					// Only add the URL to our list if it is to the same domain
 					urls = append(urls, parsed.String())
 				}
 			}
 		}
-		// Select 3 URLs at random
-		if len(urls) <= URLS_TO_EXTRACT {
-			// Did not parse more than 3 URLS, return everything parsed instead of selecting
-			result.URLs = urls
-		} else {
-			vs := rand.Perm(len(urls))
-			for _, i := range vs[:URLS_TO_EXTRACT] {
-				result.URLs = append(result.URLs, urls[i])
-			}
+		result.URLs, result.LinkSeed = SelectLinks(request, urls, settings)
+		if result.LinkSeed != 0 {
+			log.Printf("[worker-%d] Selected %d links on %d of %v with seed %d", worker, len(result.URLs), len(urls), request.URL, result.LinkSeed)
 		}
 	}
 	log.Printf("[worker-%d] Finished extracting scripts from %v", worker, request.URL)
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+	"metrics"
+	"scraping"
+)
+
+// Upper bounds of the buckets of the durations of page visits, in seconds
+var pageDurationBuckets = []float64{1, 2, 5, 10, 15, 20, 30, 40, 60, 90}
+
+// Upper bounds of the buckets of the durations of batches, in seconds
+var batchDurationBuckets = []float64{30, 60, 120, 300, 600, 900, 1200, 1800, 3600}
+
+// The metrics of the node, served on /metrics
+type NodeMetrics struct {
+	registry *metrics.Registry
+	pages *metrics.Counter
+	pageDurations *metrics.Histogram
+	workerBusy *metrics.Counter
+	chromeStarts *metrics.Counter // Every browser spawned, including the first one of each proxy (browsers are never restarted)
+	batchDurations *metrics.Histogram
+}
+
+func NewNodeMetrics() NodeMetrics {
+	registry := metrics.NewRegistry()
+	m := NodeMetrics{
+		registry: registry,
+		pages: registry.Counter("scraping_node_pages_total", "Pages visited, by job and outcome (error code, scripts or no_scripts)", "job", "outcome"),
+		pageDurations: registry.Histogram("scraping_node_page_duration_seconds", "Time spent visiting a page, by outcome", pageDurationBuckets, "outcome"),
+		workerBusy: registry.Counter("scraping_node_worker_busy_seconds_total", "Time each worker spent visiting pages", "worker"),
+		chromeStarts: registry.Counter("scraping_node_chrome_starts_total", "Browsers started, counting every start and not only restarts"),
+		batchDurations: registry.Histogram("scraping_node_batch_duration_seconds", "Time spent performing a batch", batchDurationBuckets),
+	}
+	progress := func(value func(progress scraping.Heartbeat) int) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			state.progressLock.Lock()
+			defer state.progressLock.Unlock()
+			return []metrics.Sample{{Value: float64(value(state.progress))}}
+		}
+	}
+	registry.GaugeFunc("scraping_node_workers", "Number of workers of the node", progress(func(p scraping.Heartbeat) int { return p.Workers }))
+	registry.GaugeFunc("scraping_node_busy_workers", "Workers currently visiting a page", progress(func(p scraping.Heartbeat) int { return p.BusyWorkers }))
+	registry.GaugeFunc("scraping_node_batch_remaining_requests", "Requests of the current batch that have not been performed yet", progress(func(p scraping.Heartbeat) int { return p.BatchSize - p.BatchDone }))
+	return m
+}
+
+// The outcome of a page visit, used as label: the error code if the page
+// could not be scraped, and whether scripts have been found otherwise
+func OutcomeOf(result scraping.Result) string {
+	switch {
+	case result.Error != scraping.ErrorNone && result.Error != scraping.ErrorHTTP:
+		return string(result.Error)
+	case len(result.Scripts) > 0:
+		return "scripts"
+	}
+	return "no_scripts"
+}
+
+// Record a page visit performed by a worker
+func (m NodeMetrics) Visited(worker int, result scraping.Result, seconds float64) {
+	outcome := OutcomeOf(result)
+	m.pages.Inc(result.Job, outcome)
+	m.pageDurations.Observe(seconds, outcome)
+	m.workerBusy.Add(seconds, strconv.Itoa(worker))
+}
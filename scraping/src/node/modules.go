@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/rpc"
+	"sync"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/debugger"
+	"github.com/chromedp/chromedp"
+	"scraping"
+)
+
+// Hashes of the blobs that the server is known to have
+var uploaded = struct {
+	sync.Mutex
+	hashes map[string]bool
+}{hashes: make(map[string]bool)}
+
+// Retrieves the bytecode of the WebAssembly modules of a page while it is
+// being visited, along with the JavaScript that instantiated them
+type ModuleCapture struct {
+	lock sync.Mutex
+	wg sync.WaitGroup
+	modules []scraping.Module
+}
+
+// Retrieve the bytecode of a module that has just been parsed, and the source
+// of the script at the top of the stack when it was parsed (its loader). This
+// does not block, as it is called from the event listener of the tab.
+func (m *ModuleCapture) Capture(ctx context.Context, ev *debugger.EventScriptParsed) {
+	m.lock.Lock()
+	index := len(m.modules)
+	m.modules = append(m.modules, scraping.Module{URL: ev.URL})
+	m.lock.Unlock()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		c := chromedp.FromContext(ctx)
+		_, bytecode, err := debugger.GetScriptSource(ev.ScriptID).Do(cdp.WithExecutor(ctx, c.Target))
+		if err != nil {
+			log.Printf("Cannot retrieve bytecode of %v: %v", ev.URL, err)
+			return
+		}
+		hash := HashOf(bytecode)
+		m.lock.Lock()
+		m.modules[index].Hash = hash
+		m.modules[index].Size = len(bytecode)
+		m.lock.Unlock()
+		UploadBlob(scraping.BlobWasm, hash, bytecode)
+		if ev.StackTrace == nil || len(ev.StackTrace.CallFrames) == 0 {
+			return
+		}
+		frame := ev.StackTrace.CallFrames[0]
+		source, _, err := debugger.GetScriptSource(frame.ScriptID).Do(cdp.WithExecutor(ctx, c.Target))
+		if err != nil || source == "" {
+			log.Printf("Cannot retrieve the loader of %v: %v", ev.URL, err)
+			return
+		}
+		loader := HashOf([]byte(source))
+		m.lock.Lock()
+		m.modules[index].Loader = loader
+		m.modules[index].LoaderURL = frame.URL
+		m.lock.Unlock()
+		UploadBlob(scraping.BlobJS, loader, []byte(source))
+	}()
+}
+
+// Wait for all bytecode and loader retrievals to finish, and return the modules found
+func (m *ModuleCapture) Wait() []scraping.Module {
+	m.wg.Wait()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.modules
+}
+
+func HashOf(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// Send a blob (see scraping.BlobWasm and scraping.BlobJS) to the server, unless the server already has it
+func UploadBlob(kind string, hash string, data []byte) {
+	uploaded.Lock()
+	done := uploaded.hashes[hash]
+	uploaded.Unlock()
+	if done {
+		return
+	}
+	client, err := rpc.DialHTTP("tcp", state.config.serverAddress)
+	if err != nil {
+		log.Printf("Cannot upload %s blob %s: %v", kind, hash, err)
+		return
+	}
+	defer client.Close()
+	var present bool
+	err = client.Call("Server.HasBlob", hash, &present)
+	if err != nil {
+		log.Printf("Cannot check if the server has %s blob %s: %v", kind, hash, err)
+		return
+	}
+	if !present {
+		var reply bool
+		err = client.Call("Server.UploadBlob", scraping.BlobUpload{Node: state.config.myself, Kind: kind, Hash: hash, Data: data}, &reply)
+	}
+	if err != nil {
+		log.Printf("Cannot upload %s blob %s: %v", kind, hash, err)
+		return
+	}
+	uploaded.Lock()
+	uploaded.hashes[hash] = true
+	uploaded.Unlock()
+}
@@ -0,0 +1,331 @@
+// Command reschedule reads the results of previous scrapings, as stored by
+// the jsonl and sqlite sinks of the coordinator, and writes a job with the
+// URLs to scrape again: the top level URLs that have never been scraped, and
+// the other pages whose last attempt failed with a transient error. The job
+// is a JSON file that can be given to the coordinator with -job-file, or
+// submitted with scrapectl submit -file.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"scraping"
+)
+
+const usage = `Usage: %s [flags] <data directories or result files...>
+
+Results are read from the results.jsonl and results.db files of the data
+directories (and of their jobs), or from the result files given directly.
+
+`
+
+// A result read from the history, with the fields needed to reschedule its URL
+type Record struct {
+	scraping.Result
+	TopLevel bool
+	Parent string
+	Depth int
+	Received time.Time
+}
+
+// Whether the page has been scraped, possibly with an HTTP error status
+func (r Record) Scraped() bool {
+	return r.Code() == scraping.ErrorNone || r.Code() == scraping.ErrorHTTP
+}
+
+// The error code of the result. Results stored before error codes existed only
+// have the Timeout, DNSError and Failure flags, from which the code is guessed.
+func (r Record) Code() scraping.ErrorCode {
+	switch {
+	case r.Error != scraping.ErrorNone:
+		return r.Error
+	case r.Timeout:
+		return scraping.ErrorNavigationTimeout
+	case r.DNSError:
+		return scraping.ErrorNameNotResolved
+	case r.Failure:
+		return scraping.ErrorOther
+	}
+	return scraping.ErrorNone
+}
+
+// What is known about an URL
+type History struct {
+	URL string
+	TopLevel bool
+	Parent string
+	Depth int
+	Attempts int
+	Scraped bool // Whether any attempt succeeded
+	LastError scraping.ErrorCode // The error of the last attempt
+	Last time.Time // When the last result was received
+}
+
+// Which results are considered and which URLs are rescheduled
+type Filter struct {
+	job string // Only consider the results of this job, if not empty
+	since time.Time // Only consider the results received after, if not zero
+	until time.Time // Only consider the results received before, if not zero
+	maxAttempts int // Do not reschedule URLs attempted at least that many times, 0 for no limit
+	errors map[scraping.ErrorCode]bool // The errors for which pages that are not top level are rescheduled, nil for all
+}
+
+func (f Filter) Keep(record Record) bool {
+	if f.job != "" && record.Job != f.job {
+		return false
+	}
+	if !f.since.IsZero() && record.Received.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !record.Received.Before(f.until) {
+		return false
+	}
+	return true
+}
+
+// Whether an URL should be scraped again
+func (f Filter) Reschedule(history *History) bool {
+	if history.Scraped {
+		return false
+	}
+	if f.maxAttempts > 0 && history.Attempts >= f.maxAttempts {
+		return false
+	}
+	// Top level URLs are rescheduled whatever the error, as the links of their page are needed
+	return history.TopLevel || f.errors == nil || f.errors[history.LastError]
+}
+
+// The history of all URLs read so far
+type Histories map[string]*History
+
+func (h Histories) Get(url string) *History {
+	if h[url] == nil {
+		h[url] = &History{URL: url}
+	}
+	return h[url]
+}
+
+func (h Histories) Add(record Record) {
+	history := h.Get(record.URL)
+	history.Attempts += 1
+	history.TopLevel = history.TopLevel || record.TopLevel
+	if record.Parent != "" {
+		history.Parent = record.Parent
+		history.Depth = record.Depth
+	}
+	if record.Scraped() {
+		history.Scraped = true
+	}
+	if !record.Received.Before(history.Last) {
+		history.Last = record.Received
+		history.LastError = record.Code()
+	}
+}
+
+// Read the results of a jsonl sink
+func ReadJSONL(path string, filter Filter, histories Histories) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64 * 1024), 16 * 1024 * 1024)
+	read := 0
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// The coordinator may have been stopped while writing the last line
+			log.Printf("Ignoring line %d of %s: %v", line, path, err)
+			continue
+		}
+		if filter.Keep(record) {
+			histories.Add(record)
+			read += 1
+		}
+	}
+	return read, scanner.Err()
+}
+
+// The result files of the given paths: files are taken as they are, and
+// directories are searched for results.jsonl and results.db files. When a
+// directory holds both, only results.jsonl is read, as they hold the same results.
+func ResultFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			switch entry.Name() {
+			case "results.jsonl":
+				files = append(files, file)
+			case "results.db":
+				if _, err := os.Stat(filepath.Join(filepath.Dir(file), "results.jsonl")); os.IsNotExist(err) {
+					files = append(files, file)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Parse a date given as 2006-01-02 or in RFC 3339, zero if empty
+func ParseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", date, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, date)
+}
+
+// Read the lines of a file, ignoring empty ones
+func ReadLines(path string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Cannot read %s: %v", path, err)
+	}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func main() {
+	name := flag.String("name", "reschedule", "name of the job to write")
+	priority := flag.Int("priority", 0, "priority of the job to write")
+	output := flag.String("o", "", "file in which the job is written, stdout if empty")
+	urls := flag.String("urls", "", "top level URLs that were to be scraped (e.g., urls.txt), those without result being rescheduled as well")
+	job := flag.String("job", "", "only consider the results of this job")
+	since := flag.String("since", "", "only consider the results received from this date (2006-01-02 or RFC 3339)")
+	until := flag.String("until", "", "only consider the results received before this date (2006-01-02 or RFC 3339)")
+	maxAttempts := flag.Int("max-attempts", 0, "do not reschedule URLs that have been attempted at least this many times, 0 for no limit")
+	errors := flag.String("errors", "transient", "comma-separated error codes for which pages that are not top level are rescheduled, transient for the errors that may not happen again, or all")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	filter := Filter{job: *job, maxAttempts: *maxAttempts}
+	var err error
+	if filter.since, err = ParseDate(*since); err != nil {
+		log.Fatalf("Invalid date for -since: %v", err)
+	}
+	if filter.until, err = ParseDate(*until); err != nil {
+		log.Fatalf("Invalid date for -until: %v", err)
+	}
+	if *errors != "all" {
+		filter.errors = make(map[scraping.ErrorCode]bool)
+		for _, code := range strings.Split(*errors, ",") {
+			code = strings.TrimSpace(code)
+			if code == "transient" {
+				for _, transient := range scraping.TransientErrorCodes {
+					filter.errors[transient] = true
+				}
+				continue
+			}
+			known := false
+			for _, other := range scraping.ErrorCodes {
+				known = known || other == scraping.ErrorCode(code)
+			}
+			if !known {
+				log.Fatalf("Unknown error code %q", code)
+			}
+			filter.errors[scraping.ErrorCode(code)] = true
+		}
+	}
+
+	files, err := ResultFiles(flag.Args())
+	if err != nil {
+		log.Fatalf("Cannot find results: %v", err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("No result file found in %s", strings.Join(flag.Args(), ", "))
+	}
+	histories := make(Histories)
+	for _, file := range files {
+		var read int
+		if strings.HasSuffix(file, ".db") {
+			read, err = ReadSQLite(file, filter, histories)
+		} else {
+			read, err = ReadJSONL(file, filter, histories)
+		}
+		if err != nil {
+			log.Fatalf("Cannot read results from %s: %v", file, err)
+		}
+		log.Printf("Read %d results from %s", read, file)
+	}
+	if *urls != "" {
+		for _, url := range ReadLines(*urls) {
+			histories.Get(url).TopLevel = true
+		}
+	}
+
+	submission := scraping.JobSubmission{Name: *name, Priority: *priority, Requests: make([]scraping.SubmittedRequest, 0)}
+	topLevel, pages, skipped := 0, 0, 0
+	for _, history := range histories {
+		if !filter.Reschedule(history) {
+			if !history.Scraped {
+				skipped += 1
+			}
+			continue
+		}
+		request := scraping.SubmittedRequest{URL: history.URL, TopLevel: history.TopLevel, Attempts: history.Attempts, LastError: history.LastError}
+		if history.TopLevel {
+			topLevel += 1
+		} else {
+			request.Parent = history.Parent
+			request.Depth = history.Depth
+			pages += 1
+		}
+		submission.Requests = append(submission.Requests, request)
+	}
+	sort.Slice(submission.Requests, func(i, j int) bool {
+		return submission.Requests[i].URL < submission.Requests[j].URL
+	})
+	content, err := json.MarshalIndent(submission, "", "  ")
+	if err != nil {
+		log.Fatalf("Cannot encode job: %v", err)
+	}
+	content = append(content, '\n')
+	if *output == "" {
+		os.Stdout.Write(content)
+	} else if err := os.WriteFile(*output, content, 0644); err != nil {
+		log.Fatalf("Cannot write job: %v", err)
+	}
+	log.Printf("Rescheduled %d top level URLs and %d other pages, %d failed URLs not rescheduled", topLevel, pages, skipped)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	_ "modernc.org/sqlite"
+	"scraping"
+)
+
+// Read the results of an sqlite sink
+func ReadSQLite(path string, filter Filter, histories Histories) (int, error) {
+	db, err := sql.Open("sqlite", "file:" + path + "?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var tables int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'results'").Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		// The database has been created, but no result has been stored yet
+		return 0, nil
+	}
+	rows, err := db.Query("SELECT url, top_level, parent, timeout, dns_error, failure, received, job, error, depth FROM results ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	read := 0
+	for rows.Next() {
+		var record Record
+		var code string
+		if err := rows.Scan(&record.URL, &record.TopLevel, &record.Parent, &record.Timeout, &record.DNSError, &record.Failure, &record.Received, &record.Job, &code, &record.Depth); err != nil {
+			return read, err
+		}
+		record.Error = scraping.ErrorCode(code)
+		if filter.Keep(record) {
+			histories.Add(record)
+			read += 1
+		}
+	}
+	return read, rows.Err()
+}
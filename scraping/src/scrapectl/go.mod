@@ -0,0 +1,7 @@
+module scrapectl
+
+go 1.26.0
+
+require scraping v0.0.0
+
+replace scraping => ../scraping
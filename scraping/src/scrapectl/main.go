@@ -0,0 +1,222 @@
+// Command scrapectl controls a running coordinator over its RPC endpoint:
+// it shows the progress of the jobs and the state of the nodes, pauses and
+// resumes the dispatch of batches, drains nodes, adds URLs and jobs, and
+// shuts the scraping down.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/rpc"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"scraping"
+)
+
+const usage = `Usage: %s [-server address] <command> [arguments]
+
+Commands:
+  status                     progress of the jobs and state of the nodes
+  pause                      stop dispatching batches
+  resume                     dispatch batches again
+  drain <node>               stop sending batches to a node once its current batch is done
+  add [-job name] [urls...]  add top level URLs to a job, read from stdin if none is given
+  submit [-priority n] <name> [urls...]
+                             start a new job, with the URLs read from stdin if none is given
+  submit [-priority n] -file job.json [name]
+                             start the job of a JSON file, such as written by reschedule
+  shutdown                   terminate the nodes and the coordinator
+
+`
+
+func Connect(server string) *rpc.Client {
+	client, err := rpc.DialHTTP("tcp", server)
+	if err != nil {
+		log.Fatalf("Cannot connect to the coordinator at %s: %v", server, err)
+	}
+	return client
+}
+
+func Call(client *rpc.Client, method string, args interface{}, reply interface{}) {
+	if err := client.Call("Server." + method, args, reply); err != nil {
+		log.Fatalf("%s failed: %v", method, err)
+	}
+}
+
+// The URLs given as arguments, or read from stdin, one per line, if there is none
+func ReadURLs(args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+	urls := make([]string, 0)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if url := strings.TrimSpace(scanner.Text()); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Cannot read URLs: %v", err)
+	}
+	return urls
+}
+
+// Read a job from a JSON file
+func ReadJob(path string) scraping.JobSubmission {
+	var submission scraping.JobSubmission
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Cannot read job: %v", err)
+	}
+	if err := json.Unmarshal(content, &submission); err != nil {
+		log.Fatalf("Invalid job in %s: %v", path, err)
+	}
+	return submission
+}
+
+func ago(t time.Time) string {
+	return time.Since(t).Round(time.Second).String()
+}
+
+func PrintStatus(status scraping.Status) {
+	var total scraping.JobStatus
+	for _, job := range status.Jobs {
+		total.Queued += job.Queued
+		total.Delayed += job.Delayed
+		total.Checking += job.Checking
+		total.Retries += job.Retries
+		total.Skipped += job.Skipped
+		total.Duplicates += job.Duplicates
+		total.InFlight += job.InFlight
+		total.URLsToRequest += job.URLsToRequest
+		total.Scraped += job.Scraped
+		total.Scripts += job.Scripts
+		total.Failures += job.Failures
+		total.DNSErrors += job.DNSErrors
+		total.Timeouts += job.Timeouts
+		for code, count := range job.Errors {
+			if total.Errors == nil {
+				total.Errors = make(map[scraping.ErrorCode]int)
+			}
+			total.Errors[code] += count
+		}
+	}
+	uptime := time.Since(status.Started)
+	dispatch := "running"
+	if status.Paused {
+		dispatch = "paused"
+	}
+	fmt.Printf("Coordinator up for %s, dispatch %s (protocol version %d)\n", uptime.Round(time.Second).String(), dispatch, status.Version)
+	fmt.Printf("Scraped %d URLs on %d [%.2f URL/s], %d scripts found, %d failures, %d DNS errors, %d timeouts\n",
+		total.Scraped, total.URLsToRequest, float64(total.Scraped) / uptime.Seconds(), total.Scripts, total.Failures, total.DNSErrors, total.Timeouts)
+	if len(total.Errors) > 0 {
+		codes := make([]string, 0, len(total.Errors))
+		for code := range total.Errors {
+			codes = append(codes, string(code))
+		}
+		sort.Strings(codes)
+		errors := make([]string, len(codes))
+		for i, code := range codes {
+			errors[i] = fmt.Sprintf("%s %d", code, total.Errors[scraping.ErrorCode(code)])
+		}
+		fmt.Printf("Errors: %s\n", strings.Join(errors, ", "))
+	}
+	fmt.Printf("%d requests queued, %d waiting to be checked, %d waiting to be retried (%d retries so far), %d batches in flight, %d links disallowed by robots.txt, %d duplicate URLs left out\n\n", total.Queued, total.Checking, total.Delayed, total.Retries, total.InFlight, total.Skipped, total.Duplicates)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tPRIORITY\tSCRAPED\tTO SCRAPE\tQUEUED\tCHECKING\tRETRYING\tSKIPPED\tIN FLIGHT\tSCRIPTS\tFAILURES\tDNS ERRORS\tTIMEOUTS\tDONE")
+	for _, job := range status.Jobs {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%t\n", job.Name, job.Priority, job.Scraped, job.URLsToRequest, job.Queued, job.Checking, job.Delayed, job.Skipped, job.InFlight, job.Scripts, job.Failures, job.DNSErrors, job.Timeouts, job.Done)
+	}
+	w.Flush()
+	fmt.Println()
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTATE\tBATCHES DONE\tBUSY WORKERS\tCURRENT BATCH\tLAST SEEN")
+	for _, node := range status.Nodes {
+		batch := "-"
+		if node.Heartbeat.BatchSize > 0 {
+			batch = fmt.Sprintf("%d/%d", node.Heartbeat.BatchDone, node.Heartbeat.BatchSize)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d/%d\t%s\t%s ago\n", node.URL, node.State, node.BatchesDone, node.Heartbeat.BusyWorkers, node.Heartbeat.Workers, batch, ago(node.LastSeen))
+	}
+	w.Flush()
+}
+
+func main() {
+	server := flag.String("server", "127.0.0.1:6345", "address of the coordinator")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+	client := Connect(*server)
+	defer client.Close()
+	var ok bool
+	switch command {
+	case "status":
+		var status scraping.Status
+		Call(client, "Status", true, &status)
+		PrintStatus(status)
+	case "pause":
+		Call(client, "Pause", true, &ok)
+		fmt.Println("Dispatch paused")
+	case "resume":
+		Call(client, "Resume", true, &ok)
+		fmt.Println("Dispatch resumed")
+	case "drain":
+		if len(args) != 1 {
+			log.Fatalf("Expected the address of the node to drain")
+		}
+		Call(client, "Drain", scraping.Node{URL: args[0]}, &ok)
+		fmt.Printf("Node %s is draining\n", args[0])
+	case "add":
+		flags := flag.NewFlagSet("add", flag.ExitOnError)
+		job := flags.String("job", "default", "job to which the URLs are added")
+		flags.Parse(args)
+		var added int
+		Call(client, "AddURLs", scraping.URLSubmission{Job: *job, URLs: ReadURLs(flags.Args())}, &added)
+		fmt.Printf("Added %d URLs to job %s\n", added, *job)
+	case "submit":
+		flags := flag.NewFlagSet("submit", flag.ExitOnError)
+		priority := flags.Int("priority", 0, "batches of jobs with a higher priority are dispatched first")
+		file := flags.String("file", "", "JSON file with the job to submit, whose name and priority can be overridden")
+		flags.Parse(args)
+		var submission scraping.JobSubmission
+		if *file != "" {
+			submission = ReadJob(*file)
+			if flags.NArg() > 0 {
+				submission.Name = flags.Arg(0)
+			}
+			flags.Visit(func(f *flag.Flag) {
+				if f.Name == "priority" {
+					submission.Priority = *priority
+				}
+			})
+		} else {
+			if flags.NArg() < 1 {
+				log.Fatalf("Expected the name of the job")
+			}
+			submission = scraping.JobSubmission{Name: flags.Arg(0), Priority: *priority, URLs: ReadURLs(flags.Args()[1:])}
+		}
+		Call(client, "SubmitJob", submission, &ok)
+		fmt.Printf("Job %s submitted with %d URLs\n", submission.Name, len(submission.URLs) + len(submission.Requests))
+	case "shutdown":
+		Call(client, "Shutdown", true, &ok)
+		fmt.Println("Coordinator shutting down")
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
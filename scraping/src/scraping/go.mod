@@ -0,0 +1,3 @@
+module scraping
+
+go 1.21
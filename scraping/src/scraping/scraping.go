@@ -0,0 +1,393 @@
+// Package scraping defines the messages exchanged between the coordinator
+// and the scraping nodes over net/rpc (HTTP transport, gob encoding).
+//
+// The coordinator exposes the following methods, called by the nodes:
+//   - Server.NodeReady(Ready, *bool): the node is ready to receive a batch
+//   - Server.Heartbeat(Heartbeat, *bool): periodic progress report of a node
+//   - Server.Results(BatchResult, *bool): results of a batch
+//   - Server.HasBlob(string, *bool): whether a blob with the given hash is stored
+//   - Server.UploadBlob(BlobUpload, *bool): content of a blob (e.g., WebAssembly bytecode)
+//   - Server.ListBlobs(string, *[]BlobInfo): the stored blobs of a kind (all if empty)
+//   - Server.SubmitJob(JobSubmission, *bool): start a new job
+//   - Server.ListJobs(bool, *[]JobStatus): the jobs of the coordinator and their progress
+//
+// It also exposes the following methods, called by administration tools:
+//   - Server.Status(bool, *Status): the progress of the jobs and the state of the nodes
+//   - Server.Pause(bool, *bool): stop dispatching batches
+//   - Server.Resume(bool, *bool): dispatch batches again
+//   - Server.Drain(Node, *bool): stop sending batches to a node once its current batch is done
+//   - Server.AddURLs(URLSubmission, *int): queue top level URLs in an existing job
+//   - Server.Shutdown(bool, *bool): terminate the nodes and the coordinator
+//
+// The nodes expose the following methods, called by the coordinator:
+//   - NodeServer.Batch(Batch, *bool): a batch of requests to perform
+//   - NodeServer.Shutdown(bool, *bool): the node should terminate
+//
+// Ready, Batch, BatchResult and Heartbeat carry the version of the protocol of
+// their sender, and are rejected with an IncompatibleVersion error if it
+// differs from that of their receiver. ProtocolVersion must be increased whenever a change to these
+// messages changes their meaning, as gob silently ignores unknown fields and
+// leaves missing ones to their zero value. A version of 0 denotes a build that
+// predates versioning, whose messages are those of version 1 without the
+// Version and batch identifier fields. Version 2 introduced jobs, which
+// builds of earlier versions would silently ignore. Version 3 introduced the
+// depth of requests, from which nodes decide whether to extract links.
+package scraping
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The version of the protocol implemented by this package
+const ProtocolVersion = 3
+
+// A scraping node, identified by the address on which it listens
+type Node struct {
+	URL string
+}
+
+// Settings of a node, reported to the coordinator so that results can be
+// attributed to the settings that produced them
+type NodeConfig struct {
+	Workers int `toml:"workers"` // Number of pages scraped in parallel
+	URLsToExtract int `toml:"urls_to_extract"` // Number of links followed from each page
+	MaxDepth int `toml:"max_depth"` // Links are followed up to this many links away from top level pages
+	LinkSelection string `toml:"link_selection"` // How the links followed are selected, one of LinkSelections
+	LinkPatterns []string `toml:"link_patterns"` // Regular expressions matched against the path of links ignoring case, preferred by the patterns selection
+	LinkSeed int64 `toml:"link_seed"` // Seed from which the seed of the random selection of each page is derived, 0 for a new seed for each page
+	PreventHeadlessDetection bool `toml:"prevent_headless_detection"`
+	UseTor bool `toml:"use_tor"`
+	TorProxy string `toml:"tor_proxy"` // Proxy through which requests are performed when UseTor is set
+	TimeoutSeconds int `toml:"timeout_seconds"` // Time given to a page to load
+	PostLoadWaitSeconds int `toml:"post_load_wait_seconds"` // Time to wait for scripts after the page has loaded
+	UserAgent string `toml:"user_agent"`
+	WindowWidth int `toml:"window_width"`
+	WindowHeight int `toml:"window_height"`
+}
+
+// A new job to run, submitted to the coordinator
+type JobSubmission struct {
+	Name string // Unique name of the job, also the name of the directory of its results
+	Spec *JobSpec // How pages are scraped, nil to let nodes use their own settings
+	Priority int // Batches of jobs with a higher priority are dispatched first
+	URLs []string // The top level URLs to scrape
+	Requests []SubmittedRequest `json:",omitempty"` // Other requests to scrape, such as pages that failed in a previous scraping
+}
+
+// A request of a submitted job, given with the context in which it has been found
+type SubmittedRequest struct {
+	URL string
+	TopLevel bool // Whether the URL is one of the URLs to scrape, as opposed to a link found on a page
+	Parent string `json:",omitempty"` // The page on which the URL has been found, empty for top level URLs
+	Depth int `json:",omitempty"` // Number of links followed from a top level page to reach the URL, 0 for top level URLs
+	Attempts int `json:",omitempty"` // How many times the URL has been requested before, for information
+	LastError ErrorCode `json:",omitempty"` // The error of the last attempt, for information
+}
+
+// The progress of a job
+type JobStatus struct {
+	Name string
+	Spec *JobSpec
+	Priority int
+	Created time.Time
+	Done bool // Whether all requests of the job have been performed
+	Queued int // Requests waiting to be dispatched
+	Delayed int // Requests waiting to be retried after an error
+	Checking int // Requests waiting to be checked before being dispatched (host name resolved, robots.txt looked up)
+	InFlight int // Batches dispatched to nodes
+	URLsToRequest int
+	Scraped int
+	Scripts int // Pages on which WebAssembly has been found
+	Failures int
+	DNSErrors int
+	Timeouts int
+	Retries int // Requests retried after an error
+	Skipped int // Links not followed as they are disallowed by robots.txt
+	Duplicates int // New URLs left out as the job had already queued them
+	Errors map[ErrorCode]int // Number of results for each error code
+}
+
+// Top level URLs to add to an existing job
+type URLSubmission struct {
+	Job string
+	URLs []string
+}
+
+// What the coordinator knows about a node
+type NodeStatus struct {
+	URL string
+	State string // ready, busy, draining or dead
+	FirstSeen time.Time
+	LastSeen time.Time // Last time the node sent a heartbeat or was ready
+	BatchesDone int
+	Results int // Number of results received from the node
+	Heartbeat Heartbeat // The last heartbeat of the node
+	Config *NodeConfig // nil if the node did not report its settings
+}
+
+// The state of the coordinator
+type Status struct {
+	Version int
+	Started time.Time
+	Paused bool // Whether dispatching batches has been paused
+	Jobs []JobStatus
+	Nodes []NodeStatus
+}
+
+// Sent by a node when it is ready to receive a batch. Its URL field is that
+// of Node, so that coordinators that expect a Node still understand it, and
+// conversely.
+type Ready struct {
+	URL string
+	Version int
+	Config *NodeConfig // nil for nodes that predate configuration
+}
+
+// The node that sent the message
+func (r Ready) Node() Node {
+	return Node{URL: r.URL}
+}
+
+// A page to scrape
+type Request struct {
+	URL string
+	TopLevel bool // Whether the URL is one of the URLs to scrape, as opposed to a link found on a page
+	Job string // Name of the job of the request. Nodes follow its spec if it is in the batch, and their own settings otherwise.
+	Depth int // Number of links followed from a top level page to reach the URL. Links are extracted from pages below the maximum depth.
+}
+
+// Identifies a request among the requests of a batch, as the same URL can be requested by multiple jobs
+func (r Request) Key() string {
+	return RequestKey(r.Job, r.URL)
+}
+
+func RequestKey(job string, url string) string {
+	if job == "" {
+		return url
+	}
+	return job + " " + url
+}
+
+// How pages are scraped for a job, decided by the coordinator. It overrides
+// the corresponding settings of the nodes.
+type JobSpec struct {
+	Name string `toml:"name"`
+	TimeoutSeconds int `toml:"timeout_seconds"` // Time given to a page to load
+	URLsToExtract int `toml:"urls_to_extract"` // Number of links followed from each page
+	MaxDepth int `toml:"max_depth"` // Links are followed up to this many links away from top level pages
+	LinkSelection string `toml:"link_selection"` // How the links followed are selected, one of LinkSelections
+	LinkPatterns []string `toml:"link_patterns"` // Regular expressions matched against the path of links ignoring case, preferred by the patterns selection
+	LinkSeed int64 `toml:"link_seed"` // Seed from which the seed of the random selection of each page is derived, 0 for a new seed for each page
+	PreventHeadlessDetection bool `toml:"prevent_headless_detection"`
+	Proxy string `toml:"proxy"` // Proxy through which requests are performed, empty to use the setting of the node
+	PostLoadWaitSeconds int `toml:"post_load_wait_seconds"` // Time to wait for scripts after the page has loaded
+	Robots string `toml:"robots"` // Whether links disallowed by robots.txt are skipped (respect), only recorded (record) or followed (ignore), empty for the setting of the coordinator
+}
+
+// How the links followed from a page are selected among its links to the same domain
+const (
+	LinkSelectionRandom = "random" // At random
+	LinkSelectionFirst = "first" // The first ones, in the order of the page
+	LinkSelectionPatterns = "patterns" // Those whose path matches one of the link patterns first, then at random
+	LinkSelectionUnseen = "unseen" // Those the node has not selected before first, then at random
+)
+
+var LinkSelections = []string{LinkSelectionRandom, LinkSelectionFirst, LinkSelectionPatterns, LinkSelectionUnseen}
+
+// The link patterns used when none are given, matching pages likely to ship WebAssembly
+var DefaultLinkPatterns = []string{"/game", "/play", "/editor"}
+
+// Check that a link selection is known and that its link patterns are valid regular expressions
+func ValidateLinkSelection(selection string, patterns []string) error {
+	known := false
+	for _, valid := range LinkSelections {
+		known = known || selection == valid
+	}
+	if !known {
+		return fmt.Errorf("link_selection must be one of %s, got %q", strings.Join(LinkSelections, ", "), selection)
+	}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid link pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Why a page could not be scraped
+type ErrorCode string
+
+const (
+	ErrorNone ErrorCode = "" // The page has been scraped
+	ErrorNavigationTimeout ErrorCode = "navigation_timeout" // The page did not load in time
+	ErrorPostLoadTimeout ErrorCode = "post_load_timeout" // The page loaded, but the time was up while waiting for its scripts
+	ErrorNameNotResolved ErrorCode = "name_not_resolved" // The host name could not be resolved
+	ErrorConnectionRefused ErrorCode = "connection_refused"
+	ErrorConnectionReset ErrorCode = "connection_reset" // The connection was reset or closed by the server
+	ErrorConnectionTimedOut ErrorCode = "connection_timed_out"
+	ErrorAddressUnreachable ErrorCode = "address_unreachable"
+	ErrorTLS ErrorCode = "tls_error" // The TLS handshake failed
+	ErrorCertInvalid ErrorCode = "cert_invalid" // The certificate of the server is invalid
+	ErrorAborted ErrorCode = "aborted" // The navigation was aborted, e.g. by a download
+	ErrorTooManyRedirects ErrorCode = "too_many_redirects"
+	ErrorNet ErrorCode = "net_error" // Any other network error reported by Chrome
+	ErrorHTTP ErrorCode = "http_error" // The main document has an HTTP error status, the page is still scraped
+	ErrorBrowserCrash ErrorCode = "browser_crash" // The tab or the browser crashed
+	ErrorExtraction ErrorCode = "extraction_error" // The page loaded but its links could not be extracted
+	ErrorOther ErrorCode = "other"
+)
+
+// All error codes
+var ErrorCodes = []ErrorCode{
+	ErrorNavigationTimeout, ErrorPostLoadTimeout, ErrorNameNotResolved, ErrorConnectionRefused, ErrorConnectionReset,
+	ErrorConnectionTimedOut, ErrorAddressUnreachable, ErrorTLS, ErrorCertInvalid, ErrorAborted, ErrorTooManyRedirects,
+	ErrorNet, ErrorHTTP, ErrorBrowserCrash, ErrorExtraction, ErrorOther,
+}
+
+// The error codes that may not happen again when the request is performed
+// again, as opposed to errors such as a host name that does not exist
+var TransientErrorCodes = []ErrorCode{
+	ErrorNavigationTimeout, ErrorPostLoadTimeout, ErrorConnectionRefused, ErrorConnectionReset, ErrorConnectionTimedOut,
+	ErrorAddressUnreachable, ErrorNet, ErrorBrowserCrash, ErrorOther,
+}
+
+// The result of scraping a page
+type Result struct {
+	URL string // The URL of the request
+	Job string // The job of the request
+	Timeout bool // The page did not load in time
+	DNSError bool // The host name could not be resolved
+	Failure bool // Any other error
+	Error ErrorCode // What went wrong, Timeout, DNSError and Failure being derived from it
+	ErrorDetail string // The error reported by the browser, if any
+	HTTPStatus int // Status code of the main document, 0 if unknown
+	Scripts []string // URLs of the WebAssembly scripts found on the page
+	URLs []string // Links to follow, only for pages below the maximum depth
+	LinkSeed int64 // Seed with which the links have been selected at random, 0 if they were not
+	Modules []Module // The WebAssembly modules found on the page, in the same order as Scripts
+}
+
+func (r Result) Key() string {
+	return RequestKey(r.Job, r.URL)
+}
+
+// Record the error of a result, along with the corresponding flag
+func (r *Result) SetError(code ErrorCode, detail string) {
+	r.Error = code
+	r.ErrorDetail = detail
+	switch code {
+	case ErrorNone, ErrorHTTP:
+	case ErrorNavigationTimeout, ErrorPostLoadTimeout:
+		r.Timeout = true
+	case ErrorNameNotResolved:
+		r.DNSError = true
+	default:
+		r.Failure = true
+	}
+}
+
+// A WebAssembly module found on a page
+type Module struct {
+	URL string // The URL of the script, may be empty or a blob: URL
+	Hash string // Hex-encoded SHA-256 of the bytecode, empty if it could not be retrieved
+	Size int // Size of the bytecode, in bytes
+	Loader string // Hex-encoded SHA-256 of the JavaScript that instantiated the module, empty if unknown
+	LoaderURL string // The URL of that JavaScript, empty if unknown or inline
+}
+
+// Kinds of blobs
+const (
+	BlobWasm = "wasm" // WebAssembly bytecode
+	BlobJS = "js" // JavaScript source
+)
+
+// The content of a blob, uploaded by a node if the server does not have it yet
+type BlobUpload struct {
+	Node Node
+	Kind string
+	Hash string // Hex-encoded SHA-256 of Data
+	Data []byte
+}
+
+// Metadata of a stored blob
+type BlobInfo struct {
+	Hash string
+	Kind string
+	Size int
+	FirstSeen time.Time // When the blob was stored
+	Pages int // Number of pages on which the blob has been found
+	Domains int // Number of domains on which the blob has been found
+}
+
+// A batch of requests sent to a node
+type Batch struct {
+	Version int
+	ID int // Identifier of the batch, given back in the BatchResult
+	Requests []Request
+	Jobs []JobSpec // The specs of the jobs of the requests
+}
+
+// The results of a batch, sent by a node
+type BatchResult struct {
+	Version int
+	BatchID int // Identifier of the batch, 0 if unknown
+	Results []Result
+	Node Node
+	NotQueried []Request // Requests that have not been performed, if the node is shutting down
+}
+
+// Periodic message sent by the nodes to the coordinator
+type Heartbeat struct {
+	Version int
+	Node Node
+	Workers int // Number of workers of the node
+	BusyWorkers int // Number of workers currently performing a request
+	BatchSize int // Number of requests in the current batch, 0 if there is none
+	BatchDone int // Number of requests of the current batch that have been performed
+	Draining bool // Whether the node is shutting down after its current batch
+}
+
+// Whether a message of the given version can be understood by this build
+func Compatible(version int) bool {
+	return version == ProtocolVersion
+}
+
+// The error returned to the sender of a message whose version is not compatible
+type IncompatibleVersion struct {
+	Version int // The version of the message
+	Expected int // The version of the receiver
+}
+
+const incompatibleFormat = "incompatible protocol version %d, expected %d"
+
+func (e IncompatibleVersion) Error() string {
+	return fmt.Sprintf(incompatibleFormat, e.Version, e.Expected)
+}
+
+// The error to return for a message of the given version, nil if it is compatible
+func CheckVersion(version int) error {
+	if Compatible(version) {
+		return nil
+	}
+	return IncompatibleVersion{version, ProtocolVersion}
+}
+
+// Whether an error is an IncompatibleVersion, possibly returned by an RPC
+// call, in which case it has been sent as its message (see rpc.ServerError)
+func IsIncompatible(err error) bool {
+	var incompatible IncompatibleVersion
+	if errors.As(err, &incompatible) {
+		return true
+	}
+	var remote rpc.ServerError
+	if !errors.As(err, &remote) {
+		return false
+	}
+	n, _ := fmt.Sscanf(string(remote), incompatibleFormat, &incompatible.Version, &incompatible.Expected)
+	return n == 2 && incompatible.Error() == string(remote)
+}
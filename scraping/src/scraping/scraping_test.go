@@ -0,0 +1,58 @@
+package scraping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+type Receiver int
+
+func (r *Receiver) Ready(args *Ready, reply *bool) error {
+	if err := CheckVersion((*args).Version); err != nil {
+		return err
+	}
+	if (*args).URL == "" {
+		return errors.New("no URL")
+	}
+	*reply = true
+	return nil
+}
+
+func TestIsIncompatible(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(new(Receiver))
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	tests := []struct {
+		ready Ready
+		incompatible bool
+		fails bool
+	}{
+		{Ready{URL: "127.0.0.1:7000", Version: ProtocolVersion}, false, false},
+		{Ready{URL: "127.0.0.1:7000", Version: ProtocolVersion - 1}, true, true},
+		{Ready{URL: "127.0.0.1:7000"}, true, true},
+		{Ready{Version: ProtocolVersion}, false, true},
+	}
+	for _, test := range tests {
+		var reply bool
+		err := client.Call("Receiver.Ready", test.ready, &reply)
+		if (err != nil) != test.fails || IsIncompatible(err) != test.incompatible {
+			t.Errorf("Ready %+v: error %v, expected incompatible %t", test.ready, err, test.incompatible)
+		}
+	}
+
+	if !IsIncompatible(fmt.Errorf("sending batch: %w", CheckVersion(0))) {
+		t.Errorf("wrapped local error not recognized")
+	}
+	for _, err := range []error{nil, errors.New("incompatible protocol version 2"), rpc.ServerError("incompatible protocol version 2, expected 3 at least")} {
+		if IsIncompatible(err) {
+			t.Errorf("%v recognized as incompatible", err)
+		}
+	}
+}